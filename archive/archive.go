@@ -0,0 +1,169 @@
+package archive
+
+import (
+	"archive/tar"
+	"fmt"
+	"golang.org/x/sys/unix"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// TarLayer 把容器的可写层打包为 OCI 镜像层，写入 w
+// AUFS 白障文件写为同名的空文件并跳过 AUFS 的内部数据；overlayfs 的白障（0/0 字符设备）和不透明目录
+// 转换为 .wh.<name> 和 .wh..wh..opq；文件属主按数字 ID 记录，层内的硬链接保持为硬链接
+func TarLayer(dir string, w io.Writer) error {
+	tw := tar.NewWriter(w)
+	// 已写入的硬链接文件，inode 到 tar 中的路径
+	links := map[uint64]string{}
+
+	err := filepath.Walk(dir, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, filePath)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+		name := filepath.ToSlash(rel)
+		base := path.Base(name)
+		stat, _ := info.Sys().(*syscall.Stat_t)
+
+		switch {
+		case strings.HasPrefix(base, WhiteoutMetaPrefix) && base != WhiteoutOpaqueDir:
+			// AUFS 内部数据，如 .wh..wh.aufs、.wh..wh.plnk、.wh..wh.orph
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		case strings.HasPrefix(base, WhiteoutPrefix):
+			// AUFS 的白障可能是指向内部文件的硬链接，统一写为空文件
+			return writeWhiteout(tw, name)
+		case info.Mode()&os.ModeCharDevice != 0 && stat != nil && stat.Rdev == 0:
+			// overlayfs 的白障
+			return writeWhiteout(tw, path.Join(path.Dir(name), WhiteoutPrefix+base))
+		}
+
+		if err := addFile(tw, links, filePath, name, info); err != nil {
+			return err
+		}
+
+		// overlayfs 的不透明目录
+		if info.IsDir() && isOverlayOpaque(filePath) {
+			return writeWhiteout(tw, path.Join(name, WhiteoutOpaqueDir))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+// addFile 把一个文件（目录只写入目录本身）写入 tar 包，属主按数字 ID 记录
+// links 记录已写入的多链接文件，inode 到 tar 中的路径，同一 inode 的文件再次出现时写为硬链接
+func addFile(tw *tar.Writer, links map[uint64]string, filePath, name string, info os.FileInfo) error {
+	var err error
+	link := ""
+	if info.Mode()&os.ModeSymlink != 0 {
+		if link, err = os.Readlink(filePath); err != nil {
+			return err
+		}
+	}
+	hdr, err := tar.FileInfoHeader(info, link)
+	if err != nil {
+		return err
+	}
+	hdr.Name = name
+	if info.IsDir() {
+		hdr.Name += "/"
+	}
+	hdr.Uname, hdr.Gname = "", ""
+	if err := addXattrs(hdr, filePath); err != nil {
+		return err
+	}
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		hdr.Uid, hdr.Gid = int(stat.Uid), int(stat.Gid)
+		if info.Mode()&os.ModeDevice != 0 {
+			hdr.Devmajor, hdr.Devminor = int64(unix.Major(stat.Rdev)), int64(unix.Minor(stat.Rdev))
+		}
+		if info.Mode().IsRegular() && stat.Nlink > 1 {
+			if target, ok := links[stat.Ino]; ok {
+				hdr.Typeflag = tar.TypeLink
+				hdr.Linkname = target
+				hdr.Size = 0
+			} else {
+				links[stat.Ino] = name
+			}
+		}
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+
+	if hdr.Typeflag == tar.TypeReg && hdr.Size > 0 {
+		f, err := os.Open(filePath)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(tw, f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("copy %s error %v", filePath, err)
+		}
+	}
+	return nil
+}
+
+// addXattrs 把文件的扩展属性记录到 PAX 扩展头，例如文件能力 security.capability
+// overlayfs 的内部属性（trusted.overlay.*）不属于镜像层；文件系统不支持扩展属性时忽略
+func addXattrs(hdr *tar.Header, filePath string) error {
+	size, err := unix.Llistxattr(filePath, nil)
+	if err != nil || size == 0 {
+		return nil
+	}
+	buf := make([]byte, size)
+	if size, err = unix.Llistxattr(filePath, buf); err != nil {
+		return nil
+	}
+	for _, name := range strings.Split(strings.TrimRight(string(buf[:size]), "\x00"), "\x00") {
+		if name == "" || strings.HasPrefix(name, "trusted.overlay.") {
+			continue
+		}
+		vsize, err := unix.Lgetxattr(filePath, name, nil)
+		if err != nil {
+			continue
+		}
+		value := make([]byte, vsize)
+		if vsize, err = unix.Lgetxattr(filePath, name, value); err != nil {
+			return fmt.Errorf("get xattr %s of %s error %v", name, filePath, err)
+		}
+		if hdr.PAXRecords == nil {
+			hdr.PAXRecords = map[string]string{}
+		}
+		hdr.PAXRecords[xattrPrefix+name] = string(value[:vsize])
+	}
+	return nil
+}
+
+// writeWhiteout 写入一个 OCI 白障文件
+func writeWhiteout(tw *tar.Writer, name string) error {
+	return tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     0600,
+	})
+}
+
+// isOverlayOpaque 判断目录是否被 overlayfs 标记为不透明
+func isOverlayOpaque(dir string) bool {
+	value := make([]byte, 1)
+	n, err := unix.Lgetxattr(dir, OverlayOpaqueXattr, value)
+	return err == nil && n == 1 && value[0] == 'y'
+}
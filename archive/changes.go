@@ -0,0 +1,186 @@
+package archive
+
+import (
+	"archive/tar"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+)
+
+// Change 是目录相对于镜像各层合并结果的一处改动，Path 为 / 分隔的相对路径
+type Change struct {
+	Path    string
+	Deleted bool
+}
+
+// lowerEntry 是镜像各层合并后的一个文件及其所在的层目录
+type lowerEntry struct {
+	info os.FileInfo
+	path string
+}
+
+// Changes 比较 dir 与镜像各层（OCI 白障形式，按从下到上的顺序）合并后的结果，返回新增、修改和删除的文件
+// 用于没有联合文件系统的 vfs 驱动：容器的根文件系统是各层的完整副本，只能逐个文件比较
+// 被删除的目录只返回目录本身，其中的文件不再单独列出
+func Changes(layers []string, dir string) ([]Change, error) {
+	lower, err := mergeLayers(layers)
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []Change
+	seen := map[string]bool{}
+	err = filepath.Walk(dir, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, filePath)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+		name := filepath.ToSlash(rel)
+		seen[name] = true
+		if entry, ok := lower[name]; !ok || changed(entry, info, filePath) {
+			changes = append(changes, Change{Path: name})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for name := range lower {
+		if seen[name] {
+			continue
+		}
+		// 父目录也被删除时只记录父目录
+		if parent := path.Dir(name); parent == "." || seen[parent] {
+			changes = append(changes, Change{Path: name, Deleted: true})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	return changes, nil
+}
+
+// mergeLayers 从下到上依次应用各层，得到合并后的文件列表
+// 每层先处理白障再加入该层的文件，不透明目录和白障只隐藏下层的内容
+func mergeLayers(layers []string) (map[string]lowerEntry, error) {
+	merged := map[string]lowerEntry{}
+	for _, layer := range layers {
+		var entries []string
+		var whiteouts []string
+		err := filepath.Walk(layer, func(filePath string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(layer, filePath)
+			if err != nil {
+				return err
+			}
+			if rel == "." {
+				return nil
+			}
+			name := filepath.ToSlash(rel)
+			base := path.Base(name)
+			switch {
+			case base == WhiteoutOpaqueDir:
+				whiteouts = append(whiteouts, name)
+			case strings.HasPrefix(base, WhiteoutMetaPrefix):
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
+			case strings.HasPrefix(base, WhiteoutPrefix):
+				whiteouts = append(whiteouts, name)
+			default:
+				entries = append(entries, name)
+				merged[name] = lowerEntry{info: info, path: filePath}
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		// 先记录本层的文件，再按白障删除下层的文件时跳过本层的文件
+		current := map[string]bool{}
+		for _, name := range entries {
+			current[name] = true
+		}
+		for _, wh := range whiteouts {
+			dir, base := path.Dir(wh), path.Base(wh)
+			if base == WhiteoutOpaqueDir {
+				prefix := dir + "/"
+				if dir == "." {
+					prefix = ""
+				}
+				for name := range merged {
+					if strings.HasPrefix(name, prefix) && name != dir && !current[name] {
+						delete(merged, name)
+					}
+				}
+				continue
+			}
+			target := path.Join(dir, strings.TrimPrefix(base, WhiteoutPrefix))
+			for name := range merged {
+				if (name == target || strings.HasPrefix(name, target+"/")) && !current[name] {
+					delete(merged, name)
+				}
+			}
+		}
+	}
+	return merged, nil
+}
+
+// changed 判断文件相对于下层的同名文件是否有改动：类型、权限、属主、符号链接目标、设备号，
+// 普通文件还比较大小和修改时间，目录比较修改时间（目录中增删文件会更新目录的修改时间）
+func changed(entry lowerEntry, info os.FileInfo, filePath string) bool {
+	old := entry.info
+	if old.Mode() != info.Mode() {
+		return true
+	}
+	oldStat, ok1 := old.Sys().(*syscall.Stat_t)
+	stat, ok2 := info.Sys().(*syscall.Stat_t)
+	if ok1 && ok2 && (oldStat.Uid != stat.Uid || oldStat.Gid != stat.Gid || oldStat.Rdev != stat.Rdev) {
+		return true
+	}
+	switch {
+	case info.Mode()&os.ModeSymlink != 0:
+		oldLink, _ := os.Readlink(entry.path)
+		link, _ := os.Readlink(filePath)
+		return oldLink != link
+	case info.Mode().IsRegular():
+		return old.Size() != info.Size() || !old.ModTime().Equal(info.ModTime())
+	case info.IsDir():
+		return !old.ModTime().Equal(info.ModTime())
+	}
+	return false
+}
+
+// TarChanges 把 dir 中的改动打包为 OCI 镜像层写入 w，删除的文件写为白障
+func TarChanges(dir string, changes []Change, w io.Writer) error {
+	tw := tar.NewWriter(w)
+	links := map[uint64]string{}
+	for _, change := range changes {
+		if change.Deleted {
+			if err := writeWhiteout(tw, path.Join(path.Dir(change.Path), WhiteoutPrefix+path.Base(change.Path))); err != nil {
+				return err
+			}
+			continue
+		}
+		filePath := filepath.Join(dir, filepath.FromSlash(change.Path))
+		info, err := os.Lstat(filePath)
+		if err != nil {
+			return err
+		}
+		if err := addFile(tw, links, filePath, change.Path, info); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
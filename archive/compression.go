@@ -0,0 +1,94 @@
+package archive
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+	"io"
+)
+
+// Compression 是 tar 包的压缩格式
+type Compression int
+
+const (
+	Uncompressed Compression = iota
+	Gzip
+	Bzip2
+	Xz
+	Zstd
+)
+
+func (c Compression) String() string {
+	switch c {
+	case Gzip:
+		return "gzip"
+	case Bzip2:
+		return "bzip2"
+	case Xz:
+		return "xz"
+	case Zstd:
+		return "zstd"
+	}
+	return "uncompressed"
+}
+
+var (
+	gzipMagic  = []byte{0x1f, 0x8b}
+	bzip2Magic = []byte("BZh")
+	xzMagic    = []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}
+	zstdMagic  = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// DetectCompression 根据文件头判断压缩格式
+func DetectCompression(header []byte) Compression {
+	switch {
+	case bytes.HasPrefix(header, gzipMagic):
+		return Gzip
+	case bytes.HasPrefix(header, bzip2Magic):
+		return Bzip2
+	case bytes.HasPrefix(header, xzMagic):
+		return Xz
+	case bytes.HasPrefix(header, zstdMagic):
+		return Zstd
+	}
+	return Uncompressed
+}
+
+// IsTar 判断未压缩的数据是否以 tar 文件头开始
+func IsTar(header []byte) bool {
+	return len(header) >= 262 && bytes.HasPrefix(header[257:], []byte("ustar"))
+}
+
+// DecompressStream 自动识别 gzip、bzip2、xz、zstd 压缩并返回解压后的数据流，未压缩的数据原样返回
+func DecompressStream(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	// Peek 在数据不足 10 字节时返回错误，此时按已读到的部分判断
+	header, _ := br.Peek(10)
+	switch compression := DetectCompression(header); compression {
+	case Gzip:
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, fmt.Errorf("open %s stream error %v", compression, err)
+		}
+		return gz, nil
+	case Bzip2:
+		return io.NopCloser(bzip2.NewReader(br)), nil
+	case Xz:
+		xr, err := xz.NewReader(br)
+		if err != nil {
+			return nil, fmt.Errorf("open %s stream error %v", compression, err)
+		}
+		return io.NopCloser(xr), nil
+	case Zstd:
+		zr, err := zstd.NewReader(br)
+		if err != nil {
+			return nil, fmt.Errorf("open %s stream error %v", compression, err)
+		}
+		return zr.IOReadCloser(), nil
+	}
+	return io.NopCloser(br), nil
+}
@@ -0,0 +1,33 @@
+package archive
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+)
+
+// verifyingReader 在读取的同时计算 sha256 摘要，读到末尾时与期望的摘要比较
+type verifyingReader struct {
+	r        io.Reader
+	hash     hash.Hash
+	expected string
+}
+
+// NewVerifyingReader 返回校验 sha256 摘要的 Reader，读到末尾时摘要不符返回错误而不是 io.EOF
+// expected 为 sha256:<hex> 形式；Untar 会读完 tar 包末尾的填充，因此解压的同时即可完成校验
+func NewVerifyingReader(r io.Reader, expected string) io.Reader {
+	return &verifyingReader{r: r, hash: sha256.New(), expected: expected}
+}
+
+func (v *verifyingReader) Read(p []byte) (int, error) {
+	n, err := v.r.Read(p)
+	v.hash.Write(p[:n])
+	if err == io.EOF {
+		if actual := "sha256:" + hex.EncodeToString(v.hash.Sum(nil)); actual != v.expected {
+			return n, fmt.Errorf("digest mismatch: expected %s, got %s", v.expected, actual)
+		}
+	}
+	return n, err
+}
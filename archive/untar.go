@@ -0,0 +1,200 @@
+package archive
+
+import (
+	"archive/tar"
+	"fmt"
+	"golang.org/x/sys/unix"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// xattrPrefix 是 PAX 扩展头中记录扩展属性的键前缀
+const xattrPrefix = "SCHILY.xattr."
+
+// Untar 把 tar 包（可以是 gzip、bzip2、xz、zstd 压缩的）解压到目录 dest
+// 属主按数字 ID 还原，保留权限、修改时间、扩展属性、硬链接和设备文件；白障文件原样解压，由存储驱动转换
+// 路径经过 ..、绝对路径的硬链接目标或已解压的符号链接指向 dest 之外的条目会被拒绝
+// 解压完成后读完剩余的数据，使 NewVerifyingReader 能在解压的同时校验摘要
+func Untar(r io.Reader, dest string) error {
+	stream, err := DecompressStream(r)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+	if err := os.MkdirAll(dest, 0755); err != nil {
+		return err
+	}
+
+	// 目录的修改时间在解压完其中的文件后再设置
+	type dirTime struct {
+		path  string
+		mtime time.Time
+	}
+	var dirTimes []dirTime
+
+	tr := tar.NewReader(stream)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("read tar error %v", err)
+		}
+		if hdr.Typeflag == tar.TypeXGlobalHeader {
+			continue
+		}
+		target, err := securePath(dest, hdr.Name)
+		if err != nil {
+			return err
+		}
+		if target == dest {
+			// 根目录本身只还原属性
+			if hdr.Typeflag == tar.TypeDir {
+				if err := restoreAttrs(target, hdr); err != nil {
+					return err
+				}
+				dirTimes = append(dirTimes, dirTime{target, hdr.ModTime})
+			}
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		// 类型不同的同名文件先删除；目录与目录合并
+		if existing, err := os.Lstat(target); err == nil && !(existing.IsDir() && hdr.Typeflag == tar.TypeDir) {
+			if err := os.RemoveAll(target); err != nil {
+				return err
+			}
+		}
+
+		if err := createEntry(tr, hdr, dest, target); err != nil {
+			return fmt.Errorf("extract %s error %v", hdr.Name, err)
+		}
+		if hdr.Typeflag == tar.TypeLink {
+			// 硬链接与目标共享 inode，属性随目标
+			continue
+		}
+		if err := restoreAttrs(target, hdr); err != nil {
+			return fmt.Errorf("extract %s error %v", hdr.Name, err)
+		}
+		if hdr.Typeflag == tar.TypeDir {
+			dirTimes = append(dirTimes, dirTime{target, hdr.ModTime})
+		}
+	}
+	for i := len(dirTimes) - 1; i >= 0; i-- {
+		if err := os.Chtimes(dirTimes[i].path, dirTimes[i].mtime, dirTimes[i].mtime); err != nil {
+			return err
+		}
+	}
+
+	// 读完 tar 包末尾的填充和压缩流的剩余部分
+	if _, err := io.Copy(io.Discard, stream); err != nil {
+		return err
+	}
+	return nil
+}
+
+// securePath 把 tar 包中的路径转换为 dest 中的路径
+// 开头的 / 视为相对于 dest；经过 .. 跳出 dest 或者经过已存在的符号链接的路径返回错误
+func securePath(dest, name string) (string, error) {
+	clean := path.Clean(strings.TrimLeft(name, "/"))
+	if clean == ".." || strings.HasPrefix(clean, "../") {
+		return "", fmt.Errorf("unsafe path %q in archive", name)
+	}
+	if clean == "." || clean == "" {
+		return dest, nil
+	}
+	// 父目录中不能有符号链接，否则条目可能被写到符号链接指向的 dest 之外的位置
+	current := dest
+	parts := strings.Split(clean, "/")
+	for _, part := range parts[:len(parts)-1] {
+		current = filepath.Join(current, part)
+		info, err := os.Lstat(current)
+		if os.IsNotExist(err) {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return "", fmt.Errorf("unsafe path %q in archive: %s is a symlink", name, strings.TrimPrefix(current, dest))
+		}
+	}
+	return filepath.Join(dest, filepath.FromSlash(clean)), nil
+}
+
+// createEntry 按条目类型创建文件
+func createEntry(tr *tar.Reader, hdr *tar.Header, dest, target string) error {
+	mode := uint32(hdr.Mode & 07777)
+	switch hdr.Typeflag {
+	case tar.TypeDir:
+		if err := os.Mkdir(target, 0700); err != nil && !os.IsExist(err) {
+			return err
+		}
+	case tar.TypeReg, tar.TypeRegA:
+		f, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(f, tr); err != nil {
+			f.Close()
+			return err
+		}
+		return f.Close()
+	case tar.TypeLink:
+		// 硬链接的目标必须是本次已解压到 dest 中的文件
+		source, err := securePath(dest, hdr.Linkname)
+		if err != nil {
+			return err
+		}
+		if source == dest {
+			return fmt.Errorf("unsafe hardlink target %q", hdr.Linkname)
+		}
+		return os.Link(source, target)
+	case tar.TypeSymlink:
+		// 符号链接的内容不检查：之后经过它的条目会被 securePath 拒绝
+		return os.Symlink(hdr.Linkname, target)
+	case tar.TypeChar:
+		return unix.Mknod(target, unix.S_IFCHR|mode, int(unix.Mkdev(uint32(hdr.Devmajor), uint32(hdr.Devminor))))
+	case tar.TypeBlock:
+		return unix.Mknod(target, unix.S_IFBLK|mode, int(unix.Mkdev(uint32(hdr.Devmajor), uint32(hdr.Devminor))))
+	case tar.TypeFifo:
+		return unix.Mkfifo(target, mode)
+	default:
+		return fmt.Errorf("unsupported tar entry type %q", hdr.Typeflag)
+	}
+	return nil
+}
+
+// restoreAttrs 还原属主、扩展属性、权限和修改时间
+// chown 会清除 setuid/setgid 位，权限在 chown 之后设置；符号链接没有自己的权限
+func restoreAttrs(target string, hdr *tar.Header) error {
+	if err := os.Lchown(target, hdr.Uid, hdr.Gid); err != nil {
+		return err
+	}
+	for key, value := range hdr.PAXRecords {
+		if !strings.HasPrefix(key, xattrPrefix) {
+			continue
+		}
+		name := strings.TrimPrefix(key, xattrPrefix)
+		if err := unix.Lsetxattr(target, name, []byte(value), 0); err != nil && err != unix.ENOTSUP {
+			return fmt.Errorf("set xattr %s error %v", name, err)
+		}
+	}
+	if hdr.Typeflag == tar.TypeSymlink {
+		ts := []unix.Timespec{unix.NsecToTimespec(hdr.ModTime.UnixNano()), unix.NsecToTimespec(hdr.ModTime.UnixNano())}
+		return unix.UtimesNanoAt(unix.AT_FDCWD, target, ts, unix.AT_SYMLINK_NOFOLLOW)
+	}
+	if err := os.Chmod(target, hdr.FileInfo().Mode()); err != nil {
+		return err
+	}
+	if hdr.Typeflag == tar.TypeDir {
+		return nil
+	}
+	return os.Chtimes(target, hdr.ModTime, hdr.ModTime)
+}
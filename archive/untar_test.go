@@ -0,0 +1,197 @@
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// entry 是测试用 tar 包中的一个条目，body 为普通文件的内容
+type entry struct {
+	hdr  tar.Header
+	body string
+}
+
+// buildTar 按顺序把条目写入 tar 包，属主为当前用户
+func buildTar(t *testing.T, entries []entry) []byte {
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+	for _, e := range entries {
+		hdr := e.hdr
+		if hdr.Mode == 0 {
+			hdr.Mode = 0644
+		}
+		hdr.Uid, hdr.Gid = os.Getuid(), os.Getgid()
+		if hdr.Typeflag == tar.TypeReg {
+			hdr.Size = int64(len(e.body))
+		}
+		if err := tw.WriteHeader(&hdr); err != nil {
+			t.Fatal(err)
+		}
+		if e.body != "" {
+			if _, err := tw.Write([]byte(e.body)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func file(name, body string) entry {
+	return entry{tar.Header{Typeflag: tar.TypeReg, Name: name}, body}
+}
+
+func dir(name string) entry {
+	return entry{tar.Header{Typeflag: tar.TypeDir, Name: name, Mode: 0755}, ""}
+}
+
+func symlink(name, target string) entry {
+	return entry{tar.Header{Typeflag: tar.TypeSymlink, Name: name, Linkname: target}, ""}
+}
+
+func hardlink(name, target string) entry {
+	return entry{tar.Header{Typeflag: tar.TypeLink, Name: name, Linkname: target}, ""}
+}
+
+// 指向 dest 之外的条目必须被拒绝，并且 dest 之外不能出现任何写入
+func TestUntarRejectsUnsafeEntries(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []entry
+	}{
+		{"parent directory", []entry{file("../escape", "x")}},
+		{"nested parent directory", []entry{dir("a"), file("a/../../escape", "x")}},
+		{"absolute hardlink target", []entry{hardlink("passwd", "/escape")}},
+		{"hardlink target outside", []entry{hardlink("passwd", "../escape")}},
+		{"hardlink to dest", []entry{hardlink("root", "/")}},
+		{"write through symlink", []entry{symlink("link", ".."), file("link/escape", "x")}},
+		{"write through absolute symlink", []entry{symlink("link", "/"), file("link/escape", "x")}},
+		{"hardlink through symlink", []entry{file("secret", "x"), symlink("link", ".."), hardlink("link/escape", "secret")}},
+		{"symlink in nested path", []entry{dir("a"), symlink("a/b", "../.."), dir("a/b/escape")}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parent := t.TempDir()
+			dest := filepath.Join(parent, "dest")
+			err := Untar(bytes.NewReader(buildTar(t, tt.entries)), dest)
+			if err == nil {
+				t.Fatal("Untar succeeded, want an error")
+			}
+			if _, err := os.Lstat(filepath.Join(parent, "escape")); !os.IsNotExist(err) {
+				t.Errorf("entry was written outside dest: %v", err)
+			}
+		})
+	}
+}
+
+// 绝对路径的硬链接目标相对于 dest 解析，不能链接到宿主机上存在的同名文件
+func TestUntarAbsoluteHardlinkTarget(t *testing.T) {
+	parent := t.TempDir()
+	outside := filepath.Join(parent, "outside")
+	if err := ioutil.WriteFile(outside, []byte("secret"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	dest := filepath.Join(parent, "dest")
+	if err := Untar(bytes.NewReader(buildTar(t, []entry{hardlink("stolen", outside)})), dest); err == nil {
+		t.Fatal("Untar succeeded, want an error")
+	}
+	if _, err := os.Lstat(filepath.Join(dest, "stolen")); !os.IsNotExist(err) {
+		t.Errorf("hardlink to a host file was created: %v", err)
+	}
+}
+
+// 已解压的符号链接被同名的普通文件替换时，写入的是新文件而不是符号链接指向的文件
+func TestUntarReplacesSymlink(t *testing.T) {
+	parent := t.TempDir()
+	outside := filepath.Join(parent, "outside")
+	if err := ioutil.WriteFile(outside, []byte("original"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	dest := filepath.Join(parent, "dest")
+	content := buildTar(t, []entry{symlink("link", outside), file("link", "replaced")})
+	if err := Untar(bytes.NewReader(content), dest); err != nil {
+		t.Fatalf("Untar: %v", err)
+	}
+	if data, _ := ioutil.ReadFile(outside); string(data) != "original" {
+		t.Errorf("file outside dest was overwritten: %q", data)
+	}
+	info, err := os.Lstat(filepath.Join(dest, "link"))
+	if err != nil || !info.Mode().IsRegular() {
+		t.Errorf("link should be replaced by a regular file, got %v, %v", info, err)
+	}
+}
+
+func TestUntar(t *testing.T) {
+	dest := filepath.Join(t.TempDir(), "dest")
+	content := buildTar(t, []entry{
+		dir("/etc"),
+		file("/etc/passwd", "root:x:0:0"),
+		hardlink("etc/passwd.bak", "/etc/passwd"),
+		symlink("etc/link", "/etc/passwd"),
+		dir("./a/b/"),
+		file("a/b/../c", "c"),
+	})
+	buf := &bytes.Buffer{}
+	gw := gzip.NewWriter(buf)
+	gw.Write(content)
+	gw.Close()
+
+	if err := Untar(buf, dest); err != nil {
+		t.Fatalf("Untar: %v", err)
+	}
+	for name, want := range map[string]string{"etc/passwd": "root:x:0:0", "etc/passwd.bak": "root:x:0:0", "a/c": "c"} {
+		if data, err := ioutil.ReadFile(filepath.Join(dest, name)); err != nil || string(data) != want {
+			t.Errorf("%s = %q, %v, want %q", name, data, err, want)
+		}
+	}
+	a, _ := os.Stat(filepath.Join(dest, "etc/passwd"))
+	b, _ := os.Stat(filepath.Join(dest, "etc/passwd.bak"))
+	if !os.SameFile(a, b) {
+		t.Error("etc/passwd.bak should be a hardlink to etc/passwd")
+	}
+	// 符号链接的内容原样保留，在容器中解析
+	if target, err := os.Readlink(filepath.Join(dest, "etc/link")); err != nil || target != "/etc/passwd" {
+		t.Errorf("etc/link -> %q, %v, want /etc/passwd", target, err)
+	}
+}
+
+func TestVerifyingReader(t *testing.T) {
+	content := buildTar(t, []entry{file("a", strings.Repeat("a", 4096))})
+	sum := sha256.Sum256(content)
+	digest := "sha256:" + hex.EncodeToString(sum[:])
+	other := sha256.Sum256([]byte("other"))
+
+	tests := []struct {
+		name     string
+		expected string
+		wantErr  bool
+	}{
+		{"match", digest, false},
+		{"mismatch", "sha256:" + hex.EncodeToString(other[:]), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dest := filepath.Join(t.TempDir(), "dest")
+			err := Untar(NewVerifyingReader(bytes.NewReader(content), tt.expected), dest)
+			if tt.wantErr {
+				if err == nil || !strings.Contains(err.Error(), "digest mismatch") {
+					t.Fatalf("Untar error = %v, want digest mismatch", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Untar: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,13 @@
+package archive
+
+// OCI 镜像层用特殊文件名表示删除：.wh.<name> 表示下层的 <name> 被删除，
+// 目录中的 .wh..wh..opq 表示该目录是不透明的，下层目录中的内容全部被隐藏
+// AUFS 在可写层中使用相同的命名；.wh..wh. 开头的其他文件和目录是 AUFS 的内部数据，不属于镜像层
+const (
+	WhiteoutPrefix     = ".wh."
+	WhiteoutMetaPrefix = ".wh..wh."
+	WhiteoutOpaqueDir  = ".wh..wh..opq"
+)
+
+// OverlayOpaqueXattr 是 overlayfs 标记不透明目录的扩展属性，值为 y
+const OverlayOpaqueXattr = "trusted.overlay.opaque"
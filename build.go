@@ -0,0 +1,486 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"go-docker/archive"
+	"go-docker/container"
+	"go-docker/image"
+	"go-docker/storage"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// builder 保存 build 过程中的镜像状态
+type builder struct {
+	contextDir string          // 构建上下文目录
+	noCache    bool            // 是否禁用构建缓存
+	diffIDs    []string        // 当前的各层，按从下到上的顺序排列
+	config     image.Config    // 当前的运行配置
+	history    []image.History // 当前的构建历史
+	cacheKey   string          // 上一个生成镜像层的步骤的缓存键，基础镜像的 ID 作为初值
+	cmdSet     bool            // Dockerfile 中是否设置过 CMD
+	fromDone   bool            // 是否已经处理过 FROM
+}
+
+// buildImage 按照 Dockerfile 构建镜像并打上 tags 中的标签
+// 支持 FROM、RUN、COPY、ADD（仅本地文件）、ENV、WORKDIR、USER、CMD、ENTRYPOINT、EXPOSE 和 LABEL
+// RUN 在临时容器中执行；RUN、COPY 和 ADD 各生成一个镜像层，并按照指令和构建上下文的校验和缓存
+func buildImage(contextDir, dockerfile string, tags []string, noCache bool) error {
+	contextDir, err := filepath.Abs(contextDir)
+	if err != nil {
+		return err
+	}
+	if info, err := os.Stat(contextDir); err != nil || !info.IsDir() {
+		return fmt.Errorf("build context %s is not a directory", contextDir)
+	}
+	if dockerfile == "" {
+		dockerfile = filepath.Join(contextDir, "Dockerfile")
+	}
+	var refs []image.Reference
+	for _, tag := range tags {
+		ref, err := image.ParseReference(tag)
+		if err != nil {
+			return err
+		}
+		if ref.Digest != "" {
+			return fmt.Errorf("tag %s must be a name:tag reference", tag)
+		}
+		refs = append(refs, ref)
+	}
+
+	// 构建过程中生成的层在镜像配置写入之前没有引用，需要防止被垃圾回收
+	release, err := image.Lease()
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	f, err := os.Open(dockerfile)
+	if err != nil {
+		return err
+	}
+	instructions, err := image.ParseDockerfile(f)
+	f.Close()
+	if err != nil {
+		return err
+	}
+	if instructions[0].Command != "FROM" {
+		return fmt.Errorf("Dockerfile line %d: the first instruction must be FROM", instructions[0].Line)
+	}
+
+	b := &builder{contextDir: contextDir, noCache: noCache}
+	for i, inst := range instructions {
+		fmt.Fprintf(os.Stdout, "Step %d/%d : %s\n", i+1, len(instructions), inst.Original)
+		layers := len(b.diffIDs)
+		if err := b.dispatch(inst); err != nil {
+			return fmt.Errorf("Dockerfile line %d: %v", inst.Line, err)
+		}
+		// FROM 继承基础镜像的构建历史，其余每条指令记录一步，没有生成镜像层的指令标记为 empty_layer
+		if inst.Command != "FROM" {
+			b.history = append(b.history, image.History{
+				Created:    time.Now().UTC(),
+				CreatedBy:  inst.Original,
+				EmptyLayer: len(b.diffIDs) == layers,
+			})
+		}
+	}
+
+	img := image.NewImage(b.diffIDs, b.config)
+	img.History = b.history
+	id, err := image.CreateImage(img)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stdout, "Successfully built %s\n", image.ShortID(id))
+	for _, ref := range refs {
+		if err := image.Tag(id, ref); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stdout, "Successfully tagged %s\n", ref)
+	}
+	return nil
+}
+
+// dispatch 执行一条指令
+func (b *builder) dispatch(inst image.Instruction) error {
+	switch inst.Command {
+	case "FROM":
+		return b.from(inst.Args)
+	case "RUN":
+		return b.run(inst)
+	case "COPY":
+		return b.copy(inst, false)
+	case "ADD":
+		return b.copy(inst, true)
+	case "CMD":
+		b.cmdSet = true
+	case "ENTRYPOINT":
+		// 与 docker 一致，设置 ENTRYPOINT 时清除从基础镜像继承的 CMD
+		if !b.cmdSet {
+			b.config.Cmd = nil
+		}
+	case "ENV", "WORKDIR", "USER", "EXPOSE", "LABEL":
+	default:
+		return fmt.Errorf("unsupported instruction %s", inst.Command)
+	}
+	return image.ApplyChange(&b.config, inst.Original)
+}
+
+// from 以基础镜像的各层和运行配置作为初始状态，scratch 表示空镜像
+func (b *builder) from(args string) error {
+	if b.fromDone {
+		return fmt.Errorf("multi-stage builds are not supported")
+	}
+	b.fromDone = true
+	words, err := image.SplitWords(args)
+	if err != nil {
+		return err
+	}
+	if len(words) != 1 {
+		return fmt.Errorf("FROM requires exactly one argument, stage names are not supported")
+	}
+	if words[0] == "scratch" {
+		b.cacheKey = "scratch"
+		return nil
+	}
+	id, img, err := resolveImage(words[0])
+	if err != nil {
+		return err
+	}
+	b.diffIDs = append([]string{}, img.RootFS.DiffIDs...)
+	b.config = img.Config
+	b.history = append([]image.History{}, img.History...)
+	b.cacheKey = id
+	return nil
+}
+
+// run 在以当前各层为只读层的临时容器中执行命令，可写层中的改动作为新的镜像层
+func (b *builder) run(inst image.Instruction) error {
+	key := b.stepKey(inst, "")
+	if b.useCache(key) {
+		return nil
+	}
+
+	driver := storage.Default()
+	layers, err := image.LayerDirs(&image.Image{RootFS: image.RootFS{DiffIDs: b.diffIDs}}, driver)
+	if err != nil {
+		return err
+	}
+	containerID := randStringBytes(10)
+	containerName := "build-" + containerID
+	fmt.Fprintf(os.Stdout, " ---> Running in %s\n", containerID)
+
+	// 与宿主机共享网络，命令可以下载依赖；环境变量、工作目录和用户取自当前的运行配置
+	config := b.config
+	args := image.ParseCommand(inst.Args)
+	spec := &container.InitSpec{
+		Args: args,
+		Env:  config.Env,
+		Cwd:  config.WorkingDir,
+		User: config.User,
+	}
+	containerInfo := &container.ContainerInfo{
+		Id:           containerID,
+		Name:         containerName,
+		Tty:          true,
+		Namespaces:   container.NamespaceConfig{Net: container.NamespaceHost},
+		Entrypoint:   config.Entrypoint,
+		ConfigEnv:    config.Env,
+		ExposedPorts: exposedPortList(&config),
+		Driver:       driver.Name(),
+	}
+	cleanup := func() {
+		deleteContainerInfo(containerName)
+		deleteWorkSpace(driver, nil, containerName)
+	}
+	parent, cgroupManager, err := startContainer(containerInfo, spec, layers, false, cleanup)
+	if err != nil {
+		return err
+	}
+	// 无论命令是否成功都删除临时容器
+	defer func() {
+		cgroupManager.Destroy()
+		deleteContainerInfo(containerName)
+		deleteWorkSpace(driver, nil, containerName)
+	}()
+	if err := parent.Wait(); err != nil {
+		return fmt.Errorf("the command %q returned a non-zero code: %v", strings.Join(args, " "), err)
+	}
+
+	diffID, err := createLayerFromContainer(driver, containerName, layers)
+	if err != nil {
+		return err
+	}
+	return b.addLayer(key, diffID)
+}
+
+// copy 把构建上下文中的文件复制到镜像中，生成一个新的镜像层
+// extract 为 true 时（ADD）本地的 tar 包（可以是压缩的）被解压到目标目录
+func (b *builder) copy(inst image.Instruction, extract bool) error {
+	var args []string
+	if strings.HasPrefix(inst.Args, "[") {
+		if err := json.Unmarshal([]byte(inst.Args), &args); err != nil {
+			return fmt.Errorf("%s: invalid JSON array %v", inst.Command, err)
+		}
+	} else {
+		words, err := image.SplitWords(inst.Args)
+		if err != nil {
+			return err
+		}
+		args = words
+	}
+	if len(args) > 0 && strings.HasPrefix(args[0], "--") {
+		return fmt.Errorf("%s: flag %s is not supported", inst.Command, args[0])
+	}
+	if len(args) < 2 {
+		return fmt.Errorf("%s requires at least two arguments", inst.Command)
+	}
+	dest := args[len(args)-1]
+
+	// 展开构建上下文中的源文件，源文件不能位于构建上下文之外
+	var srcs []string
+	for _, src := range args[:len(args)-1] {
+		if strings.Contains(src, "://") {
+			return fmt.Errorf("%s: remote URL %s is not supported", inst.Command, src)
+		}
+		pattern := filepath.Join(b.contextDir, filepath.Clean("/"+src))
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return err
+		}
+		if len(matches) == 0 {
+			return fmt.Errorf("%s: %s: no such file or directory in the build context", inst.Command, src)
+		}
+		srcs = append(srcs, matches...)
+	}
+
+	checksum, err := contextChecksum(b.contextDir, srcs)
+	if err != nil {
+		return err
+	}
+	key := b.stepKey(inst, checksum)
+	if b.useCache(key) {
+		return nil
+	}
+
+	// 在临时目录中构造新层的内容
+	layerDir, err := ioutil.TempDir("", "mydocker-build-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(layerDir)
+	// 目标以 / 结尾、为 . 、有多个源文件或者是镜像中已有的目录时，源文件复制到该目录下
+	destIsDir := strings.HasSuffix(dest, "/") || dest == "." || strings.HasSuffix(dest, "/.") || len(srcs) > 1
+	if !path.IsAbs(dest) {
+		dest = path.Join("/", b.config.WorkingDir, dest)
+	}
+	if !destIsDir {
+		destIsDir = b.isDir(dest)
+	}
+	target := filepath.Join(layerDir, filepath.Clean(dest))
+	for _, src := range srcs {
+		info, err := os.Lstat(src)
+		if err != nil {
+			return err
+		}
+		switch {
+		case info.IsDir():
+			// 复制目录中的内容，而不是目录本身
+			err = copyTree(src, target)
+		case extract && info.Mode().IsRegular() && isArchive(src):
+			err = extractArchive(src, target)
+		case destIsDir:
+			err = copyTree(src, filepath.Join(target, filepath.Base(src)))
+		default:
+			err = copyTree(src, target)
+		}
+		if err != nil {
+			return fmt.Errorf("%s %s error %v", inst.Command, src, err)
+		}
+	}
+
+	diffID, err := createLayerFromDir(layerDir)
+	if err != nil {
+		return err
+	}
+	return b.addLayer(key, diffID)
+}
+
+// isDir 判断路径在当前各层合并后的文件系统中是否为目录，从上到下找到的第一个即为合并后的结果
+func (b *builder) isDir(p string) bool {
+	layers, err := image.LayerDirs(&image.Image{RootFS: image.RootFS{DiffIDs: b.diffIDs}}, storage.Default())
+	if err != nil {
+		return false
+	}
+	for i := len(layers) - 1; i >= 0; i-- {
+		if info, err := os.Lstat(filepath.Join(layers[i], p)); err == nil {
+			return info.IsDir()
+		}
+	}
+	return false
+}
+
+// stepKey 计算生成镜像层的步骤的缓存键
+// 由上一个缓存键、当前运行配置、指令原文和构建上下文的校验和决定，因此之前任何一步发生变化都会使之后的缓存失效
+func (b *builder) stepKey(inst image.Instruction, checksum string) string {
+	config, _ := json.Marshal(b.config)
+	hasher := sha256.New()
+	fmt.Fprintf(hasher, "%s\n%s\n%s\n%s", b.cacheKey, config, inst.Original, checksum)
+	return "sha256:" + hex.EncodeToString(hasher.Sum(nil))
+}
+
+// useCache 查找构建缓存，命中时直接使用缓存的镜像层
+func (b *builder) useCache(key string) bool {
+	if b.noCache {
+		return false
+	}
+	diffID, ok := image.GetBuildCache(key)
+	if !ok {
+		return false
+	}
+	fmt.Fprintln(os.Stdout, " ---> Using cache")
+	b.diffIDs = append(b.diffIDs, diffID)
+	b.cacheKey = key
+	return true
+}
+
+// addLayer 添加新生成的镜像层并记录到构建缓存
+func (b *builder) addLayer(key, diffID string) error {
+	if err := image.SetBuildCache(key, diffID); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stdout, " ---> %s\n", image.ShortID(diffID))
+	b.diffIDs = append(b.diffIDs, diffID)
+	b.cacheKey = key
+	return nil
+}
+
+// contextChecksum 计算源文件的校验和，包括相对路径、权限、符号链接目标和文件内容，不包括修改时间
+func contextChecksum(contextDir string, srcs []string) (string, error) {
+	hasher := sha256.New()
+	for _, src := range srcs {
+		err := filepath.Walk(src, func(filePath string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			rel, _ := filepath.Rel(contextDir, filePath)
+			fmt.Fprintf(hasher, "%s\x00%o\x00", rel, info.Mode())
+			switch {
+			case info.Mode()&os.ModeSymlink != 0:
+				link, err := os.Readlink(filePath)
+				if err != nil {
+					return err
+				}
+				fmt.Fprintf(hasher, "%s\x00", link)
+			case info.Mode().IsRegular():
+				f, err := os.Open(filePath)
+				if err != nil {
+					return err
+				}
+				_, err = io.Copy(hasher, f)
+				f.Close()
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return "", err
+		}
+	}
+	return "sha256:" + hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// copyTree 把文件、符号链接或目录（递归）复制到 dest，保留权限和修改时间，属主为 root
+func copyTree(src, dest string) error {
+	return filepath.Walk(src, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, filePath)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dest, rel)
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		switch {
+		case info.IsDir():
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+			if err := os.Chmod(target, info.Mode()); err != nil {
+				return err
+			}
+		case info.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(filePath)
+			if err != nil {
+				return err
+			}
+			os.Remove(target)
+			return os.Symlink(link, target)
+		case info.Mode().IsRegular():
+			if err := copyFile(filePath, target, info); err != nil {
+				return err
+			}
+		default:
+			// 设备文件、管道等不复制
+			return nil
+		}
+		return os.Chtimes(target, info.ModTime(), info.ModTime())
+	})
+}
+
+// copyFile 复制普通文件
+func copyFile(src, dest string, info os.FileInfo) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dest, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Chmod(dest, info.Mode())
+}
+
+// isArchive 根据文件头判断是否为 tar 包或 gzip、bzip2、xz、zstd 压缩的文件
+func isArchive(file string) bool {
+	f, err := os.Open(file)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	header := make([]byte, 512)
+	n, _ := io.ReadFull(f, header)
+	header = header[:n]
+	return archive.DetectCompression(header) != archive.Uncompressed || archive.IsTar(header)
+}
+
+// extractArchive 把 tar 包解压到目录，压缩格式自动识别
+func extractArchive(file, dest string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return archive.Untar(f, dest)
+}
@@ -3,23 +3,162 @@ package main
 import (
 	"fmt"
 	log "github.com/sirupsen/logrus"
+	"go-docker/archive"
 	"go-docker/container"
-	"os/exec"
+	"go-docker/image"
+	"go-docker/storage"
+	"io"
+	"os"
+	"strings"
+	"time"
 )
 
-// commitContainer 将指定容器的文件系统打包并保存为镜像文件
-func commitContainer(containerName, imageName string) {
-	// 构造容器挂载路径
-	mntURL := fmt.Sprintf(container.MntUrl, containerName) // 获取容器的挂载路径模板并格式化为指定容器名的路径
-	mntURL += "/"                                          // 加上斜杠以确保路径格式正确
+// commitContainer 将容器可写层中的改动打包为新的镜像层，与容器所用镜像的各层组成新镜像，并打上 imageName 标签
+// 镜像的运行配置取自容器记录的设置，再依次应用 changes 中的 Dockerfile 风格指令
+// author 和 message 与容器的命令一起记录在新镜像层的构建历史中
+func commitContainer(containerName, imageName, author, message string, changes []string) error {
+	ref, err := image.ParseReference(imageName)
+	if err != nil {
+		return err
+	}
+	if ref.Digest != "" {
+		return fmt.Errorf("commit target %s must be a name:tag reference", imageName)
+	}
+	containerInfo, err := getContainerInfoByName(containerName)
+	if err != nil {
+		return fmt.Errorf("container %s does not exist", containerName)
+	}
+	config, err := containerConfig(containerInfo)
+	if err != nil {
+		return err
+	}
+	for _, change := range changes {
+		if err := image.ApplyChange(config, change); err != nil {
+			return fmt.Errorf("invalid change %q: %v", change, err)
+		}
+	}
+
+	writeURL := fmt.Sprintf(container.WriteLayerUrl, containerName)
+	if exist, _ := container.PathExists(writeURL); !exist {
+		return fmt.Errorf("container %s has no write layer", containerName)
+	}
+	driver, err := storage.GetDriver(containerInfo.Driver)
+	if err != nil {
+		return err
+	}
+	// 写入镜像配置之前新的层没有引用，需要防止被垃圾回收
+	release, err := image.Lease()
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	// 新镜像由容器所用镜像的各层加上可写层的差异组成
+	// run --rootfs 启动的容器没有镜像，根文件系统目录打包为新镜像的第一层
+	var diffIDs, layers []string
+	var history []image.History
+	if containerInfo.Rootfs != "" {
+		baseID, err := createLayerFromDir(containerInfo.Rootfs)
+		if err != nil {
+			return err
+		}
+		diffIDs = []string{baseID}
+		layers = []string{containerInfo.Rootfs}
+		history = []image.History{{Created: time.Now().UTC(), Comment: "Imported from " + containerInfo.Rootfs}}
+	} else {
+		if containerInfo.ImageID == "" {
+			return fmt.Errorf("container %s was not created from an image", containerName)
+		}
+		parent, err := image.GetImage(containerInfo.ImageID)
+		if err != nil {
+			return err
+		}
+		if layers, err = image.LayerDirs(parent, driver); err != nil {
+			return err
+		}
+		diffIDs = append([]string{}, parent.RootFS.DiffIDs...)
+		history = append([]image.History{}, parent.History...)
+	}
+
+	// 将可写层的改动打包为镜像层
+	diffID, err := createLayerFromContainer(driver, containerName, layers)
+	if err != nil {
+		return err
+	}
+	diffIDs = append(diffIDs, diffID)
 
-	// 构造要保存的镜像文件路径
-	imageTar := container.RootUrl + "/" + imageName + ".tar" // 组合镜像存储的完整路径
+	img := image.NewImage(diffIDs, *config)
+	img.Author = author
+	img.History = append(history, image.History{
+		Created:   img.Created,
+		CreatedBy: strings.Join(containerInfo.Args, " "),
+		Author:    author,
+		Comment:   message,
+	})
+	id, err := image.CreateImage(img)
+	if err != nil {
+		return err
+	}
+	if err := image.Tag(id, ref); err != nil {
+		return err
+	}
+	fmt.Fprintln(os.Stdout, id)
+	return nil
+}
+
+// containerConfig 由容器记录的设置构造镜像的运行配置
+func containerConfig(containerInfo *container.ContainerInfo) (*image.Config, error) {
+	config := &image.Config{
+		User:       containerInfo.User,
+		Env:        containerInfo.ConfigEnv,
+		WorkingDir: containerInfo.WorkingDir,
+	}
+	if len(containerInfo.Entrypoint) > 0 {
+		config.Entrypoint = containerInfo.Entrypoint
+	}
+	if len(containerInfo.Args) > len(containerInfo.Entrypoint) {
+		config.Cmd = containerInfo.Args[len(containerInfo.Entrypoint):]
+	}
+	for _, p := range containerInfo.ExposedPorts {
+		port, err := image.ParsePort(p)
+		if err != nil {
+			return nil, err
+		}
+		if config.ExposedPorts == nil {
+			config.ExposedPorts = map[string]struct{}{}
+		}
+		config.ExposedPorts[port] = struct{}{}
+	}
+	return config, nil
+}
+
+// createLayerFromDir 将目录（build 生成的文件或 run --rootfs 的根文件系统）打包为镜像层，返回层的 diff ID
+func createLayerFromDir(dir string) (string, error) {
+	return createLayer(dir, func(w io.Writer) error {
+		return archive.TarLayer(dir, w)
+	})
+}
+
+// createLayerFromContainer 用存储驱动把容器可写层相对于镜像各层的改动打包为镜像层，返回层的 diff ID
+func createLayerFromContainer(driver storage.Driver, containerName string, layers []string) (string, error) {
+	writeURL := fmt.Sprintf(container.WriteLayerUrl, containerName)
+	return createLayer(writeURL, func(w io.Writer) error {
+		return driver.Diff(writeURL, layers, w)
+	})
+}
 
-	// 使用 tar 命令将容器的文件系统打包为 tar 压缩包
-	// 其中 -C 选项用于改变工作目录，表示打包 mntURL 目录下的所有内容
-	if _, err := exec.Command("tar", "-czf", imageTar, "-C", mntURL, ".").CombinedOutput(); err != nil {
+// createLayer 把 tarLayer 输出的镜像层直接通过管道写入镜像存储，返回层的 diff ID
+func createLayer(dir string, tarLayer func(w io.Writer) error) (string, error) {
+	reader, writer := io.Pipe()
+	go func() {
+		writer.CloseWithError(tarLayer(writer))
+	}()
+	diffID, err := image.CreateLayer(reader)
+	reader.Close()
+	if err != nil {
 		// 如果打包过程出错，记录错误信息
-		log.Errorf("Tar folder %s error %v", mntURL, err)
+		log.Errorf("Tar layer %s error %v", dir, err)
+		return "", err
 	}
+	return diffID, nil
 }
@@ -3,6 +3,8 @@ package container
 import (
 	"fmt"
 	log "github.com/sirupsen/logrus"
+	"go-docker/cgroups/subsystems"
+	"go-docker/storage"
 	"os"
 	"os/exec"
 	"syscall"
@@ -15,13 +17,15 @@ import (
 var (
 	RUNNING             string = "running"               // 容器运行状态
 	STOP                string = "stopped"               // 容器停止状态
+	CREATED             string = "created"               // 容器已创建但用户命令尚未执行（OCI create）
 	Exit                string = "exited"                // 容器退出状态
 	DefaultInfoLocation string = "/var/run/mydocker/%s/" // 容器信息存储目录（如 config.json）
 	ConfigName          string = "config.json"           // 容器配置信息文件名
 	ContainerLogFile    string = "container.log"         // 容器标准输出日志文件名
-	RootUrl             string = "/root"                 // 镜像只读层路径
+	RootUrl             string = "/root"                 // 旧式镜像 tar 包（<name>.tar）所在目录
 	MntUrl              string = "/root/mnt/%s"          // 容器挂载点路径
-	WriteLayerUrl       string = "/root/writeLayer/%s"   // 容器可写层路径
+	WriteLayerUrl       string = "/root/writeLayer/%s"   // 容器可写层目录，内部结构由存储驱动决定
+	SnapshotUrl         string = "/root/snapshots/%s"    // 容器可写层快照的存放目录，与可写层在同一文件系统上以便使用 reflink
 )
 
 // ------------------------
@@ -29,51 +33,62 @@ var (
 // ------------------------
 
 type ContainerInfo struct {
-	Pid         string   `json:"pid"`         // 容器中 init 进程的 PID（宿主机上的）
-	Id          string   `json:"id"`          // 容器 ID
-	Name        string   `json:"name"`        // 容器名称
-	Command     string   `json:"command"`     // 容器启动时执行的命令
-	CreatedTime string   `json:"createTime"`  // 容器创建时间
-	Status      string   `json:"status"`      // 容器当前状态（running, stopped 等）
-	Volume      string   `json:"volume"`      // 数据卷（volume）挂载路径
-	PortMapping []string `json:"portmapping"` // 容器和宿主机端口映射信息
+	Pid          string          `json:"pid"`           // 容器中 init 进程的 PID（宿主机上的）
+	Id           string          `json:"id"`            // 容器 ID
+	Name         string          `json:"name"`          // 容器名称
+	Command      string          `json:"command"`       // 容器启动时执行的命令（仅用于展示）
+	Args         []string        `json:"args"`          // 容器启动时执行的命令及参数
+	CreatedTime  string          `json:"createTime"`    // 容器创建时间
+	Status       string          `json:"status"`        // 容器当前状态（running, stopped 等）
+	Tty          bool            `json:"tty"`           // 是否以 -ti 在前台运行，前台的 mydocker run 等待容器退出后删除容器；快照恢复会把它改为后台运行
+	Mounts       []MountPoint    `json:"mounts"`        // -v、--mount 指定的挂载
+	PortMapping  []string        `json:"portmapping"`   // 容器和宿主机端口映射信息
+	Network      string          `json:"network"`       // 容器连接的网络或网络命名空间模式（--net）
+	Namespaces   NamespaceConfig `json:"namespaces"`    // 各命名空间的共享模式
+	Init         bool            `json:"init"`          // 是否由 mydocker init 作为 PID 1 运行用户进程
+	Rlimits      []Rlimit        `json:"rlimits"`       // 资源限制（--ulimit）
+	Entrypoint   []string        `json:"entrypoint"`    // 命令前缀（--entrypoint 或镜像的 Entrypoint），已包含在 Args 中
+	WorkingDir   string          `json:"workingDir"`    // 容器内的工作目录
+	User         string          `json:"user"`          // 运行用户 user[:group]
+	Hostname     string          `json:"hostname"`      // 容器主机名
+	Env          []string        `json:"env"`           // 容器的环境变量，exec 时使用
+	ConfigEnv    []string        `json:"configEnv"`     // 镜像默认值加上 -e/--env-file 的环境变量，commit 时写入镜像配置
+	ExposedPorts []string        `json:"exposedPorts"`  // 暴露的端口，如 80/tcp
+	Bundle       string          `json:"bundle"`        // OCI bundle 目录，仅由 create 创建的容器有
+	Image        string          `json:"image"`         // 启动容器时指定的镜像引用
+	ImageID      string          `json:"imageId"`       // 镜像 ID（镜像配置的 sha256 摘要）
+	Driver       string          `json:"storageDriver"` // 创建容器可写层和挂载根文件系统的存储驱动
+	Rootfs       string          `json:"rootfs"`        // run --rootfs 指定的根文件系统目录，此时容器没有镜像
+	StorageSize  int64           `json:"storageSize"`   // 可写层的大小上限（字节），--storage-opt size，为 0 时不限制
+	ReadonlyRoot bool            `json:"readonlyRoot"`  // 根文件系统是否只读（--read-only）
+
+	Resources subsystems.ResourceConfig `json:"resources"` // cgroup 资源限制（-m、--cpushare、--cpuset）
 }
 
 // ------------------------
 // 创建新的父进程（容器 init 进程）
 // tty 表示是否开启终端（即是否交互）
 // containerName 是容器名
-// volume 是数据卷挂载信息
-// imageName 是镜像名称
-// envSlice 是环境变量数组
-// 返回创建的命令（即 init 容器进程）和管道写入端
+// layers 是镜像各层由 driver 解压后的目录，按从下到上的顺序排列
+// driver 是创建可写层和挂载根文件系统的存储驱动
+// storageSize 是可写层的大小上限（字节），为 0 时不限制
+// reuseLayer 表示沿用已有的可写层重新启动容器，见 NewWorkSpace
+// ns 是各命名空间的共享模式，决定需要新建哪些命名空间
+// 返回创建的命令（即 init 容器进程）、发送 InitSpec 的管道写入端和接收初始化错误的管道读取端
+// 用户命令、环境变量等由调用方通过 SendInitSpec 发送
 // ------------------------
 
-func NewParentProcess(tty bool, containerName, volume, imageName string, envSlice []string) (*exec.Cmd, *os.File) {
-	// 创建匿名管道，用于父子进程间通信
-	readPipe, writePipe, err := NewPipe()
-	if err != nil {
-		log.Errorf("New pipe error %v", err)
-		return nil, nil
+func NewParentProcess(tty bool, containerName string, layers []string, driver storage.Driver, storageSize int64, reuseLayer bool,
+	ns *NamespaceConfig) (*exec.Cmd, *os.File, *os.File) {
+	cmd, writePipe, errReadPipe := newInitCommand(ns)
+	if cmd == nil {
+		return nil, nil, nil
 	}
 
-	// 获取当前进程执行文件的路径（用于调用自身执行 init 子命令）
-	initCmd, err := os.Readlink("/proc/self/exe")
-	if err != nil {
-		log.Errorf("get init process error %v", err)
-		return nil, nil
-	}
-
-	// 创建命令行对象，执行自身，并传入 init 子命令（此时执行的是 container/init.go 中的逻辑）
-	cmd := exec.Command(initCmd, "init")
-
-	// 设置命名空间隔离标志，类似 docker 的 --net、--pid 等
-	cmd.SysProcAttr = &syscall.SysProcAttr{
-		Cloneflags: syscall.CLONE_NEWUTS | // 主机名
-			syscall.CLONE_NEWPID | // PID 进程号
-			syscall.CLONE_NEWNS | // 文件系统
-			syscall.CLONE_NEWNET | // 网络
-			syscall.CLONE_NEWIPC, // IPC 信号量
+	// 先设置容器文件系统，包括挂载点；失败时还没有创建容器信息目录，不会留下只有日志的目录
+	if err := NewWorkSpace(driver, layers, containerName, storageSize, reuseLayer); err != nil {
+		log.Errorf("NewParentProcess create workspace error %v", err)
+		return nil, nil, nil
 	}
 
 	if tty {
@@ -82,35 +97,88 @@ func NewParentProcess(tty bool, containerName, volume, imageName string, envSlic
 		cmd.Stdout = os.Stdout
 		cmd.Stderr = os.Stderr
 	} else {
-		// 非交互模式，将 stdout 重定向到日志文件
+		// 非交互模式，将 stdout 重定向到日志文件；重新启动的容器接着写原来的日志
 		dirURL := fmt.Sprintf(DefaultInfoLocation, containerName)
 		if err := os.MkdirAll(dirURL, 0622); err != nil {
 			log.Errorf("NewParentProcess mkdir %s error %v", dirURL, err)
-			return nil, nil
+			return nil, nil, nil
 		}
 		stdLogFilePath := dirURL + ContainerLogFile
-		stdLogFile, err := os.Create(stdLogFilePath)
+		stdLogFile, err := os.OpenFile(stdLogFilePath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
 		if err != nil {
 			log.Errorf("NewParentProcess create file %s error %v", stdLogFilePath, err)
-			return nil, nil
+			return nil, nil, nil
 		}
 		cmd.Stdout = stdLogFile
 	}
 
-	// 把管道的读端传给子进程（作为 fd 3）
-	cmd.ExtraFiles = []*os.File{readPipe}
+	// 设置容器进程的工作目录（即挂载后的 mnt 目录）
+	cmd.Dir = fmt.Sprintf(MntUrl, containerName)
 
-	// 设置环境变量
-	cmd.Env = append(os.Environ(), envSlice...)
+	// 返回构造好的命令对象和两个管道在父进程一侧的端
+	return cmd, writePipe, errReadPipe
+}
 
-	// 设置容器文件系统，包括挂载点
-	NewWorkSpace(volume, imageName, containerName)
+// NewBundleProcess 为 OCI bundle 创建容器 init 进程
+// 与 NewParentProcess 不同，rootfs 直接使用 bundle 中的目录，不创建镜像层和可写层；标准输入输出继承自调用者
+func NewBundleProcess(rootfs string, ns *NamespaceConfig) (*exec.Cmd, *os.File, *os.File) {
+	cmd, writePipe, errReadPipe := newInitCommand(ns)
+	if cmd == nil {
+		return nil, nil, nil
+	}
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Dir = rootfs
+	return cmd, writePipe, errReadPipe
+}
 
-	// 设置容器进程的工作目录（即挂载后的 mnt 目录）
-	cmd.Dir = fmt.Sprintf(MntUrl, containerName)
+// newInitCommand 创建执行 init 子命令的命令对象以及父子进程通信的两个管道
+func newInitCommand(ns *NamespaceConfig) (*exec.Cmd, *os.File, *os.File) {
+	// 创建匿名管道，用于父进程向子进程发送 InitSpec
+	readPipe, writePipe, err := NewPipe()
+	if err != nil {
+		log.Errorf("New pipe error %v", err)
+		return nil, nil, nil
+	}
+	// 创建第二个管道，用于子进程向父进程回报初始化错误
+	errReadPipe, errWritePipe, err := NewPipe()
+	if err != nil {
+		log.Errorf("New pipe error %v", err)
+		return nil, nil, nil
+	}
+
+	// 获取当前进程执行文件的路径（用于调用自身执行 init 子命令）
+	initCmd, err := os.Readlink("/proc/self/exe")
+	if err != nil {
+		log.Errorf("get init process error %v", err)
+		return nil, nil, nil
+	}
 
-	// 返回构造好的命令对象和写端管道
-	return cmd, writePipe
+	// 创建命令行对象，执行自身，并传入 init 子命令（此时执行的是 container/init.go 中的逻辑）
+	cmd := exec.Command(initCmd, "init")
+
+	// 设置命名空间隔离标志，类似 docker 的 --net、--pid 等
+	// 与宿主机或其他容器共享的命名空间不再 clone，加入其他容器命名空间的操作见 NamespaceConfig.Enter
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Cloneflags: ns.Cloneflags(),
+	}
+
+	// 把 InitSpec 管道的读端（作为 fd 3）和错误管道的写端（作为 fd 4）传给子进程
+	cmd.ExtraFiles = []*os.File{readPipe, errWritePipe}
+
+	// init 进程不继承宿主机的环境变量，用户进程的环境变量通过 InitSpec 传递
+	cmd.Env = []string{}
+
+	return cmd, writePipe, errReadPipe
+}
+
+// CloseChildPipes 在子进程启动后关闭父进程持有的子进程一侧管道端
+// 否则父进程自己持有错误管道的写端，WaitInitResult 永远读不到 EOF
+func CloseChildPipes(cmd *exec.Cmd) {
+	for _, f := range cmd.ExtraFiles {
+		f.Close()
+	}
 }
 
 // NewPipe 创建一个匿名管道用于父子进程通信
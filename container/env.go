@@ -0,0 +1,81 @@
+package container
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// ParseEnvFile 读取 --env-file 指定的环境变量文件
+// 每行一个 KEY=VALUE，空行和 # 开头的注释行被忽略；只有 KEY 的行取宿主机上同名变量的值
+func ParseEnvFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var envs []string
+	scanner := bufio.NewScanner(f)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		kv := strings.SplitN(line, "=", 2)
+		key := strings.TrimSpace(kv[0])
+		if key == "" || strings.ContainsAny(key, " \t") {
+			return nil, fmt.Errorf("%s:%d: invalid variable name %q", path, lineNo, kv[0])
+		}
+		if len(kv) == 1 {
+			if value, ok := os.LookupEnv(key); ok {
+				envs = append(envs, key+"="+value)
+			}
+			continue
+		}
+		envs = append(envs, key+"="+kv[1])
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return envs, nil
+}
+
+// DefaultPathEnv 是容器中未设置 PATH 时使用的默认值
+const DefaultPathEnv = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+
+// MergeEnv 按顺序合并多组 KEY=VALUE 形式的环境变量，同名变量以后出现的为准，并保留首次出现的位置
+func MergeEnv(envGroups ...[]string) []string {
+	var merged []string
+	index := map[string]int{}
+	for _, envs := range envGroups {
+		for _, env := range envs {
+			key := strings.SplitN(env, "=", 2)[0]
+			if i, ok := index[key]; ok {
+				merged[i] = env
+				continue
+			}
+			index[key] = len(merged)
+			merged = append(merged, env)
+		}
+	}
+	return merged
+}
+
+// BuildContainerEnv 构造容器的环境变量，不继承宿主机的任何环境变量
+// 先设置 PATH、HOSTNAME、HOME 和 TERM（分配终端时）等标准变量，再依次应用 envGroups（镜像默认值、--env-file、-e），
+// 因此用户可以覆盖标准变量
+func BuildContainerEnv(hostname, home string, tty bool, envGroups ...[]string) []string {
+	standard := []string{"PATH=" + DefaultPathEnv}
+	if hostname != "" {
+		standard = append(standard, "HOSTNAME="+hostname)
+	}
+	if home != "" {
+		standard = append(standard, "HOME="+home)
+	}
+	if tty {
+		standard = append(standard, "TERM=xterm")
+	}
+	return MergeEnv(append([][]string{standard}, envGroups...)...)
+}
@@ -3,6 +3,7 @@ package container
 import (
 	"fmt"
 	log "github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
 	"io/ioutil"
 	"os"
 	"os/exec"
@@ -12,64 +13,252 @@ import (
 )
 
 // RunContainerInitProcess 是容器内部 init 进程的入口函数。
-// 它负责从管道中读取用户命令，设置挂载点，并使用 syscall.Exec 执行用户指定的命令。
+// 它从 3 号文件描述符读取父进程发送的 InitSpec，完成挂载、主机名、资源限制、环境变量、工作目录和用户的设置，
+// 然后使用 syscall.Exec 执行用户指定的命令。
+// 初始化过程中的任何错误都会通过 4 号文件描述符回报给父进程，使 run 直接失败而不是留下一个半启动的容器。
 func RunContainerInitProcess() error {
-	cmdArray := readUserCommand()
-	if cmdArray == nil || len(cmdArray) == 0 {
-		return fmt.Errorf("Run container get user command error, cmdArray is nil")
+	errPipe := os.NewFile(uintptr(initErrorFd), "errpipe")
+	// exec 用户命令时自动关闭错误管道，父进程读到 EOF 即表示初始化成功
+	syscall.CloseOnExec(initErrorFd)
+	if err := initContainer(errPipe); err != nil {
+		log.Errorf("Init container error %v", err)
+		reportInitError(errPipe, err)
+		errPipe.Close()
+		return err
+	}
+	return nil
+}
+
+// initContainer 按照 InitSpec 设置容器环境并执行用户命令，成功时不返回
+func initContainer(errPipe *os.File) error {
+	spec, err := readInitSpec()
+	if err != nil {
+		return err
 	}
 
-	// 设置挂载点：proc 文件系统等（容器隔离环境的关键）
-	setUpMount()
+	// 由 OCI create 创建的容器需要等待 start 之后才执行用户命令
+	// exec fifo 在 pivot_root 之后不可见，因此先以 O_PATH 打开，之后通过 /proc/self/fd 重新打开
+	fifoFd := -1
+	if spec.ExecFifo != "" {
+		if fifoFd, err = unix.Open(spec.ExecFifo, unix.O_PATH|unix.O_CLOEXEC, 0); err != nil {
+			return fmt.Errorf("open exec fifo %s error %v", spec.ExecFifo, err)
+		}
+	}
 
-	// 查找命令的绝对路径
-	path, err := exec.LookPath(cmdArray[0])
+	// 设置挂载点：用户挂载、pivot_root、proc 文件系统等（容器隔离环境的关键）
+	if err := setUpMount(spec.Mounts, spec.RootPropagation); err != nil {
+		return err
+	}
+
+	if spec.Hostname != "" {
+		if err := syscall.Sethostname([]byte(spec.Hostname)); err != nil {
+			return fmt.Errorf("set hostname %s error %v", spec.Hostname, err)
+		}
+	}
+	if err := setRlimits(spec.Rlimits); err != nil {
+		return err
+	}
+
+	// 使用 spec 中的环境变量，查找命令时使用其中的 PATH
+	os.Clearenv()
+	for _, env := range spec.Env {
+		if kv := strings.SplitN(env, "=", 2); len(kv) == 2 {
+			os.Setenv(kv[0], kv[1])
+		}
+	}
+
+	// 按容器内的 /etc/passwd 和 /etc/group 解析运行用户
+	user, err := lookupUser("/", spec.User)
 	if err != nil {
-		log.Errorf("Exec loop path error %v", err)
 		return err
 	}
+	cred := user.Credential()
+	if spec.OnlyAdditionalGids {
+		// 与 runc 一致，OCI bundle 的附加组完全由 config.json 指定，不按容器内的 /etc/group 补充
+		cred.Groups = spec.AdditionalGids
+	} else {
+		cred.Groups = append(cred.Groups, spec.AdditionalGids...)
+	}
+
+	// 切换到工作目录，不存在时创建（与 docker 的 -w 行为一致）
+	cwd := spec.Cwd
+	if cwd == "" {
+		cwd = "/"
+	}
+	if err := os.MkdirAll(cwd, 0755); err != nil {
+		return fmt.Errorf("create working directory %s error %v", cwd, err)
+	}
+	// 工作目录创建之后再把根文件系统设为只读；/proc、/dev 和 -v、--tmpfs 等挂载是独立的挂载，不受影响
+	if spec.ReadonlyRoot {
+		if err := remountReadonly("/"); err != nil {
+			return err
+		}
+	}
+	if err := syscall.Chdir(cwd); err != nil {
+		return fmt.Errorf("chdir to working directory %s error %v", cwd, err)
+	}
+
+	// 查找命令的绝对路径
+	path, err := exec.LookPath(spec.Args[0])
+	if err != nil {
+		return fmt.Errorf("exec look path %s error %v", spec.Args[0], err)
+	}
 	log.Infof("Find path %s", path)
 
-	// 执行用户命令，替换当前 init 进程（不返回）
-	if err := syscall.Exec(path, cmdArray[0:], os.Environ()); err != nil {
-		log.Errorf(err.Error())
+	if fifoFd >= 0 {
+		// 初始化已完成，通知父进程（create）返回，然后阻塞到 start 读取 exec fifo
+		errPipe.Close()
+		if err := waitExecFifo(fifoFd); err != nil {
+			return err
+		}
+	}
+
+	if spec.Init {
+		return runAsInit(path, spec.Args, spec.Env, cred, errPipe)
+	}
+
+	if err := setCredential(cred); err != nil {
+		return err
+	}
+	// 执行用户命令，替换当前 init 进程（成功时不返回）
+	if err := syscall.Exec(path, spec.Args, spec.Env); err != nil {
+		return fmt.Errorf("exec %s error %v", path, err)
 	}
 	return nil
 }
 
-// 从 3 号文件描述符（即管道）读取用户传入的命令字符串，并解析为字符串数组
-func readUserCommand() []string {
-	pipe := os.NewFile(uintptr(3), "pipe") // 对应父进程传入的 writePipe
-	defer pipe.Close()
-	msg, err := ioutil.ReadAll(pipe) // 读取全部内容
-	if err != nil {
-		log.Errorf("init read pipe error %v", err)
-		return nil
-	}
-	msgStr := string(msg)
-	return strings.Split(msgStr, " ") // 按空格切分成命令+参数数组
+// defaultMounts 是每个容器都需要的挂载点，InitSpec 中有相同目标的挂载时以 InitSpec 为准
+var defaultMounts = []Mount{
+	// 挂载 proc 文件系统，便于容器内 ps 等命令访问进程信息
+	{Source: "proc", Destination: "/proc", Type: "proc", Options: []string{"nosuid", "noexec", "nodev"}},
+	// 挂载 tmpfs 到 /dev，提供一些必要的设备支持（如 /dev/null）
+	{Source: "tmpfs", Destination: "/dev", Type: "tmpfs", Options: []string{"nosuid", "strictatime", "mode=755"}},
+}
+
+// defaultDevices 是从宿主机 bind 挂载到容器 /dev 下的设备
+var defaultDevices = []string{"null", "zero", "full", "random", "urandom", "tty"}
+
+// defaultDevSymlinks 是容器 /dev 下的标准符号链接
+var defaultDevSymlinks = map[string]string{
+	"fd":     "/proc/self/fd",
+	"stdin":  "/proc/self/fd/0",
+	"stdout": "/proc/self/fd/1",
+	"stderr": "/proc/self/fd/2",
+	"ptmx":   "pts/ptmx",
 }
 
 /*
  * 设置容器的挂载点（类似 chroot 的环境隔离）
+ * - 挂载 /proc 和 /dev 等必要文件系统，以及 spec 中的挂载点
+ * - 在 /dev 下创建默认设备
  * - 设置新的 root 文件系统
- * - 挂载 /proc 和 /dev 等必要文件系统
+ * 所有挂载都在 pivot_root 之前完成，此时宿主机上的源路径仍然可见
+ * rootPropagation 为根挂载的传播方式，为空时为 rprivate
  */
-func setUpMount() {
+func setUpMount(mounts []Mount, rootPropagation string) error {
 	pwd, err := os.Getwd() // 获取当前工作目录，作为新的 root
 	if err != nil {
-		log.Errorf("Get current location error %v", err)
-		return
+		return fmt.Errorf("get current location error %v", err)
 	}
 	log.Infof("Current location is %s", pwd)
-	pivotRoot(pwd) // 执行 root 切换操作
 
-	// 挂载 proc 文件系统，便于容器内 ps 等命令访问进程信息
-	defaultMountFlags := syscall.MS_NOEXEC | syscall.MS_NOSUID | syscall.MS_NODEV
-	syscall.Mount("proc", "/proc", "proc", uintptr(defaultMountFlags), "")
+	// 默认把挂载命名空间设为私有，避免容器内的挂载传播回宿主机，同时满足 pivot_root 对挂载传播的要求
+	// 有 shared 或 slave 的 bind 挂载时根挂载改为 rshared 或 rslave，bind 挂载才能继承宿主机上源挂载的传播关系
+	propagation := uintptr(syscall.MS_PRIVATE | syscall.MS_REC)
+	if rootPropagation != "" {
+		p, ok := propagationFlags[rootPropagation]
+		if !ok {
+			return fmt.Errorf("invalid root propagation %s", rootPropagation)
+		}
+		propagation = p
+	}
+	if err := syscall.Mount("", "/", "", propagation, ""); err != nil {
+		return fmt.Errorf("set propagation of / error %v", err)
+	}
+	// 根挂载为 shared 时，rootfs 所在的挂载也需要设为私有，否则 proc 等挂载会传播回宿主机，pivot_root 也会失败
+	if propagation&syscall.MS_SHARED != 0 {
+		mountPoint, err := mountPointOf(pwd)
+		if err != nil {
+			return err
+		}
+		if err := syscall.Mount("", mountPoint, "", syscall.MS_PRIVATE, ""); err != nil {
+			return fmt.Errorf("make %s private error %v", mountPoint, err)
+		}
+	}
 
-	// 挂载 tmpfs 到 /dev，提供一些必要的设备支持（如 /dev/null）
-	syscall.Mount("tmpfs", "/dev", "tmpfs", syscall.MS_NOSUID|syscall.MS_STRICTATIME, "mode=755")
+	for _, m := range defaultMounts {
+		if hasMountAt(mounts, m.Destination) {
+			continue
+		}
+		if err := mountToRootfs(pwd, m); err != nil {
+			return err
+		}
+	}
+	for _, m := range mounts {
+		if err := mountToRootfs(pwd, m); err != nil {
+			return err
+		}
+	}
+	if err := setupDevices(pwd); err != nil {
+		return err
+	}
+
+	// 执行 root 切换操作
+	return pivotRoot(pwd)
+}
+
+// mountPointOf 返回路径所在挂载的挂载点，即 /proc/self/mountinfo 中是路径前缀的最长挂载点
+func mountPointOf(path string) (string, error) {
+	content, err := ioutil.ReadFile("/proc/self/mountinfo")
+	if err != nil {
+		return "", err
+	}
+	result := "/"
+	for _, line := range strings.Split(string(content), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 5 {
+			continue
+		}
+		mp := fields[4]
+		if (path == mp || strings.HasPrefix(path, strings.TrimSuffix(mp, "/")+"/")) && len(mp) > len(result) {
+			result = mp
+		}
+	}
+	return result, nil
+}
+
+// hasMountAt 判断挂载列表中是否有挂载到 dest 的挂载点
+func hasMountAt(mounts []Mount, dest string) bool {
+	for _, m := range mounts {
+		if filepath.Clean(m.Destination) == dest {
+			return true
+		}
+	}
+	return false
+}
+
+// setupDevices 在容器的 /dev 中创建默认设备和符号链接，已存在的跳过
+func setupDevices(rootfs string) error {
+	devDir := filepath.Join(rootfs, "dev")
+	for _, name := range defaultDevices {
+		dest := filepath.Join(devDir, name)
+		if _, err := os.Lstat(dest); err == nil {
+			continue
+		}
+		if err := mountToRootfs(rootfs, Mount{Source: "/dev/" + name, Destination: "/dev/" + name, Type: "bind"}); err != nil {
+			return err
+		}
+	}
+	for name, target := range defaultDevSymlinks {
+		dest := filepath.Join(devDir, name)
+		if _, err := os.Lstat(dest); err == nil {
+			continue
+		}
+		if err := os.Symlink(target, dest); err != nil {
+			return fmt.Errorf("create symlink %s error %v", dest, err)
+		}
+	}
+	return nil
 }
 
 /*
@@ -107,3 +296,43 @@ func pivotRoot(root string) error {
 	// 6. 删除旧 root 临时目录
 	return os.Remove(pivotDir)
 }
+
+// remountReadonly 把挂载点 path 重新挂载为只读，保留它原有的 nosuid、nodev 等选项
+// pivot_root 之前 rootfs 被 bind 挂载到自身，重新挂载只影响根挂载本身，不影响其下的其他挂载
+func remountReadonly(path string) error {
+	var st unix.Statfs_t
+	if err := unix.Statfs(path, &st); err != nil {
+		return fmt.Errorf("statfs %s error %v", path, err)
+	}
+	flags := uintptr(syscall.MS_BIND | syscall.MS_REMOUNT | syscall.MS_RDONLY)
+	for _, f := range []struct{ st, ms int64 }{
+		{unix.ST_NOSUID, syscall.MS_NOSUID},
+		{unix.ST_NODEV, syscall.MS_NODEV},
+		{unix.ST_NOEXEC, syscall.MS_NOEXEC},
+		{unix.ST_NOATIME, syscall.MS_NOATIME},
+		{unix.ST_NODIRATIME, syscall.MS_NODIRATIME},
+		{unix.ST_RELATIME, syscall.MS_RELATIME},
+	} {
+		if st.Flags&f.st != 0 {
+			flags |= uintptr(f.ms)
+		}
+	}
+	if err := syscall.Mount("", path, "", flags, ""); err != nil {
+		return fmt.Errorf("remount %s read-only error %v", path, err)
+	}
+	return nil
+}
+
+// waitExecFifo 以写方式打开 exec fifo，阻塞直到 start 命令以读方式打开它
+func waitExecFifo(fd int) error {
+	fifo, err := os.OpenFile(fmt.Sprintf("/proc/self/fd/%d", fd), os.O_WRONLY, 0)
+	if err != nil {
+		return fmt.Errorf("open exec fifo error %v", err)
+	}
+	defer fifo.Close()
+	unix.Close(fd)
+	if _, err := fifo.Write([]byte{0}); err != nil {
+		return fmt.Errorf("write exec fifo error %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,189 @@
+package container
+
+import (
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// Mount 描述 init 进程在容器 rootfs 中完成的一个挂载
+// Options 与 mount(8) 的 -o 选项相同，例如 rbind、ro、nosuid、rprivate、size=64m
+type Mount struct {
+	Source      string   `json:"source"`
+	Destination string   `json:"destination"` // 容器内的路径
+	Type        string   `json:"type"`        // 文件系统类型，bind 挂载为 bind
+	Options     []string `json:"options,omitempty"`
+}
+
+// mountFlags 是挂载选项到 mount flag 的映射，clear 为 true 的选项表示清除该 flag
+var mountFlags = map[string]struct {
+	clear bool
+	flag  uintptr
+}{
+	"ro":          {false, syscall.MS_RDONLY},
+	"rw":          {true, syscall.MS_RDONLY},
+	"nosuid":      {false, syscall.MS_NOSUID},
+	"suid":        {true, syscall.MS_NOSUID},
+	"nodev":       {false, syscall.MS_NODEV},
+	"dev":         {true, syscall.MS_NODEV},
+	"noexec":      {false, syscall.MS_NOEXEC},
+	"exec":        {true, syscall.MS_NOEXEC},
+	"sync":        {false, syscall.MS_SYNCHRONOUS},
+	"async":       {true, syscall.MS_SYNCHRONOUS},
+	"noatime":     {false, syscall.MS_NOATIME},
+	"atime":       {true, syscall.MS_NOATIME},
+	"nodiratime":  {false, syscall.MS_NODIRATIME},
+	"relatime":    {false, syscall.MS_RELATIME},
+	"strictatime": {false, syscall.MS_STRICTATIME},
+	"bind":        {false, syscall.MS_BIND},
+	"rbind":       {false, syscall.MS_BIND | syscall.MS_REC},
+	"remount":     {false, syscall.MS_REMOUNT},
+}
+
+// propagationFlags 是挂载传播选项到 mount flag 的映射
+var propagationFlags = map[string]uintptr{
+	"private":     syscall.MS_PRIVATE,
+	"rprivate":    syscall.MS_PRIVATE | syscall.MS_REC,
+	"shared":      syscall.MS_SHARED,
+	"rshared":     syscall.MS_SHARED | syscall.MS_REC,
+	"slave":       syscall.MS_SLAVE,
+	"rslave":      syscall.MS_SLAVE | syscall.MS_REC,
+	"unbindable":  syscall.MS_UNBINDABLE,
+	"runbindable": syscall.MS_UNBINDABLE | syscall.MS_REC,
+}
+
+// parseMountOptions 把挂载选项拆分为 mount flag、传播属性和文件系统私有数据（如 size=64m）
+func parseMountOptions(options []string) (uintptr, []uintptr, string) {
+	var flags uintptr
+	var propagation []uintptr
+	var data []string
+	for _, o := range options {
+		if f, ok := mountFlags[o]; ok {
+			if f.clear {
+				flags &^= f.flag
+			} else {
+				flags |= f.flag
+			}
+		} else if p, ok := propagationFlags[o]; ok {
+			propagation = append(propagation, p)
+		} else {
+			data = append(data, o)
+		}
+	}
+	return flags, propagation, strings.Join(data, ",")
+}
+
+// ResolveInRootfs 把容器内路径解析为宿主机上 rootfs 中的路径
+// 路径中的符号链接按容器内的视角解析，保证结果不会逃逸出 rootfs
+func ResolveInRootfs(rootfs, path string) (string, error) {
+	const maxSymlinks = 255
+	resolved := ""
+	remaining := filepath.Clean("/" + path)
+	for links := 0; remaining != "/" && remaining != ""; {
+		remaining = strings.TrimPrefix(remaining, "/")
+		var part string
+		if i := strings.Index(remaining, "/"); i >= 0 {
+			part, remaining = remaining[:i], remaining[i:]
+		} else {
+			part, remaining = remaining, ""
+		}
+		if part == "" || part == "." {
+			continue
+		}
+		if part == ".." {
+			resolved = filepath.Dir("/" + resolved)
+			continue
+		}
+		next := filepath.Join(resolved, part)
+		fi, err := os.Lstat(filepath.Join(rootfs, next))
+		if err != nil {
+			if os.IsNotExist(err) {
+				// 不存在的部分原样拼接，之后由调用方创建
+				resolved = next
+				continue
+			}
+			return "", err
+		}
+		if fi.Mode()&os.ModeSymlink == 0 {
+			resolved = next
+			continue
+		}
+		links++
+		if links > maxSymlinks {
+			return "", fmt.Errorf("too many symlinks in %s", path)
+		}
+		target, err := os.Readlink(filepath.Join(rootfs, next))
+		if err != nil {
+			return "", err
+		}
+		if filepath.IsAbs(target) {
+			resolved = ""
+		}
+		remaining = target + remaining
+	}
+	return filepath.Join(rootfs, filepath.Clean("/"+resolved)), nil
+}
+
+// mountToRootfs 在 pivot_root 之前把挂载点挂载到 rootfs 中
+func mountToRootfs(rootfs string, m Mount) error {
+	dest, err := ResolveInRootfs(rootfs, m.Destination)
+	if err != nil {
+		return fmt.Errorf("resolve mount destination %s error %v", m.Destination, err)
+	}
+	flags, propagation, data := parseMountOptions(m.Options)
+	if m.Type == "bind" {
+		flags |= syscall.MS_BIND
+	}
+	bind := flags&syscall.MS_BIND != 0
+
+	// 创建挂载点，bind 挂载的源为文件时挂载点也需要是文件
+	if err := createMountTarget(m.Source, dest, bind); err != nil {
+		return err
+	}
+
+	fstype := m.Type
+	if bind {
+		fstype = ""
+	}
+	if err := syscall.Mount(m.Source, dest, fstype, flags, data); err != nil {
+		return fmt.Errorf("mount %s to %s error %v", m.Source, m.Destination, err)
+	}
+	// bind 挂载会忽略 ro、nosuid 等选项，需要再 remount 一次才能生效
+	if bind && flags&^(syscall.MS_BIND|syscall.MS_REC) != 0 {
+		remountFlags := flags&^syscall.MS_REC | syscall.MS_REMOUNT | syscall.MS_BIND
+		if err := syscall.Mount("", dest, "", remountFlags, ""); err != nil {
+			return fmt.Errorf("remount %s error %v", m.Destination, err)
+		}
+	}
+	for _, p := range propagation {
+		if err := syscall.Mount("", dest, "", p, ""); err != nil {
+			return fmt.Errorf("set propagation of %s error %v", m.Destination, err)
+		}
+	}
+	log.Infof("Mount %s(%s) to %s with %v", m.Source, m.Type, m.Destination, m.Options)
+	return nil
+}
+
+// createMountTarget 创建挂载目标
+func createMountTarget(source, dest string, bind bool) error {
+	if bind {
+		fi, err := os.Stat(source)
+		if err != nil {
+			return fmt.Errorf("stat bind source %s error %v", source, err)
+		}
+		if !fi.IsDir() {
+			if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+				return err
+			}
+			f, err := os.OpenFile(dest, os.O_CREATE, 0644)
+			if err != nil {
+				return err
+			}
+			return f.Close()
+		}
+	}
+	return os.MkdirAll(dest, 0755)
+}
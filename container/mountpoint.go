@@ -0,0 +1,303 @@
+package container
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// 挂载的类型
+const (
+	MountTypeBind   = "bind"   // 把宿主机上的文件或目录挂载到容器中
+	MountTypeVolume = "volume" // 挂载由 mydocker 管理的数据卷
+	MountTypeTmpfs  = "tmpfs"  // 挂载只在内存中的临时文件系统
+)
+
+// MountPoint 是 run 的 -v、--mount 指定的挂载，记录在容器信息中
+// 挂载由容器 init 进程在容器的挂载命名空间中完成，容器退出后自动消失，宿主机上不留下挂载点
+type MountPoint struct {
+	Type        string   `json:"type"`                  // bind、volume 或 tmpfs
+	Name        string   `json:"name,omitempty"`        // 数据卷名称，仅 volume
+	Source      string   `json:"source,omitempty"`      // bind 为宿主机路径，volume 为数据卷的数据目录
+	Destination string   `json:"destination"`           // 容器内的路径
+	ReadOnly    bool     `json:"readOnly"`              // 是否只读
+	Propagation string   `json:"propagation,omitempty"` // 挂载传播方式，bind 默认为 rprivate
+	Anonymous   bool     `json:"anonymous,omitempty"`   // 是否为匿名数据卷，匿名数据卷随容器一起删除
+	NoCopy      bool     `json:"noCopy,omitempty"`      // 数据卷为空时不复制镜像中目标路径下的内容，仅 volume
+	Driver      string   `json:"driver,omitempty"`      // 数据卷的驱动，仅 volume；为空时使用已有数据卷的驱动或 local
+	Options     []string `json:"options,omitempty"`     // tmpfs 的 size、mode 选项
+}
+
+// tmpfsSizeRegexp 是 tmpfs-size 的格式：字节数，可以带 k、m、g 单位
+var tmpfsSizeRegexp = regexp.MustCompile(`^[0-9]+[kKmMgG]?$`)
+
+// ParseVolume 解析 -v 的参数：
+//
+//	/宿主机路径:/容器路径[:选项]   bind 挂载，宿主机路径不存在时由调用方创建
+//	数据卷名:/容器路径[:选项]     挂载数据卷，数据卷不存在时自动创建
+//	/容器路径                     挂载匿名数据卷
+//
+// 选项以逗号分隔：ro、rw，bind 挂载的传播方式 private、rprivate、shared、rshared、slave、rslave，
+// 以及数据卷的 nocopy
+func ParseVolume(spec string) (MountPoint, error) {
+	parts := strings.Split(spec, ":")
+	m := MountPoint{}
+	switch len(parts) {
+	case 1:
+		m.Type, m.Destination, m.Anonymous = MountTypeVolume, parts[0], true
+	case 2, 3:
+		m.Source, m.Destination = parts[0], parts[1]
+		if filepath.IsAbs(m.Source) {
+			m.Type = MountTypeBind
+		} else {
+			m.Type, m.Name, m.Source = MountTypeVolume, m.Source, ""
+		}
+	default:
+		return MountPoint{}, fmt.Errorf("invalid volume specification %q", spec)
+	}
+	if len(parts) == 3 {
+		for _, o := range strings.Split(parts[2], ",") {
+			switch {
+			case o == "ro":
+				m.ReadOnly = true
+			case o == "rw":
+				m.ReadOnly = false
+			case propagationFlags[o] != 0 && m.Type == MountTypeBind:
+				m.Propagation = o
+			case o == "nocopy" && m.Type == MountTypeVolume:
+				m.NoCopy = true
+			default:
+				return MountPoint{}, fmt.Errorf("invalid volume specification %q: invalid mode %q", spec, o)
+			}
+		}
+	}
+	if err := m.validate(); err != nil {
+		return MountPoint{}, fmt.Errorf("invalid volume specification %q: %v", spec, err)
+	}
+	return m, nil
+}
+
+// ParseMount 解析 --mount 的参数，格式为逗号分隔的 key=value：
+//
+//	type=bind|volume|tmpfs（默认 volume）、source|src、destination|dst|target、readonly|ro[=true|false]、
+//	bind-propagation（仅 bind）、volume-nocopy[=true|false]、volume-driver（仅 volume）、tmpfs-size、tmpfs-mode（仅 tmpfs）
+//
+// bind 的源路径必须已经存在；volume 没有 source 时为匿名数据卷
+func ParseMount(spec string) (MountPoint, error) {
+	m := MountPoint{Type: MountTypeVolume}
+	var tmpfsOptions []string
+	volumeOptions := false
+	for _, field := range strings.Split(spec, ",") {
+		kv := strings.SplitN(field, "=", 2)
+		key, value := strings.ToLower(strings.TrimSpace(kv[0])), ""
+		if len(kv) == 2 {
+			value = kv[1]
+		}
+		switch key {
+		case "type":
+			m.Type = value
+		case "source", "src":
+			m.Source = value
+		case "destination", "dst", "target":
+			m.Destination = value
+		case "readonly", "ro":
+			if len(kv) == 1 {
+				m.ReadOnly = true
+				break
+			}
+			ro, err := strconv.ParseBool(value)
+			if err != nil {
+				return MountPoint{}, fmt.Errorf("invalid mount %q: invalid value for %s: %s", spec, key, value)
+			}
+			m.ReadOnly = ro
+		case "bind-propagation":
+			if propagationFlags[value] == 0 {
+				return MountPoint{}, fmt.Errorf("invalid mount %q: invalid propagation mode %q", spec, value)
+			}
+			m.Propagation = value
+		case "volume-nocopy":
+			volumeOptions = true
+			if len(kv) == 1 {
+				m.NoCopy = true
+				break
+			}
+			noCopy, err := strconv.ParseBool(value)
+			if err != nil {
+				return MountPoint{}, fmt.Errorf("invalid mount %q: invalid value for %s: %s", spec, key, value)
+			}
+			m.NoCopy = noCopy
+		case "volume-driver":
+			volumeOptions = true
+			m.Driver = value
+		case "tmpfs-size":
+			if !tmpfsSizeRegexp.MatchString(value) {
+				return MountPoint{}, fmt.Errorf("invalid mount %q: invalid tmpfs size %q", spec, value)
+			}
+			tmpfsOptions = append(tmpfsOptions, "size="+value)
+		case "tmpfs-mode":
+			if _, err := strconv.ParseUint(value, 8, 32); err != nil {
+				return MountPoint{}, fmt.Errorf("invalid mount %q: invalid tmpfs mode %q", spec, value)
+			}
+			tmpfsOptions = append(tmpfsOptions, "mode="+value)
+		default:
+			return MountPoint{}, fmt.Errorf("invalid mount %q: unknown option %q", spec, key)
+		}
+	}
+
+	switch m.Type {
+	case MountTypeBind:
+		if m.Source == "" {
+			return MountPoint{}, fmt.Errorf("invalid mount %q: bind mounts require a source", spec)
+		}
+		if !filepath.IsAbs(m.Source) {
+			return MountPoint{}, fmt.Errorf("invalid mount %q: bind source %s is not an absolute path", spec, m.Source)
+		}
+		if exist, _ := PathExists(m.Source); !exist {
+			return MountPoint{}, fmt.Errorf("invalid mount %q: bind source path %s does not exist", spec, m.Source)
+		}
+	case MountTypeVolume:
+		m.Name, m.Source, m.Anonymous = m.Source, "", m.Source == ""
+	case MountTypeTmpfs:
+		if m.Source != "" {
+			return MountPoint{}, fmt.Errorf("invalid mount %q: tmpfs mounts do not take a source", spec)
+		}
+		m.Options = tmpfsOptions
+	default:
+		return MountPoint{}, fmt.Errorf("invalid mount %q: unsupported type %q", spec, m.Type)
+	}
+	if m.Propagation != "" && m.Type != MountTypeBind {
+		return MountPoint{}, fmt.Errorf("invalid mount %q: bind-propagation is only valid for bind mounts", spec)
+	}
+	if volumeOptions && m.Type != MountTypeVolume {
+		return MountPoint{}, fmt.Errorf("invalid mount %q: volume options are only valid for volume mounts", spec)
+	}
+	if len(tmpfsOptions) > 0 && m.Type != MountTypeTmpfs {
+		return MountPoint{}, fmt.Errorf("invalid mount %q: tmpfs options are only valid for tmpfs mounts", spec)
+	}
+	if err := m.validate(); err != nil {
+		return MountPoint{}, fmt.Errorf("invalid mount %q: %v", spec, err)
+	}
+	return m, nil
+}
+
+// tmpfsDataOptions 是 --tmpfs 允许的 tmpfs 私有选项
+var tmpfsDataOptions = map[string]bool{"size": true, "mode": true, "uid": true, "gid": true, "nr_inodes": true, "nr_blocks": true}
+
+// ParseTmpfs 解析 --tmpfs 的参数 /容器路径[:选项]，选项以逗号分隔：
+// size、mode、uid、gid、nr_inodes、nr_blocks，以及 ro、rw、exec、noexec、suid、nosuid、dev、nodev 等挂载选项
+// 默认为 rw,noexec,nosuid,nodev，与 docker 一致
+func ParseTmpfs(spec string) (MountPoint, error) {
+	parts := strings.SplitN(spec, ":", 2)
+	m := MountPoint{Type: MountTypeTmpfs, Destination: parts[0]}
+	if len(parts) == 2 && parts[1] != "" {
+		for _, o := range strings.Split(parts[1], ",") {
+			kv := strings.SplitN(o, "=", 2)
+			switch {
+			case o == "ro":
+				m.ReadOnly = true
+			case o == "rw":
+				m.ReadOnly = false
+			case len(kv) == 1 && mountFlags[o].flag != 0 && o != "bind" && o != "rbind" && o != "remount":
+				m.Options = append(m.Options, o)
+			case len(kv) == 2 && tmpfsDataOptions[kv[0]]:
+				if kv[0] == "size" && !tmpfsSizeRegexp.MatchString(kv[1]) {
+					return MountPoint{}, fmt.Errorf("invalid tmpfs %q: invalid size %q", spec, kv[1])
+				}
+				if kv[0] == "mode" {
+					if _, err := strconv.ParseUint(kv[1], 8, 32); err != nil {
+						return MountPoint{}, fmt.Errorf("invalid tmpfs %q: invalid mode %q", spec, kv[1])
+					}
+				} else if kv[0] != "size" {
+					if _, err := strconv.ParseUint(kv[1], 10, 64); err != nil {
+						return MountPoint{}, fmt.Errorf("invalid tmpfs %q: invalid %s %q", spec, kv[0], kv[1])
+					}
+				}
+				m.Options = append(m.Options, o)
+			default:
+				return MountPoint{}, fmt.Errorf("invalid tmpfs %q: unknown option %q", spec, o)
+			}
+		}
+	}
+	if err := m.validate(); err != nil {
+		return MountPoint{}, fmt.Errorf("invalid tmpfs %q: %v", spec, err)
+	}
+	return m, nil
+}
+
+// validate 检查挂载的目标路径
+func (m *MountPoint) validate() error {
+	if m.Destination == "" {
+		return fmt.Errorf("destination is required")
+	}
+	if !filepath.IsAbs(m.Destination) {
+		return fmt.Errorf("destination %s is not an absolute path", m.Destination)
+	}
+	m.Destination = filepath.Clean(m.Destination)
+	if m.Destination == "/" {
+		return fmt.Errorf("destination can't be '/'")
+	}
+	if m.Type == MountTypeBind && m.Propagation == "" {
+		m.Propagation = "rprivate"
+	}
+	return nil
+}
+
+// ValidateMountPoints 检查多个挂载的目标路径不重复
+func ValidateMountPoints(mounts []MountPoint) error {
+	seen := map[string]bool{}
+	for _, m := range mounts {
+		if seen[m.Destination] {
+			return fmt.Errorf("duplicate mount point: %s", m.Destination)
+		}
+		seen[m.Destination] = true
+	}
+	return nil
+}
+
+// InitMounts 把挂载转换为 init 进程执行的挂载，volume 的 Source 必须已经解析为数据卷的数据目录
+// 父目录先于子目录挂载，否则子目录的挂载会被父目录的挂载遮住
+func InitMounts(mounts []MountPoint) []Mount {
+	sorted := append([]MountPoint{}, mounts...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return strings.Count(sorted[i].Destination, "/") < strings.Count(sorted[j].Destination, "/")
+	})
+	var result []Mount
+	for _, m := range sorted {
+		mode := "rw"
+		if m.ReadOnly {
+			mode = "ro"
+		}
+		switch m.Type {
+		case MountTypeTmpfs:
+			options := append([]string{"nosuid", "nodev", "noexec", mode}, m.Options...)
+			result = append(result, Mount{Source: "tmpfs", Destination: m.Destination, Type: "tmpfs", Options: options})
+		default:
+			propagation := m.Propagation
+			if propagation == "" {
+				propagation = "rprivate"
+			}
+			result = append(result, Mount{Source: m.Source, Destination: m.Destination, Type: "bind",
+				Options: []string{"rbind", mode, propagation}})
+		}
+	}
+	return result
+}
+
+// RootPropagation 返回容器根挂载需要的传播方式
+// 有 shared 的 bind 挂载时为 rshared，这样 bind 挂载才能与宿主机上的源挂载处于同一个 peer group；
+// 有 slave 的 bind 挂载时为 rslave；否则为空，使用默认的 rprivate
+func RootPropagation(mounts []MountPoint) string {
+	root := ""
+	for _, m := range mounts {
+		switch m.Propagation {
+		case "shared", "rshared":
+			return "rshared"
+		case "slave", "rslave":
+			root = "rslave"
+		}
+	}
+	return root
+}
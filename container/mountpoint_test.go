@@ -0,0 +1,126 @@
+package container
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseVolume(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    MountPoint
+		wantErr bool
+	}{
+		{in: "/data", want: MountPoint{Type: MountTypeVolume, Destination: "/data", Anonymous: true}},
+		{in: "/data/", want: MountPoint{Type: MountTypeVolume, Destination: "/data", Anonymous: true}},
+		{in: "/host:/data", want: MountPoint{Type: MountTypeBind, Source: "/host", Destination: "/data", Propagation: "rprivate"}},
+		{in: "/host:/data:ro", want: MountPoint{Type: MountTypeBind, Source: "/host", Destination: "/data", ReadOnly: true, Propagation: "rprivate"}},
+		{in: "/host:/data:ro,rshared", want: MountPoint{Type: MountTypeBind, Source: "/host", Destination: "/data", ReadOnly: true, Propagation: "rshared"}},
+		{in: "vol:/data", want: MountPoint{Type: MountTypeVolume, Name: "vol", Destination: "/data"}},
+		{in: "vol:/data:ro,nocopy", want: MountPoint{Type: MountTypeVolume, Name: "vol", Destination: "/data", ReadOnly: true, NoCopy: true}},
+
+		{in: "", wantErr: true},
+		{in: "data", wantErr: true},
+		{in: "/host:data", wantErr: true},
+		{in: "/host:/", wantErr: true},
+		{in: "/host:/data:bogus", wantErr: true},
+		{in: "/host:/data:nocopy", wantErr: true},
+		{in: "vol:/data:rshared", wantErr: true},
+		{in: "/a:/b:ro:extra", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseVolume(tt.in)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseVolume(%q) = %+v, want an error", tt.in, got)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseVolume(%q) = %+v, %v, want %+v", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestParseMount(t *testing.T) {
+	host := t.TempDir()
+	tests := []struct {
+		in      string
+		want    MountPoint
+		wantErr bool
+	}{
+		{in: "target=/data", want: MountPoint{Type: MountTypeVolume, Destination: "/data", Anonymous: true}},
+		{in: "type=volume,src=vol,dst=/data,ro", want: MountPoint{Type: MountTypeVolume, Name: "vol", Destination: "/data", ReadOnly: true}},
+		{in: "type=volume,source=vol,destination=/data,readonly=false,volume-nocopy,volume-driver=local",
+			want: MountPoint{Type: MountTypeVolume, Name: "vol", Destination: "/data", NoCopy: true, Driver: "local"}},
+		{in: "type=bind,source=" + host + ",target=/data,bind-propagation=shared",
+			want: MountPoint{Type: MountTypeBind, Source: host, Destination: "/data", Propagation: "shared"}},
+		{in: "type=bind,src=" + host + ",dst=/data,readonly=true",
+			want: MountPoint{Type: MountTypeBind, Source: host, Destination: "/data", ReadOnly: true, Propagation: "rprivate"}},
+		{in: "type=tmpfs,dst=/run,tmpfs-size=64m,tmpfs-mode=1777",
+			want: MountPoint{Type: MountTypeTmpfs, Destination: "/run", Options: []string{"size=64m", "mode=1777"}}},
+
+		{in: "", wantErr: true},
+		{in: "type=bind,target=/data", wantErr: true},
+		{in: "type=bind,source=relative,target=/data", wantErr: true},
+		{in: "type=bind,source=" + filepath.Join(host, "missing") + ",target=/data", wantErr: true},
+		{in: "type=bind,source=" + host + ",target=/data,volume-nocopy", wantErr: true},
+		{in: "type=volume,target=/data,bind-propagation=shared", wantErr: true},
+		{in: "type=volume,target=/data,bind-propagation=bogus", wantErr: true},
+		{in: "type=volume,target=/data,tmpfs-size=1m", wantErr: true},
+		{in: "type=tmpfs,source=x,target=/run", wantErr: true},
+		{in: "type=tmpfs,target=/run,tmpfs-size=lots", wantErr: true},
+		{in: "type=tmpfs,target=/run,tmpfs-mode=999", wantErr: true},
+		{in: "type=nfs,target=/data", wantErr: true},
+		{in: "target=/data,readonly=maybe", wantErr: true},
+		{in: "target=/data,unknown=1", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseMount(tt.in)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseMount(%q) = %+v, want an error", tt.in, got)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseMount(%q) = %+v, %v, want %+v", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestParseTmpfs(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    MountPoint
+		wantErr bool
+	}{
+		{in: "/run", want: MountPoint{Type: MountTypeTmpfs, Destination: "/run"}},
+		{in: "/run:", want: MountPoint{Type: MountTypeTmpfs, Destination: "/run"}},
+		{in: "/run:ro,size=64m,mode=1777", want: MountPoint{Type: MountTypeTmpfs, Destination: "/run", ReadOnly: true, Options: []string{"size=64m", "mode=1777"}}},
+		{in: "/tmp:exec,uid=1000,gid=1000,nr_inodes=1024", want: MountPoint{Type: MountTypeTmpfs, Destination: "/tmp", Options: []string{"exec", "uid=1000", "gid=1000", "nr_inodes=1024"}}},
+
+		{in: "", wantErr: true},
+		{in: "run", wantErr: true},
+		{in: "/", wantErr: true},
+		{in: "/run:size=lots", wantErr: true},
+		{in: "/run:mode=8", wantErr: true},
+		{in: "/run:uid=root", wantErr: true},
+		{in: "/run:bind", wantErr: true},
+		{in: "/run:bogus", wantErr: true},
+		{in: "/run:bogus=1", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseTmpfs(tt.in)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseTmpfs(%q) = %+v, want an error", tt.in, got)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseTmpfs(%q) = %+v, %v, want %+v", tt.in, got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package container
+
+import (
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+	"os"
+	"runtime"
+	"strings"
+	"syscall"
+)
+
+// ------------------------
+// 命名空间共享模式
+// ------------------------
+
+const (
+	NamespaceHost            = "host"       // 与宿主机共享该命名空间（不 clone 新的）
+	NamespaceNone            = "none"       // 仅用于网络：新的网络命名空间，只有回环接口
+	namespaceContainerPrefix = "container:" // container:<name> 加入另一个容器的命名空间
+	namespacePathPrefix      = "path:"      // path:<file> 加入 /proc/<pid>/ns/<type> 等命名空间文件指向的命名空间（OCI bundle 使用）
+)
+
+// NamespaceConfig 记录各个命名空间的模式
+// 取值为空表示创建私有的命名空间；host 表示与宿主机共享；container:<name> 表示加入该容器的命名空间；
+// path:<file> 表示加入命名空间文件指向的命名空间
+// Net 除上述取值外还可以是 none 或者一个通过 network create 创建的网络名称
+type NamespaceConfig struct {
+	Net string `json:"net"`
+	Pid string `json:"pid"`
+	Ipc string `json:"ipc"`
+	Uts string `json:"uts"`
+}
+
+// namespaceKind 描述一种可配置的命名空间
+type namespaceKind struct {
+	name string // /proc/<pid>/ns 下的文件名
+	flag int    // 对应的 clone flag
+}
+
+// 可配置的命名空间，mnt 命名空间总是私有的，不在此列
+var namespaceKinds = []namespaceKind{
+	{name: "net", flag: syscall.CLONE_NEWNET},
+	{name: "pid", flag: syscall.CLONE_NEWPID},
+	{name: "ipc", flag: syscall.CLONE_NEWIPC},
+	{name: "uts", flag: syscall.CLONE_NEWUTS},
+}
+
+// mode 返回指定命名空间的模式
+func (c *NamespaceConfig) mode(kind string) string {
+	switch kind {
+	case "net":
+		return c.Net
+	case "pid":
+		return c.Pid
+	case "ipc":
+		return c.Ipc
+	case "uts":
+		return c.Uts
+	}
+	return ""
+}
+
+// SharedContainer 解析 container:<name> 形式的模式，返回被共享的容器名
+func SharedContainer(mode string) (string, bool) {
+	if !strings.HasPrefix(mode, namespaceContainerPrefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(mode, namespaceContainerPrefix), true
+}
+
+// NamespacePath 返回 path:<file> 形式的模式对应的命名空间文件
+func NamespacePath(file string) string {
+	return namespacePathPrefix + file
+}
+
+// sharedPath 解析 path:<file> 形式的模式
+func sharedPath(mode string) (string, bool) {
+	if !strings.HasPrefix(mode, namespacePathPrefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(mode, namespacePathPrefix), true
+}
+
+// IsPrivate 判断指定命名空间是否由容器独享（需要 clone 新的命名空间）
+func (c *NamespaceConfig) IsPrivate(kind string) bool {
+	mode := c.mode(kind)
+	if mode == NamespaceHost {
+		return false
+	}
+	_, sharedContainer := SharedContainer(mode)
+	_, sharedFile := sharedPath(mode)
+	return !sharedContainer && !sharedFile
+}
+
+// Validate 检查各命名空间模式的取值是否合法
+func (c *NamespaceConfig) Validate() error {
+	for _, ns := range namespaceKinds {
+		mode := c.mode(ns.name)
+		if name, ok := SharedContainer(mode); ok {
+			if name == "" {
+				return fmt.Errorf("--%s %s: missing container name", ns.name, mode)
+			}
+			continue
+		}
+		if file, ok := sharedPath(mode); ok {
+			if file == "" {
+				return fmt.Errorf("--%s %s: missing namespace path", ns.name, mode)
+			}
+			continue
+		}
+		// 网络模式还可以是 none 或网络名称
+		if ns.name == "net" {
+			continue
+		}
+		if mode != "" && mode != NamespaceHost {
+			return fmt.Errorf("--%s %s: unsupported namespace mode, use host or container:<name>", ns.name, mode)
+		}
+	}
+	return nil
+}
+
+// Cloneflags 返回创建容器进程时需要的 clone flag
+// 挂载命名空间总是新建，其余命名空间仅在私有模式下新建
+func (c *NamespaceConfig) Cloneflags() uintptr {
+	flags := syscall.CLONE_NEWNS
+	for _, ns := range namespaceKinds {
+		if c.IsPrivate(ns.name) {
+			flags |= ns.flag
+		}
+	}
+	return uintptr(flags)
+}
+
+// Enter 让当前 OS 线程加入所有 container:<name> 和 path:<file> 模式指定的命名空间
+// 之后由该线程 fork 出的子进程会继承这些命名空间（pid 命名空间只对子进程生效）
+// pidOf 用于根据容器名查找容器 init 进程的 PID
+// 返回的函数用于恢复原来的命名空间，必须在启动子进程后调用
+func (c *NamespaceConfig) Enter(pidOf func(containerName string) (string, error)) (func(), error) {
+	runtime.LockOSThread()
+
+	var origins []*os.File
+	var flags []int
+	restore := func() {
+		ok := true
+		for i := len(origins) - 1; i >= 0; i-- {
+			if err := unix.Setns(int(origins[i].Fd()), flags[i]); err != nil {
+				log.Errorf("Restore namespace %s error %v", origins[i].Name(), err)
+				ok = false
+			}
+			origins[i].Close()
+		}
+		// 恢复失败时保持线程锁定，让运行时在 goroutine 结束后丢弃这个线程
+		if ok {
+			runtime.UnlockOSThread()
+		}
+	}
+
+	for _, ns := range namespaceKinds {
+		mode := c.mode(ns.name)
+		nsFile, shared := sharedPath(mode)
+		name, sharedContainer := SharedContainer(mode)
+		if sharedContainer {
+			pid, err := pidOf(name)
+			if err != nil {
+				restore()
+				return nil, fmt.Errorf("get container %s pid error %v", name, err)
+			}
+			nsFile, shared = fmt.Sprintf("/proc/%s/ns/%s", pid, ns.name), true
+		}
+		if !shared {
+			continue
+		}
+		target, err := os.Open(nsFile)
+		if err != nil {
+			restore()
+			return nil, fmt.Errorf("open %s namespace %s error %v", ns.name, mode, err)
+		}
+		// pid 命名空间需要恢复的是 pid_for_children，其余为线程当前所在的命名空间
+		originPath := fmt.Sprintf("/proc/thread-self/ns/%s", ns.name)
+		if ns.name == "pid" {
+			originPath = "/proc/thread-self/ns/pid_for_children"
+		}
+		origin, err := os.Open(originPath)
+		if err != nil {
+			target.Close()
+			restore()
+			return nil, fmt.Errorf("open %s error %v", originPath, err)
+		}
+		err = unix.Setns(int(target.Fd()), ns.flag)
+		target.Close()
+		if err != nil {
+			origin.Close()
+			restore()
+			return nil, fmt.Errorf("join %s namespace %s error %v", ns.name, mode, err)
+		}
+		origins = append(origins, origin)
+		flags = append(flags, ns.flag)
+		log.Infof("Join %s namespace %s (%s)", ns.name, mode, nsFile)
+	}
+	return restore, nil
+}
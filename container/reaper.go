@@ -0,0 +1,97 @@
+package container
+
+import (
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+)
+
+// runAsInit 让 mydocker 的 init 进程保持为容器内的 PID 1（--init 模式）
+// 它 fork 出用户进程，把所有可捕获的信号转发给用户进程，回收所有僵尸进程，
+// 并在用户进程退出后以相同的退出码退出。该函数不会返回（除非启动用户进程失败）
+// 用户进程以 cred 指定的身份运行，init 自身保持 root；用户进程启动后关闭 errPipe，通知父进程初始化完成
+func runAsInit(path string, argv []string, env []string, cred *syscall.Credential, errPipe *os.File) error {
+	// 不处于新的 PID 命名空间时（--pid host 或 container:<name>），init 不是 PID 1，
+	// 设置为 subreaper 后孤儿进程同样会被过继给它
+	if err := unix.Prctl(unix.PR_SET_CHILD_SUBREAPER, 1, 0, 0, 0); err != nil {
+		log.Warnf("Set child subreaper error %v", err)
+	}
+
+	// 在启动用户进程之前注册信号，避免丢失子进程很快退出时的 SIGCHLD
+	sigs := make(chan os.Signal, 128)
+	signal.Notify(sigs)
+
+	cmd := exec.Command(path)
+	cmd.Args = argv
+	cmd.Env = env
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	// 用户进程放到独立的进程组，终端产生的信号只发给它一次，不会由 init 重复转发
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true, Credential: cred}
+	if isTerminal(os.Stdin) {
+		cmd.SysProcAttr.Foreground = true
+		cmd.SysProcAttr.Ctty = int(os.Stdin.Fd())
+	}
+	if err := cmd.Start(); err != nil {
+		signal.Reset()
+		return fmt.Errorf("start user process error %v", err)
+	}
+	childPid := cmd.Process.Pid
+	log.Infof("Init forked user process %d", childPid)
+	errPipe.Close()
+
+	for sig := range sigs {
+		switch sig {
+		case syscall.SIGCHLD:
+			// 回收所有已退出的子进程，用户进程退出时 init 随之退出
+			if status, exited := reapChildren(childPid); exited {
+				os.Exit(status)
+			}
+		case syscall.SIGURG:
+			// Go 运行时用于抢占调度的信号，不转发
+		default:
+			if err := syscall.Kill(childPid, sig.(syscall.Signal)); err != nil {
+				log.Warnf("Forward signal %v to %d error %v", sig, childPid, err)
+			}
+		}
+	}
+	return nil
+}
+
+// reapChildren 非阻塞地回收所有僵尸子进程
+// 如果其中包含用户进程，返回它的退出码（被信号杀死时为 128+信号值）
+func reapChildren(childPid int) (int, bool) {
+	status, exited := 0, false
+	for {
+		var ws syscall.WaitStatus
+		pid, err := syscall.Wait4(-1, &ws, syscall.WNOHANG, nil)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil || pid <= 0 {
+			return status, exited
+		}
+		if pid != childPid {
+			log.Debugf("Reaped zombie process %d", pid)
+			continue
+		}
+		exited = true
+		if ws.Signaled() {
+			status = 128 + int(ws.Signal())
+		} else {
+			status = ws.ExitStatus()
+		}
+		log.Infof("User process %d exited with status %d", pid, status)
+	}
+}
+
+// isTerminal 判断文件是否为终端
+func isTerminal(f *os.File) bool {
+	_, err := unix.IoctlGetTermios(int(f.Fd()), unix.TCGETS)
+	return err == nil
+}
@@ -0,0 +1,161 @@
+package container
+
+import (
+	"encoding/json"
+	"fmt"
+	"golang.org/x/sys/unix"
+	"io/ioutil"
+	"os"
+	"strings"
+	"syscall"
+)
+
+// InitSpecVersion 是父进程与容器 init 进程之间 JSON 协议的版本
+// 修改 InitSpec 的字段含义时需要递增，init 进程会拒绝不认识的版本
+const InitSpecVersion = 1
+
+// init 进程中父子进程通信管道的文件描述符（对应 cmd.ExtraFiles 的顺序）
+const (
+	initSpecFd  = 3 // 父进程写入 InitSpec
+	initErrorFd = 4 // 子进程回报初始化错误
+)
+
+// InitSpec 描述容器 init 进程需要完成的全部设置，由父进程通过 3 号文件描述符以 JSON 形式发送
+type InitSpec struct {
+	Version  int      `json:"version"`            // 协议版本，即 InitSpecVersion
+	Args     []string `json:"args"`               // 用户命令及参数
+	Env      []string `json:"env"`                // 用户进程的环境变量
+	Cwd      string   `json:"cwd"`                // 用户进程的工作目录（容器内路径）
+	User     string   `json:"user"`               // 运行用户，格式为 user[:group]，可以是名称或数字 ID
+	Hostname string   `json:"hostname,omitempty"` // 主机名，为空表示不设置
+	Rlimits  []Rlimit `json:"rlimits,omitempty"`  // 资源限制
+	Mounts   []Mount  `json:"mounts,omitempty"`   // pivot_root 之前挂载到 rootfs 中的挂载点
+	Init     bool     `json:"init"`               // 是否保持为 PID 1（--init）
+
+	RootPropagation string `json:"rootPropagation,omitempty"` // 容器根挂载的传播方式，为空时为 rprivate
+	ReadonlyRoot    bool   `json:"readonlyRoot,omitempty"`    // pivot_root 之后把根文件系统重新挂载为只读（--read-only）
+
+	AdditionalGids     []uint32 `json:"additionalGids,omitempty"`     // 额外的附加组（OCI process.user.additionalGids）
+	OnlyAdditionalGids bool     `json:"onlyAdditionalGids,omitempty"` // 附加组只使用 AdditionalGids，不从容器的 /etc/group 中查找（OCI bundle）
+	ExecFifo           string   `json:"execFifo,omitempty"`           // 非空时初始化完成后阻塞在该 fifo 上，直到 start（OCI create）
+}
+
+// Rlimit 描述一项 setrlimit 资源限制，Type 使用 RLIMIT_NOFILE 这样的名称
+type Rlimit struct {
+	Type string `json:"type"`
+	Hard uint64 `json:"hard"`
+	Soft uint64 `json:"soft"`
+}
+
+// rlimitTypes 是 Rlimit.Type 到资源编号的映射
+var rlimitTypes = map[string]int{
+	"RLIMIT_AS":         unix.RLIMIT_AS,
+	"RLIMIT_CORE":       unix.RLIMIT_CORE,
+	"RLIMIT_CPU":        unix.RLIMIT_CPU,
+	"RLIMIT_DATA":       unix.RLIMIT_DATA,
+	"RLIMIT_FSIZE":      unix.RLIMIT_FSIZE,
+	"RLIMIT_LOCKS":      unix.RLIMIT_LOCKS,
+	"RLIMIT_MEMLOCK":    unix.RLIMIT_MEMLOCK,
+	"RLIMIT_MSGQUEUE":   unix.RLIMIT_MSGQUEUE,
+	"RLIMIT_NICE":       unix.RLIMIT_NICE,
+	"RLIMIT_NOFILE":     unix.RLIMIT_NOFILE,
+	"RLIMIT_NPROC":      unix.RLIMIT_NPROC,
+	"RLIMIT_RSS":        unix.RLIMIT_RSS,
+	"RLIMIT_RTPRIO":     unix.RLIMIT_RTPRIO,
+	"RLIMIT_RTTIME":     unix.RLIMIT_RTTIME,
+	"RLIMIT_SIGPENDING": unix.RLIMIT_SIGPENDING,
+	"RLIMIT_STACK":      unix.RLIMIT_STACK,
+}
+
+// ParseRlimit 解析 nofile=1024:2048 形式的资源限制，只给出一个值时软硬限制相同
+func ParseRlimit(s string) (Rlimit, error) {
+	kv := strings.SplitN(s, "=", 2)
+	if len(kv) != 2 {
+		return Rlimit{}, fmt.Errorf("invalid ulimit %q, expect name=soft[:hard]", s)
+	}
+	r := Rlimit{Type: "RLIMIT_" + strings.ToUpper(kv[0])}
+	if _, ok := rlimitTypes[r.Type]; !ok {
+		return Rlimit{}, fmt.Errorf("invalid ulimit %q, unknown resource %s", s, kv[0])
+	}
+	limits := strings.SplitN(kv[1], ":", 2)
+	if _, err := fmt.Sscan(limits[0], &r.Soft); err != nil {
+		return Rlimit{}, fmt.Errorf("invalid ulimit %q, %v", s, err)
+	}
+	r.Hard = r.Soft
+	if len(limits) == 2 {
+		if _, err := fmt.Sscan(limits[1], &r.Hard); err != nil {
+			return Rlimit{}, fmt.Errorf("invalid ulimit %q, %v", s, err)
+		}
+	}
+	return r, nil
+}
+
+// initError 是 init 进程通过 4 号文件描述符回报给父进程的错误
+type initError struct {
+	Message string `json:"message"`
+}
+
+// SendInitSpec 把 InitSpec 写入管道并关闭写端，init 进程读到 EOF 后开始初始化
+func SendInitSpec(spec *InitSpec, writePipe *os.File) error {
+	defer writePipe.Close()
+	spec.Version = InitSpecVersion
+	if err := json.NewEncoder(writePipe).Encode(spec); err != nil {
+		return fmt.Errorf("send init spec error %v", err)
+	}
+	return nil
+}
+
+// WaitInitResult 等待 init 进程完成初始化
+// 错误管道的写端在 init 进程 exec 用户命令时被自动关闭（close-on-exec），
+// 因此读到 EOF 且没有内容表示初始化成功，否则返回 init 进程回报的错误
+func WaitInitResult(errPipe *os.File) error {
+	defer errPipe.Close()
+	msg, err := ioutil.ReadAll(errPipe)
+	if err != nil {
+		return fmt.Errorf("read init error pipe error %v", err)
+	}
+	if len(msg) == 0 {
+		return nil
+	}
+	var ie initError
+	if err := json.Unmarshal(msg, &ie); err != nil {
+		return fmt.Errorf("container init failed: %s", string(msg))
+	}
+	return fmt.Errorf("container init failed: %s", ie.Message)
+}
+
+// readInitSpec 从 3 号文件描述符读取并校验 InitSpec
+func readInitSpec() (*InitSpec, error) {
+	pipe := os.NewFile(uintptr(initSpecFd), "pipe")
+	defer pipe.Close()
+	spec := &InitSpec{}
+	if err := json.NewDecoder(pipe).Decode(spec); err != nil {
+		return nil, fmt.Errorf("decode init spec error %v", err)
+	}
+	if spec.Version != InitSpecVersion {
+		return nil, fmt.Errorf("unsupported init spec version %d, expect %d", spec.Version, InitSpecVersion)
+	}
+	if len(spec.Args) == 0 {
+		return nil, fmt.Errorf("init spec has no user command")
+	}
+	return spec, nil
+}
+
+// reportInitError 把初始化错误写回父进程
+func reportInitError(errPipe *os.File, err error) {
+	json.NewEncoder(errPipe).Encode(&initError{Message: err.Error()})
+}
+
+// setRlimits 设置 init 进程的资源限制，exec 或 fork 出的用户进程会继承
+func setRlimits(rlimits []Rlimit) error {
+	for _, r := range rlimits {
+		resource, ok := rlimitTypes[r.Type]
+		if !ok {
+			return fmt.Errorf("unknown rlimit type %s", r.Type)
+		}
+		if err := syscall.Setrlimit(resource, &syscall.Rlimit{Cur: r.Soft, Max: r.Hard}); err != nil {
+			return fmt.Errorf("setrlimit %s error %v", r.Type, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,215 @@
+package container
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// 容器内的用户和组数据库（容器内路径）
+const (
+	passwdPath = "/etc/passwd"
+	groupPath  = "/etc/group"
+)
+
+// execUser 是解析后的运行用户
+type execUser struct {
+	Uid   uint32
+	Gid   uint32
+	Sgids []uint32 // 附加组
+	Home  string   // 用户主目录
+}
+
+// passwdEntry 对应 /etc/passwd 中的一行
+type passwdEntry struct {
+	name string
+	uid  uint32
+	gid  uint32
+	home string
+}
+
+// groupEntry 对应 /etc/group 中的一行
+type groupEntry struct {
+	name    string
+	gid     uint32
+	members []string
+}
+
+// lookupUser 解析 user[:group] 形式的运行用户，user 和 group 可以是名称或数字 ID
+// 名称按 rootfs 中的 /etc/passwd 和 /etc/group 解析；附加组为 /etc/group 中包含该用户的所有组
+// init 进程在 pivot_root 之后以 / 作为 rootfs 调用；为空时以 root 运行
+func lookupUser(rootfs, spec string) (*execUser, error) {
+	u := &execUser{Home: "/"}
+	if spec == "" {
+		spec = "0"
+	}
+	userPart, groupPart := spec, ""
+	if i := strings.Index(spec, ":"); i >= 0 {
+		userPart, groupPart = spec[:i], spec[i+1:]
+	}
+
+	passwdFile, err := ResolveInRootfs(rootfs, passwdPath)
+	if err != nil {
+		return nil, err
+	}
+	passwd, err := readPasswd(passwdFile)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	groupFile, err := ResolveInRootfs(rootfs, groupPath)
+	if err != nil {
+		return nil, err
+	}
+	groups, err := readGroup(groupFile)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	userName := ""
+	uid, numeric := parseID(userPart)
+	found := false
+	for _, p := range passwd {
+		if (numeric && p.uid == uid) || (!numeric && p.name == userPart) {
+			u.Uid, u.Gid, u.Home, userName = p.uid, p.gid, p.home, p.name
+			found = true
+			break
+		}
+	}
+	if !found {
+		if !numeric {
+			return nil, fmt.Errorf("unable to find user %s: no matching entries in passwd file", userPart)
+		}
+		u.Uid = uid
+	}
+
+	if groupPart != "" {
+		gid, numeric := parseID(groupPart)
+		found := false
+		for _, g := range groups {
+			if (numeric && g.gid == gid) || (!numeric && g.name == groupPart) {
+				u.Gid = g.gid
+				found = true
+				break
+			}
+		}
+		if !found {
+			if !numeric {
+				return nil, fmt.Errorf("unable to find group %s: no matching entries in group file", groupPart)
+			}
+			u.Gid = gid
+		}
+	}
+
+	// 附加组
+	if userName != "" {
+		for _, g := range groups {
+			for _, m := range g.members {
+				if m == userName && g.gid != u.Gid {
+					u.Sgids = append(u.Sgids, g.gid)
+					break
+				}
+			}
+		}
+	}
+	return u, nil
+}
+
+// LookupHome 返回运行用户在 rootfs 中的主目录，用于设置容器的 HOME 环境变量
+func LookupHome(rootfs, user string) (string, error) {
+	u, err := lookupUser(rootfs, user)
+	if err != nil {
+		return "", err
+	}
+	return u.Home, nil
+}
+
+// Credential 转换为 exec 使用的凭据
+func (u *execUser) Credential() *syscall.Credential {
+	return &syscall.Credential{Uid: u.Uid, Gid: u.Gid, Groups: u.Sgids}
+}
+
+// parseID 判断字符串是否为数字 ID
+func parseID(s string) (uint32, bool) {
+	id, err := strconv.ParseUint(s, 10, 32)
+	if err != nil {
+		return 0, false
+	}
+	return uint32(id), true
+}
+
+// readPasswd 读取 passwd 文件，格式为 name:password:uid:gid:gecos:home:shell
+func readPasswd(path string) ([]passwdEntry, error) {
+	var entries []passwdEntry
+	err := readColonFile(path, func(fields []string) {
+		if len(fields) < 6 {
+			return
+		}
+		uid, ok := parseID(fields[2])
+		if !ok {
+			return
+		}
+		gid, _ := parseID(fields[3])
+		entries = append(entries, passwdEntry{name: fields[0], uid: uid, gid: gid, home: fields[5]})
+	})
+	return entries, err
+}
+
+// readGroup 读取 group 文件，格式为 name:password:gid:member1,member2
+func readGroup(path string) ([]groupEntry, error) {
+	var entries []groupEntry
+	err := readColonFile(path, func(fields []string) {
+		if len(fields) < 3 {
+			return
+		}
+		gid, ok := parseID(fields[2])
+		if !ok {
+			return
+		}
+		g := groupEntry{name: fields[0], gid: gid}
+		if len(fields) > 3 && fields[3] != "" {
+			g.members = strings.Split(fields[3], ",")
+		}
+		entries = append(entries, g)
+	})
+	return entries, err
+}
+
+// readColonFile 逐行读取以冒号分隔的文件，跳过空行和注释
+func readColonFile(path string, fn func(fields []string)) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fn(strings.Split(line, ":"))
+	}
+	return scanner.Err()
+}
+
+// setCredential 切换 init 进程的用户、组和附加组，之后 exec 的用户命令以该身份运行
+// 必须先设置组再设置用户，否则失去 root 权限后无法再修改组
+func setCredential(cred *syscall.Credential) error {
+	groups := make([]int, len(cred.Groups))
+	for i, g := range cred.Groups {
+		groups[i] = int(g)
+	}
+	if err := syscall.Setgroups(groups); err != nil {
+		return fmt.Errorf("setgroups %v error %v", groups, err)
+	}
+	if err := syscall.Setgid(int(cred.Gid)); err != nil {
+		return fmt.Errorf("setgid %d error %v", cred.Gid, err)
+	}
+	if err := syscall.Setuid(int(cred.Uid)); err != nil {
+		return fmt.Errorf("setuid %d error %v", cred.Uid, err)
+	}
+	return nil
+}
@@ -16,13 +16,15 @@ const ENV_EXEC_PID = "mydocker_pid" // 环境变量名，用于存储容器的 P
 const ENV_EXEC_CMD = "mydocker_cmd" // 环境变量名，用于存储执行的命令
 
 // ExecContainer 执行指定容器内的命令
+// 命令使用容器记录的环境变量运行，不继承调用者（宿主机）的环境变量
 func ExecContainer(containerName string, comArray []string) {
-	// 获取容器的 PID
-	pid, err := GetContainerPidByName(containerName)
+	// 获取容器信息
+	containerInfo, err := getContainerInfoByName(containerName)
 	if err != nil {
-		log.Errorf("Exec container getContainerPidByName %s error %v", containerName, err)
+		log.Errorf("Exec container getContainerInfoByName %s error %v", containerName, err)
 		return
 	}
+	pid := containerInfo.Pid
 
 	// 将命令数组转化为字符串
 	cmdStr := strings.Join(comArray, " ")
@@ -35,13 +37,13 @@ func ExecContainer(containerName string, comArray []string) {
 	cmd.Stdout = os.Stdout // 将标准输出传递给命令
 	cmd.Stderr = os.Stderr // 将标准错误输出传递给命令
 
-	// 设置环境变量：容器 PID 和执行的命令
-	os.Setenv(ENV_EXEC_PID, pid)
-	os.Setenv(ENV_EXEC_CMD, cmdStr)
-
-	// 获取容器内的环境变量
-	containerEnvs := getEnvsByPid(pid)
-	cmd.Env = append(os.Environ(), containerEnvs...) // 将容器的环境变量添加到执行命令的环境中
+	// 使用容器记录的环境变量，旧版本记录的容器没有该字段时从容器进程读取
+	containerEnvs := containerInfo.Env
+	if containerEnvs == nil {
+		containerEnvs = getEnvsByPid(pid)
+	}
+	// 设置环境变量：容器 PID 和执行的命令，nsenter 读取后会将它们删除
+	cmd.Env = append(append([]string{}, containerEnvs...), ENV_EXEC_PID+"="+pid, ENV_EXEC_CMD+"="+cmdStr)
 
 	// 执行命令
 	if err := cmd.Run(); err != nil {
@@ -3,14 +3,16 @@ module go-docker
 go 1.23.2
 
 require (
+	github.com/klauspost/compress v1.18.0
 	github.com/sirupsen/logrus v1.9.3
+	github.com/ulikunitz/xz v0.5.15
 	github.com/urfave/cli v1.22.16
 	github.com/vishvananda/netlink v1.3.0
 	github.com/vishvananda/netns v0.0.4
+	golang.org/x/sys v0.10.0
 )
 
 require (
 	github.com/cpuguy83/go-md2man/v2 v2.0.5 // indirect
 	github.com/russross/blackfriday/v2 v2.1.0 // indirect
-	golang.org/x/sys v0.10.0 // indirect
 )
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"go-docker/container"
+	"go-docker/image"
+	"go-docker/trust"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+)
+
+// resolveImage 把镜像引用解析为镜像 ID 和镜像配置
+// 为兼容旧的镜像存放方式，<name>[:latest] 不存在时把 /root/<name>.tar 导入为 <name>:latest
+func resolveImage(imageName string) (string, *image.Image, error) {
+	id, img, err := image.Resolve(imageName)
+	if err == nil || !errors.Is(err, image.ErrImageNotFound) {
+		return id, img, err
+	}
+	ref, refErr := image.ParseReference(imageName)
+	if refErr != nil || ref.Tag != image.DefaultTag || ref.Digest != "" {
+		return "", nil, err
+	}
+	legacyTar := container.RootUrl + "/" + ref.Name + ".tar"
+	if exist, _ := container.PathExists(legacyTar); !exist {
+		return "", nil, err
+	}
+	log.Infof("Import image %s from %s", ref, legacyTar)
+	if _, err := image.ImportTar(legacyTar, ref); err != nil {
+		return "", nil, fmt.Errorf("import %s error %v", legacyTar, err)
+	}
+	return image.Resolve(ref.String())
+}
+
+// mergeRunConfig 合并镜像的默认运行配置和 run 的命令行参数，命令行参数优先
+// --entrypoint 覆盖镜像的 Entrypoint 时不再使用镜像的 Cmd；envs 覆盖镜像中的同名变量；-p 映射的容器端口也作为暴露的端口
+func mergeRunConfig(imgConfig image.Config, entrypoint string, cmd, envs []string, workdir, user string, portmapping []string) (*image.Config, error) {
+	config := &image.Config{
+		User:         imgConfig.User,
+		ExposedPorts: map[string]struct{}{},
+		Env:          append([]string{}, imgConfig.Env...),
+		Entrypoint:   imgConfig.Entrypoint,
+		Cmd:          imgConfig.Cmd,
+		WorkingDir:   imgConfig.WorkingDir,
+	}
+	if entrypoint != "" {
+		config.Entrypoint = []string{entrypoint}
+		config.Cmd = nil
+	}
+	if len(cmd) > 0 {
+		config.Cmd = cmd
+	}
+	if len(config.Entrypoint)+len(config.Cmd) == 0 {
+		return nil, fmt.Errorf("no command specified, and the image has no default Cmd or Entrypoint")
+	}
+	for _, env := range envs {
+		config.SetEnv(env)
+	}
+	if workdir != "" {
+		config.WorkingDir = workdir
+	}
+	if user != "" {
+		config.User = user
+	}
+	for port := range imgConfig.ExposedPorts {
+		config.ExposedPorts[port] = struct{}{}
+	}
+	for _, pm := range portmapping {
+		parts := strings.Split(pm, ":")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("port mapping %s is invalid, it should be hostPort:containerPort", pm)
+		}
+		port, err := image.ParsePort(parts[1])
+		if err != nil {
+			return nil, err
+		}
+		config.ExposedPorts[port] = struct{}{}
+	}
+	return config, nil
+}
+
+// listImages 列出所有镜像，没有标签的镜像显示为 <none>
+func listImages() error {
+	ids, err := image.ListImages()
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 12, 1, 3, ' ', 0)
+	fmt.Fprint(w, "REPOSITORY\tTAG\tIMAGE ID\tCREATED\tSIZE\n")
+	for _, id := range ids {
+		img, err := image.GetImage(id)
+		if err != nil {
+			log.Errorf("Get image %s error %v", id, err)
+			continue
+		}
+		tags, err := image.RepoTags(id)
+		if err != nil {
+			return err
+		}
+		created := img.Created.Local().Format("2006-01-02 15:04:05")
+		size := humanSize(image.Size(img))
+		if len(tags) == 0 {
+			fmt.Fprintf(w, "<none>\t<none>\t%s\t%s\t%s\n", image.ShortID(id), created, size)
+			continue
+		}
+		for _, tag := range tags {
+			ref, _ := image.ParseReference(tag)
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", ref.Name, ref.Tag, image.ShortID(id), created, size)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		log.Errorf("Flush error %v", err)
+		return err
+	}
+	return nil
+}
+
+// removeImage 删除镜像引用，引用是 name:tag 时只删除该标签，镜像没有其他标签时再删除镜像本身
+// 引用是镜像 ID 时删除镜像及其所有标签；镜像有多个标签或正被容器使用时需要 force
+func removeImage(imageName string, force bool) error {
+	id, _, err := image.Resolve(imageName)
+	if err != nil {
+		return err
+	}
+	tags, err := image.RepoTags(id)
+	if err != nil {
+		return err
+	}
+
+	byTag := false
+	if ref, err := image.ParseReference(imageName); err == nil {
+		for _, tag := range tags {
+			if tag == ref.String() {
+				byTag = true
+			}
+		}
+	}
+	if byTag && len(tags) > 1 {
+		ref, _ := image.ParseReference(imageName)
+		if err := image.Untag(ref); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stdout, "Untagged: %s\n", ref)
+		return nil
+	}
+	if !byTag && len(tags) > 1 && !force {
+		return fmt.Errorf("unable to delete %s (must be forced) - image is referenced in multiple repositories", image.ShortID(id))
+	}
+	// 强制删除时容器使用的镜像层保留到容器被删除，但运行中的容器使用的镜像不能删除
+	users := containersUsingImage(id)
+	for _, user := range users {
+		if user.Status == container.RUNNING {
+			return fmt.Errorf("unable to delete %s (cannot be forced) - image is being used by running container %s", image.ShortID(id), user.Name)
+		}
+	}
+	if len(users) > 0 && !force {
+		return fmt.Errorf("unable to delete %s (must be forced) - image is being used by stopped container %s", image.ShortID(id), users[0].Name)
+	}
+	digests, err := image.RepoDigests(id)
+	if err != nil {
+		return err
+	}
+
+	if err := image.DeleteImage(id); err != nil {
+		return err
+	}
+	for _, tag := range append(tags, digests...) {
+		fmt.Fprintf(os.Stdout, "Untagged: %s\n", tag)
+	}
+	fmt.Fprintf(os.Stdout, "Deleted: %s\n", id)
+	return nil
+}
+
+// tagImage 为镜像添加新的 name:tag 引用
+func tagImage(source, target string) error {
+	id, _, err := resolveImage(source)
+	if err != nil {
+		return err
+	}
+	ref, err := image.ParseReference(target)
+	if err != nil {
+		return err
+	}
+	return image.Tag(id, ref)
+}
+
+// saveImages 把镜像保存为 tar 包，output 为空时写到标准输出
+// 先写入临时文件再重命名，避免失败时留下不完整的归档
+func saveImages(imageNames []string, output string) error {
+	for _, imageName := range imageNames {
+		if _, _, err := resolveImage(imageName); err != nil {
+			return err
+		}
+	}
+	if output == "" {
+		if info, err := os.Stdout.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+			return fmt.Errorf("cowardly refusing to save to a terminal. Use the -o flag or redirect")
+		}
+		return image.Save(imageNames, os.Stdout)
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(output), ".mydocker-save-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := image.Save(imageNames, tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), output)
+}
+
+// loadImages 从 tar 包或 OCI 镜像布局目录导入镜像，input 为空时从标准输入读取
+func loadImages(input string, quiet bool) error {
+	if input == "" {
+		if info, err := os.Stdin.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+			return fmt.Errorf("requested load from stdin, but stdin is empty")
+		}
+	}
+	loaded, err := image.Load(input, os.Stdin, trust.Verify)
+	if err != nil {
+		return err
+	}
+	if quiet {
+		return nil
+	}
+	for _, img := range loaded {
+		if len(img.RepoTags) == 0 {
+			fmt.Fprintf(os.Stdout, "Loaded image ID: %s\n", img.ID)
+		}
+		for _, tag := range img.RepoTags {
+			fmt.Fprintf(os.Stdout, "Loaded image: %s\n", tag)
+		}
+	}
+	return nil
+}
+
+// imageHistory 从上到下输出镜像的构建历史
+// 生成镜像层的步骤从最上层开始依次对应镜像的各层，没有生成镜像层的步骤显示为 <none>；
+// 没有构建历史的层（如旧版本导入的镜像）显示为 <missing>
+func imageHistory(imageName string, noTrunc bool) error {
+	_, img, err := resolveImage(imageName)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 12, 1, 3, ' ', 0)
+	fmt.Fprint(w, "LAYER\tCREATED\tCREATED BY\tSIZE\tAUTHOR\tCOMMENT\n")
+	next := len(img.RootFS.DiffIDs) - 1
+	for i := len(img.History) - 1; i >= 0; i-- {
+		h := img.History[i]
+		layer, size := "<none>", int64(0)
+		if !h.EmptyLayer && next >= 0 {
+			layer, size = image.ShortID(img.RootFS.DiffIDs[next]), image.LayerSize(img.RootFS.DiffIDs[next])
+			next--
+		}
+		createdBy := strings.Join(strings.Fields(h.CreatedBy), " ")
+		if !noTrunc && len([]rune(createdBy)) > 45 {
+			createdBy = string([]rune(createdBy)[:44]) + "…"
+		}
+		created := "<missing>"
+		if !h.Created.IsZero() {
+			created = h.Created.Local().Format("2006-01-02 15:04:05")
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", layer, created, createdBy, humanSize(size), h.Author, h.Comment)
+	}
+	for ; next >= 0; next-- {
+		diffID := img.RootFS.DiffIDs[next]
+		fmt.Fprintf(w, "%s\t<missing>\t\t%s\t\t\n", image.ShortID(diffID), humanSize(image.LayerSize(diffID)))
+	}
+	if err := w.Flush(); err != nil {
+		log.Errorf("Flush error %v", err)
+		return err
+	}
+	return nil
+}
+
+// inspectImages 以 JSON 数组的形式输出镜像的详细信息
+func inspectImages(imageNames []string) error {
+	infos := []*image.InspectInfo{}
+	for _, imageName := range imageNames {
+		id, _, err := image.Resolve(imageName)
+		if err != nil {
+			return err
+		}
+		info, err := image.Inspect(id)
+		if err != nil {
+			return err
+		}
+		infos = append(infos, info)
+	}
+	infoJson, err := json.MarshalIndent(infos, "", "    ")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(os.Stdout, string(infoJson))
+	return nil
+}
+
+// containersUsingImage 返回使用该镜像的容器
+func containersUsingImage(id string) []*container.ContainerInfo {
+	var users []*container.ContainerInfo
+	for _, containerInfo := range listContainerInfos() {
+		if containerInfo.ImageID == id {
+			users = append(users, containerInfo)
+		}
+	}
+	return users
+}
+
+// humanSize 把字节数转换为易读的形式，例如 1.2MB
+func humanSize(size int64) string {
+	units := []string{"B", "kB", "MB", "GB", "TB"}
+	value := float64(size)
+	i := 0
+	for value >= 1000 && i < len(units)-1 {
+		value /= 1000
+		i++
+	}
+	if i == 0 {
+		return fmt.Sprintf("%dB", size)
+	}
+	return fmt.Sprintf("%.3g%s", value, units[i])
+}
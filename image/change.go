@@ -0,0 +1,185 @@
+package image
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// ApplyChange 把一条 Dockerfile 风格的指令应用到镜像配置上，用于 commit -c/--change 和 build
+// 支持 CMD、ENTRYPOINT、ENV、EXPOSE、LABEL、USER 和 WORKDIR
+func ApplyChange(config *Config, change string) error {
+	change = strings.TrimSpace(change)
+	instruction, args := change, ""
+	if i := strings.IndexAny(change, " \t"); i >= 0 {
+		instruction, args = change[:i], strings.TrimSpace(change[i+1:])
+	}
+	if args == "" {
+		return fmt.Errorf("%s requires at least one argument", strings.ToUpper(instruction))
+	}
+
+	switch strings.ToUpper(instruction) {
+	case "CMD":
+		config.Cmd = ParseCommand(args)
+	case "ENTRYPOINT":
+		config.Entrypoint = ParseCommand(args)
+	case "ENV":
+		envs, err := parseKeyValues("ENV", args)
+		if err != nil {
+			return err
+		}
+		for _, env := range envs {
+			config.SetEnv(env)
+		}
+	case "LABEL":
+		labels, err := parseKeyValues("LABEL", args)
+		if err != nil {
+			return err
+		}
+		if config.Labels == nil {
+			config.Labels = map[string]string{}
+		}
+		for _, label := range labels {
+			kv := strings.SplitN(label, "=", 2)
+			config.Labels[kv[0]] = kv[1]
+		}
+	case "EXPOSE":
+		words, err := SplitWords(args)
+		if err != nil {
+			return err
+		}
+		for _, word := range words {
+			port, err := ParsePort(word)
+			if err != nil {
+				return err
+			}
+			if config.ExposedPorts == nil {
+				config.ExposedPorts = map[string]struct{}{}
+			}
+			config.ExposedPorts[port] = struct{}{}
+		}
+	case "USER":
+		config.User = args
+	case "WORKDIR":
+		// 相对路径相对于之前的工作目录
+		if path.IsAbs(args) {
+			config.WorkingDir = path.Clean(args)
+		} else {
+			config.WorkingDir = path.Join("/", config.WorkingDir, args)
+		}
+	default:
+		return fmt.Errorf("unsupported change instruction %s, supported: CMD, ENTRYPOINT, ENV, EXPOSE, LABEL, USER, WORKDIR", instruction)
+	}
+	return nil
+}
+
+// SetEnv 设置一个 KEY=VALUE 环境变量，已有的同名变量被替换
+func (c *Config) SetEnv(env string) {
+	key := strings.SplitN(env, "=", 2)[0]
+	for i, e := range c.Env {
+		if strings.SplitN(e, "=", 2)[0] == key {
+			c.Env[i] = env
+			return
+		}
+	}
+	c.Env = append(c.Env, env)
+}
+
+// ParsePort 把 port[/proto] 规范化为 port/proto，协议默认为 tcp
+func ParsePort(s string) (string, error) {
+	port, proto := s, "tcp"
+	if i := strings.Index(s, "/"); i >= 0 {
+		port, proto = s[:i], strings.ToLower(s[i+1:])
+	}
+	if n, err := strconv.Atoi(port); err != nil || n <= 0 || n > 65535 {
+		return "", fmt.Errorf("invalid port %q", s)
+	}
+	if proto != "tcp" && proto != "udp" && proto != "sctp" {
+		return "", fmt.Errorf("invalid protocol in port %q", s)
+	}
+	return port + "/" + proto, nil
+}
+
+// ParseCommand 解析 CMD、ENTRYPOINT 和 RUN 的参数
+// JSON 数组形式（exec 形式）直接作为命令，否则为 shell 形式，由 /bin/sh -c 执行
+func ParseCommand(args string) []string {
+	if strings.HasPrefix(args, "[") {
+		var command []string
+		if err := json.Unmarshal([]byte(args), &command); err == nil {
+			return command
+		}
+	}
+	return []string{"/bin/sh", "-c", args}
+}
+
+// parseKeyValues 解析 ENV 和 LABEL 的参数，支持 k1=v1 k2="v 2" 和旧的 key value 两种形式，返回 key=value 列表
+func parseKeyValues(instruction, args string) ([]string, error) {
+	words, err := SplitWords(args)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.Contains(words[0], "=") {
+		key := words[0]
+		value := strings.TrimSpace(strings.TrimPrefix(args, key))
+		if value == "" {
+			return nil, fmt.Errorf("%s %s requires a value", instruction, key)
+		}
+		unquoted, err := SplitWords(value)
+		if err != nil {
+			return nil, err
+		}
+		return []string{key + "=" + strings.Join(unquoted, " ")}, nil
+	}
+	for _, word := range words {
+		if i := strings.Index(word, "="); i <= 0 {
+			return nil, fmt.Errorf("%s names can not be blank, got %q", instruction, word)
+		}
+	}
+	return words, nil
+}
+
+// SplitWords 按空白分割参数，支持单引号、双引号和反斜杠转义
+func SplitWords(s string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	inWord := false
+	var quote rune
+	escaped := false
+	for _, r := range s {
+		switch {
+		case escaped:
+			word.WriteRune(r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped = true
+			inWord = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote = r
+			inWord = true
+		case r == ' ' || r == '\t':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unmatched quote in %q", s)
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
@@ -0,0 +1,80 @@
+package image
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Instruction 是 Dockerfile 中的一条指令
+type Instruction struct {
+	Command  string // 大写的指令名，如 RUN
+	Args     string // 指令的参数，续行已合并
+	Original string // 指令原文（续行已合并），用于输出和构建缓存
+	Line     int    // 指令起始行号
+}
+
+// ParseDockerfile 把 Dockerfile 解析为指令列表
+// # 开头的行是注释，行尾的 \ 表示续行；不检查指令是否受支持，由调用方处理
+func ParseDockerfile(r io.Reader) ([]Instruction, error) {
+	var instructions []Instruction
+	var current strings.Builder
+	start := 0
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		// 注释行和空行，续行中间的也忽略
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if current.Len() == 0 {
+			start = lineNo
+		}
+		if strings.HasSuffix(line, "\\") {
+			current.WriteString(strings.TrimSuffix(line, "\\"))
+			current.WriteString(" ")
+			continue
+		}
+		current.WriteString(line)
+		instruction, err := parseInstruction(current.String(), start)
+		if err != nil {
+			return nil, err
+		}
+		instructions = append(instructions, instruction)
+		current.Reset()
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if current.Len() > 0 {
+		instruction, err := parseInstruction(current.String(), start)
+		if err != nil {
+			return nil, err
+		}
+		instructions = append(instructions, instruction)
+	}
+	if len(instructions) == 0 {
+		return nil, fmt.Errorf("the Dockerfile cannot be empty")
+	}
+	return instructions, nil
+}
+
+// parseInstruction 把一行拆分为指令名和参数
+func parseInstruction(text string, line int) (Instruction, error) {
+	text = strings.TrimSpace(text)
+	command, args := text, ""
+	if i := strings.IndexAny(text, " \t"); i >= 0 {
+		command, args = text[:i], strings.TrimSpace(text[i+1:])
+	}
+	if args == "" {
+		return Instruction{}, fmt.Errorf("Dockerfile line %d: %s requires at least one argument", line, strings.ToUpper(command))
+	}
+	return Instruction{
+		Command:  strings.ToUpper(command),
+		Args:     args,
+		Original: strings.ToUpper(command) + " " + args,
+		Line:     line,
+	}, nil
+}
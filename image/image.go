@@ -0,0 +1,69 @@
+package image
+
+import (
+	"time"
+)
+
+// ------------------------
+// 镜像配置（与 OCI image-spec 的 image config 兼容），镜像 ID 即配置内容的 sha256 摘要
+// ------------------------
+
+type Image struct {
+	Created      time.Time `json:"created"`
+	Author       string    `json:"author,omitempty"`
+	Architecture string    `json:"architecture"`
+	OS           string    `json:"os"`
+	Config       Config    `json:"config"`
+	RootFS       RootFS    `json:"rootfs"`
+	History      []History `json:"history,omitempty"`
+}
+
+// Config 是镜像的默认运行配置，run 时与命令行参数合并，命令行参数优先
+type Config struct {
+	User         string              `json:"User,omitempty"`         // 运行用户 user[:group]
+	ExposedPorts map[string]struct{} `json:"ExposedPorts,omitempty"` // 暴露的端口，如 80/tcp
+	Env          []string            `json:"Env,omitempty"`          // 默认环境变量 KEY=VALUE
+	Entrypoint   []string            `json:"Entrypoint,omitempty"`   // 命令前缀
+	Cmd          []string            `json:"Cmd,omitempty"`          // 默认命令，作为 Entrypoint 的参数
+	WorkingDir   string              `json:"WorkingDir,omitempty"`   // 工作目录
+	Labels       map[string]string   `json:"Labels,omitempty"`       // 镜像标签（元数据）
+}
+
+// RootFS 按从下到上的顺序列出镜像各层未压缩 tar 包的摘要（diff ID）
+type RootFS struct {
+	Type    string   `json:"type"`
+	DiffIDs []string `json:"diff_ids"`
+}
+
+// History 记录镜像的一个构建步骤，按从下到上的顺序排列
+// 生成镜像层的步骤与 RootFS.DiffIDs 中的层按顺序一一对应，只修改运行配置的步骤 EmptyLayer 为 true
+type History struct {
+	Created    time.Time `json:"created"`
+	CreatedBy  string    `json:"created_by,omitempty"`  // 生成该步骤的命令，如 Dockerfile 指令或容器的命令
+	Author     string    `json:"author,omitempty"`      // 作者
+	Comment    string    `json:"comment,omitempty"`     // 说明，如 commit 的提交信息
+	EmptyLayer bool      `json:"empty_layer,omitempty"` // 该步骤是否没有生成镜像层
+}
+
+// InspectInfo 是 image inspect 输出的镜像详细信息
+type InspectInfo struct {
+	ID           string    `json:"Id"`
+	RepoTags     []string  `json:"RepoTags"`
+	RepoDigests  []string  `json:"RepoDigests"`
+	Created      time.Time `json:"Created"`
+	Author       string    `json:"Author"`
+	Architecture string    `json:"Architecture"`
+	Os           string    `json:"Os"`
+	Config       Config    `json:"Config"`
+	RootFS       RootFS    `json:"RootFS"`
+	Size         int64     `json:"Size"`
+}
+
+// ShortID 返回镜像 ID 去掉 sha256: 前缀后的前 12 位，用于展示
+func ShortID(id string) string {
+	hex := digestHex(id)
+	if len(hex) > 12 {
+		return hex[:12]
+	}
+	return hex
+}
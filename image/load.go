@@ -0,0 +1,457 @@
+package image
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"go-docker/archive"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// maxMetadataSize 限制归档中 manifest、index 和镜像配置的大小
+const maxMetadataSize = 8 << 20
+
+// archiveSource 按归档内的路径读取文件，归档可以是 tar 包或解开的目录
+type archiveSource interface {
+	open(name string) (io.ReadCloser, error)
+	exists(name string) bool
+}
+
+// dirSource 读取目录形式的归档，例如其他构建工具输出的 OCI 镜像布局目录
+type dirSource struct {
+	root string
+}
+
+// path 把归档内的路径限制在目录内
+func (s *dirSource) path(name string) string {
+	return filepath.Join(s.root, filepath.FromSlash(path.Clean("/"+name)))
+}
+
+func (s *dirSource) open(name string) (io.ReadCloser, error) {
+	return os.Open(s.path(name))
+}
+
+func (s *dirSource) exists(name string) bool {
+	info, err := os.Stat(s.path(name))
+	return err == nil && info.Mode().IsRegular()
+}
+
+// tarSource 读取 tar 包形式的归档，先扫描一遍记录每个文件在 tar 包中的位置，之后按需读取
+type tarSource struct {
+	f     *os.File
+	files map[string]tarEntry
+}
+
+// tarEntry 是文件内容在 tar 包中的偏移和大小
+type tarEntry struct {
+	offset int64
+	size   int64
+}
+
+// countingReader 记录已读取的字节数，即 tar 包中当前的偏移
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// newTarSource 扫描 tar 包；tar.Reader 在 Next 返回后恰好停在文件内容的起始位置
+func newTarSource(f *os.File) (*tarSource, error) {
+	s := &tarSource{f: f, files: map[string]tarEntry{}}
+	cr := &countingReader{r: f}
+	tr := tar.NewReader(cr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read archive error %v", err)
+		}
+		if hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeRegA {
+			continue
+		}
+		name := strings.TrimPrefix(path.Clean("/"+hdr.Name), "/")
+		s.files[name] = tarEntry{offset: cr.n, size: hdr.Size}
+	}
+	return s, nil
+}
+
+func (s *tarSource) open(name string) (io.ReadCloser, error) {
+	entry, ok := s.files[strings.TrimPrefix(path.Clean("/"+name), "/")]
+	if !ok {
+		return nil, fmt.Errorf("%s not found in archive", name)
+	}
+	return ioutil.NopCloser(io.NewSectionReader(s.f, entry.offset, entry.size)), nil
+}
+
+func (s *tarSource) exists(name string) bool {
+	_, ok := s.files[strings.TrimPrefix(path.Clean("/"+name), "/")]
+	return ok
+}
+
+// LoadedImage 是从归档中导入的一个镜像
+type LoadedImage struct {
+	ID       string
+	RepoTags []string
+}
+
+// Load 导入 docker save 格式或 OCI 镜像布局的归档，input 为 tar 包（可以是压缩的）或目录，为空时从 r 读取 tar 包
+// 按从下到上的顺序导入各层并校验摘要，已有的层跳过；白障文件原样保留在层中，由联合文件系统挂载时生效
+// verify 不为空时，每个镜像在保存配置之前用归档中的签名检查，不通过时不导入
+func Load(input string, r io.Reader, verify Verifier) ([]LoadedImage, error) {
+	release, err := Lease()
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	var source archiveSource
+	if input != "" {
+		if info, err := os.Stat(input); err != nil {
+			return nil, err
+		} else if info.IsDir() {
+			source = &dirSource{root: input}
+		}
+	}
+	if source == nil {
+		f, cleanup, err := openArchive(input, r)
+		if err != nil {
+			return nil, err
+		}
+		defer cleanup()
+		if source, err = newTarSource(f); err != nil {
+			return nil, err
+		}
+	}
+
+	switch {
+	case source.exists(dockerManifestFile):
+		return loadDocker(source, verify)
+	case source.exists(ociIndexFile) && source.exists(ociLayoutFile):
+		return loadOCI(source, verify)
+	default:
+		return nil, fmt.Errorf("unrecognized image archive: neither %s nor an OCI image layout found", dockerManifestFile)
+	}
+}
+
+// openArchive 返回可以随机读取的未压缩 tar 包；标准输入和压缩的 tar 包先解压写入临时文件
+func openArchive(input string, r io.Reader) (*os.File, func(), error) {
+	if input != "" {
+		f, err := os.Open(input)
+		if err != nil {
+			return nil, nil, err
+		}
+		header := make([]byte, 10)
+		n, _ := io.ReadFull(f, header)
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			f.Close()
+			return nil, nil, err
+		}
+		if archive.DetectCompression(header[:n]) == archive.Uncompressed {
+			return f, func() { f.Close() }, nil
+		}
+		defer f.Close()
+		r = f
+	}
+
+	src, err := archive.DecompressStream(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer src.Close()
+	tmp, err := ioutil.TempFile("", "mydocker-load-")
+	if err != nil {
+		return nil, nil, err
+	}
+	cleanup := func() {
+		tmp.Close()
+		os.Remove(tmp.Name())
+	}
+	if _, err := io.Copy(tmp, src); err != nil {
+		cleanup()
+		return nil, nil, fmt.Errorf("read archive error %v", err)
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	return tmp, cleanup, nil
+}
+
+// readMetadata 读取归档中的小文件，expected 不为空时校验摘要
+func readMetadata(source archiveSource, name, expected string) ([]byte, error) {
+	rc, err := source.open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	content, err := ioutil.ReadAll(io.LimitReader(rc, maxMetadataSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(content) > maxMetadataSize {
+		return nil, fmt.Errorf("%s is too large", name)
+	}
+	if expected != "" {
+		if got := DigestOf(content); got != expected {
+			return nil, fmt.Errorf("%s digest mismatch: expected %s, got %s", name, expected, got)
+		}
+	}
+	return content, nil
+}
+
+// loadDocker 导入 docker save 格式的归档
+func loadDocker(source archiveSource, verify Verifier) ([]LoadedImage, error) {
+	content, err := readMetadata(source, dockerManifestFile, "")
+	if err != nil {
+		return nil, err
+	}
+	var items []dockerManifestItem
+	if err := json.Unmarshal(content, &items); err != nil {
+		return nil, fmt.Errorf("parse %s error %v", dockerManifestFile, err)
+	}
+	var loaded []LoadedImage
+	for _, item := range items {
+		config, err := readMetadata(source, item.Config, "")
+		if err != nil {
+			return nil, err
+		}
+		layers := make([]layerSource, len(item.Layers))
+		for i, name := range item.Layers {
+			layers[i] = layerSource{name: name}
+		}
+		var refs []Reference
+		var names []string
+		for _, tag := range item.RepoTags {
+			ref, err := ParseReference(tag)
+			if err != nil {
+				return nil, err
+			}
+			refs = append(refs, ref)
+			names = append(names, ref.Name)
+		}
+		id, err := loadImage(source, config, layers, names, verify)
+		if err != nil {
+			return nil, err
+		}
+		result := LoadedImage{ID: id}
+		for _, ref := range refs {
+			if err := Tag(id, ref); err != nil {
+				return nil, err
+			}
+			result.RepoTags = append(result.RepoTags, ref.String())
+		}
+		loaded = append(loaded, result)
+	}
+	return loaded, nil
+}
+
+// loadOCI 导入 OCI 镜像布局，index 中嵌套的 index 按本机平台选择镜像
+// 名字取自 io.containerd.image.name，或者是完整引用的 org.opencontainers.image.ref.name
+func loadOCI(source archiveSource, verify Verifier) ([]LoadedImage, error) {
+	content, err := readMetadata(source, ociLayoutFile, "")
+	if err != nil {
+		return nil, err
+	}
+	layout := ociLayout{}
+	if err := json.Unmarshal(content, &layout); err != nil {
+		return nil, fmt.Errorf("parse %s error %v", ociLayoutFile, err)
+	}
+	if layout.ImageLayoutVersion != ociLayoutVersion {
+		return nil, fmt.Errorf("unsupported OCI image layout version %q", layout.ImageLayoutVersion)
+	}
+	content, err = readMetadata(source, ociIndexFile, "")
+	if err != nil {
+		return nil, err
+	}
+	index := Index{}
+	if err := json.Unmarshal(content, &index); err != nil {
+		return nil, fmt.Errorf("parse %s error %v", ociIndexFile, err)
+	}
+
+	var loaded []LoadedImage
+	byID := map[string]int{}
+	for _, desc := range index.Manifests {
+		// 只有带标签的名字才为镜像打标签
+		var tag *Reference
+		var names []string
+		name := desc.Annotations[AnnotationDockerImageName]
+		if name == "" && strings.ContainsAny(desc.Annotations[AnnotationRefName], ":/") {
+			name = desc.Annotations[AnnotationRefName]
+		}
+		if name != "" {
+			ref, err := ParseReference(name)
+			if err != nil {
+				return nil, err
+			}
+			if ref.Tag != "" {
+				tag = &Reference{Name: ref.Name, Tag: ref.Tag}
+				names = append(names, ref.Name)
+			}
+		}
+
+		id, err := loadOCIManifest(source, desc, names, verify)
+		if err != nil {
+			return nil, err
+		}
+		i, ok := byID[id]
+		if !ok {
+			i = len(loaded)
+			byID[id] = i
+			loaded = append(loaded, LoadedImage{ID: id})
+		}
+		if tag == nil {
+			continue
+		}
+		if err := Tag(id, *tag); err != nil {
+			return nil, err
+		}
+		loaded[i].RepoTags = append(loaded[i].RepoTags, tag.String())
+	}
+	return loaded, nil
+}
+
+// loadOCIManifest 导入 index 中的一项，返回镜像 ID；names 和 verify 同 loadImage
+func loadOCIManifest(source archiveSource, desc Descriptor, names []string, verify Verifier) (string, error) {
+	if !IsDigestFormat(desc.Digest) {
+		return "", fmt.Errorf("unsupported digest %q", desc.Digest)
+	}
+	content, err := readMetadata(source, blobName(desc.Digest), desc.Digest)
+	if err != nil {
+		return "", err
+	}
+	switch desc.MediaType {
+	case MediaTypeOCIIndex, MediaTypeManifestList:
+		index := Index{}
+		if err := json.Unmarshal(content, &index); err != nil {
+			return "", fmt.Errorf("parse index %s error %v", desc.Digest, err)
+		}
+		for _, m := range index.Manifests {
+			if m.Platform != nil && m.Platform.OS == "linux" && m.Platform.Architecture == runtime.GOARCH {
+				return loadOCIManifest(source, m, names, verify)
+			}
+		}
+		return "", fmt.Errorf("no matching manifest for linux/%s in index %s", runtime.GOARCH, desc.Digest)
+	case MediaTypeOCIManifest, MediaTypeManifest:
+	default:
+		return "", fmt.Errorf("unsupported manifest media type %q", desc.MediaType)
+	}
+
+	manifest := Manifest{}
+	if err := json.Unmarshal(content, &manifest); err != nil {
+		return "", fmt.Errorf("parse manifest %s error %v", desc.Digest, err)
+	}
+	if !IsDigestFormat(manifest.Config.Digest) {
+		return "", fmt.Errorf("unsupported config digest %q", manifest.Config.Digest)
+	}
+	config, err := readMetadata(source, blobName(manifest.Config.Digest), manifest.Config.Digest)
+	if err != nil {
+		return "", err
+	}
+	layers := make([]layerSource, len(manifest.Layers))
+	for i, layer := range manifest.Layers {
+		switch layer.MediaType {
+		case MediaTypeOCILayer, MediaTypeOCILayerGzip, MediaTypeOCILayerZstd, MediaTypeLayer:
+		default:
+			return "", fmt.Errorf("unsupported layer media type %q", layer.MediaType)
+		}
+		if !IsDigestFormat(layer.Digest) {
+			return "", fmt.Errorf("unsupported layer digest %q", layer.Digest)
+		}
+		layers[i] = layerSource{name: blobName(layer.Digest), digest: layer.Digest}
+	}
+	return loadImage(source, config, layers, names, verify)
+}
+
+// layerSource 是归档中的一层，digest 为归档中（可能压缩的）内容的摘要，docker save 格式中没有
+type layerSource struct {
+	name   string
+	digest string
+}
+
+// loadImage 按从下到上的顺序导入镜像各层，校验 diff ID 后保存镜像配置，返回镜像 ID
+// 归档中有镜像的签名时一并导入；verify 不为空时在保存配置之前检查签名，names 为镜像将被标记的仓库名
+func loadImage(source archiveSource, config []byte, layers []layerSource, names []string, verify Verifier) (string, error) {
+	img := &Image{}
+	if err := json.Unmarshal(config, img); err != nil {
+		return "", fmt.Errorf("parse image config error %v", err)
+	}
+	if len(img.RootFS.DiffIDs) != len(layers) {
+		return "", fmt.Errorf("image config has %d layers, but manifest has %d", len(img.RootFS.DiffIDs), len(layers))
+	}
+	for i, layer := range layers {
+		diffID := img.RootFS.DiffIDs[i]
+		if HasLayer(diffID) {
+			continue
+		}
+		if err := loadLayer(source, layer, diffID); err != nil {
+			return "", fmt.Errorf("load layer %s error %v", layer.name, err)
+		}
+	}
+
+	manifest, err := ManifestOf(config)
+	if err != nil {
+		return "", err
+	}
+	manifestDigest := DigestOf(manifest)
+	var signatures []Signature
+	if source.exists(signatureName(manifestDigest)) {
+		content, err := readMetadata(source, signatureName(manifestDigest), "")
+		if err != nil {
+			return "", err
+		}
+		if err := json.Unmarshal(content, &signatures); err != nil {
+			return "", fmt.Errorf("parse %s error %v", signatureName(manifestDigest), err)
+		}
+	}
+	if err := ImportSignatures(manifestDigest, signatures, names, verify); err != nil {
+		return "", err
+	}
+	return StoreConfig(config)
+}
+
+// loadLayer 导入一层，先校验归档中的摘要再导入，导入后校验 diff ID
+func loadLayer(source archiveSource, layer layerSource, diffID string) error {
+	if layer.digest != "" {
+		rc, err := source.open(layer.name)
+		if err != nil {
+			return err
+		}
+		hash := sha256.New()
+		_, err = io.Copy(hash, rc)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+		if got := "sha256:" + hex.EncodeToString(hash.Sum(nil)); got != layer.digest {
+			return fmt.Errorf("digest mismatch: expected %s, got %s", layer.digest, got)
+		}
+	}
+	rc, err := source.open(layer.name)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	got, err := CreateLayer(rc)
+	if err != nil {
+		return err
+	}
+	if got != diffID {
+		return fmt.Errorf("diff ID mismatch: expected %s, got %s", diffID, got)
+	}
+	return nil
+}
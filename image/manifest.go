@@ -0,0 +1,54 @@
+package image
+
+// ------------------------
+// 镜像分发格式：仓库和 OCI 镜像布局中的 manifest 与 index
+// ------------------------
+
+// manifest 和镜像内容的媒体类型
+const (
+	MediaTypeManifest        = "application/vnd.docker.distribution.manifest.v2+json"
+	MediaTypeManifestList    = "application/vnd.docker.distribution.manifest.list.v2+json"
+	MediaTypeConfig          = "application/vnd.docker.container.image.v1+json"
+	MediaTypeLayer           = "application/vnd.docker.image.rootfs.diff.tar.gzip"
+	MediaTypeOCIManifest     = "application/vnd.oci.image.manifest.v1+json"
+	MediaTypeOCIIndex        = "application/vnd.oci.image.index.v1+json"
+	MediaTypeOCIConfig       = "application/vnd.oci.image.config.v1+json"
+	MediaTypeOCILayer        = "application/vnd.oci.image.layer.v1.tar"
+	MediaTypeOCILayerGzip    = "application/vnd.oci.image.layer.v1.tar+gzip"
+	MediaTypeOCILayerZstd    = "application/vnd.oci.image.layer.v1.tar+zstd"
+	MediaTypeSchema1Manifest = "application/vnd.docker.distribution.manifest.v1+json"
+	MediaTypeSchema1Signed   = "application/vnd.docker.distribution.manifest.v1+prettyjws"
+	// 镜像签名在仓库中作为一个 OCI artifact，以 sha256-<manifest hex>.sig 为标签，签名列表是它的配置
+	MediaTypeSignatures = "application/vnd.mydocker.signatures.v1+json"
+)
+
+// Descriptor 描述一段按摘要寻址的内容
+type Descriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	Platform    *Platform         `json:"platform,omitempty"`
+}
+
+// Platform 是 manifest list 和 index 中镜像适用的平台
+type Platform struct {
+	Architecture string `json:"architecture"`
+	OS           string `json:"os"`
+	Variant      string `json:"variant,omitempty"`
+}
+
+// Manifest 是单个平台镜像的 manifest，Docker schema2 与 OCI manifest 格式相同
+type Manifest struct {
+	SchemaVersion int          `json:"schemaVersion"`
+	MediaType     string       `json:"mediaType,omitempty"`
+	Config        Descriptor   `json:"config"`
+	Layers        []Descriptor `json:"layers"`
+}
+
+// Index 是多平台镜像的 manifest list 或 OCI index
+type Index struct {
+	SchemaVersion int          `json:"schemaVersion"`
+	MediaType     string       `json:"mediaType,omitempty"`
+	Manifests     []Descriptor `json:"manifests"`
+}
@@ -0,0 +1,96 @@
+package image
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// DefaultTag 是引用中未指定标签时使用的标签
+const DefaultTag = "latest"
+
+var (
+	// 仓库名由 / 分隔的若干部分组成，每部分为小写字母和数字，中间可以用 .、_、__ 或若干 - 连接；
+	// 第一部分可以是带端口的仓库地址，例如 localhost:5000/busybox
+	domainRegexp    = regexp.MustCompile(`^(?:[a-zA-Z0-9](?:[a-zA-Z0-9-]*[a-zA-Z0-9])?)(?:\.(?:[a-zA-Z0-9](?:[a-zA-Z0-9-]*[a-zA-Z0-9])?))*(?::[0-9]+)?$`)
+	componentRegexp = regexp.MustCompile(`^[a-z0-9]+(?:(?:[._]|__|[-]+)[a-z0-9]+)*$`)
+	tagRegexp       = regexp.MustCompile(`^[\w][\w.-]{0,127}$`)
+	digestRegexp    = regexp.MustCompile(`^sha256:[a-f0-9]{64}$`)
+)
+
+// Reference 是镜像引用 name[:tag][@digest]
+type Reference struct {
+	Name   string // 仓库名，例如 busybox 或 localhost:5000/library/busybox
+	Tag    string // 标签，只有摘要时为空
+	Digest string // 内容摘要 sha256:<hex>
+}
+
+// ParseReference 解析镜像引用，既没有标签也没有摘要时使用默认标签 latest
+func ParseReference(s string) (Reference, error) {
+	ref := Reference{}
+	remaining := s
+	if i := strings.Index(remaining, "@"); i >= 0 {
+		ref.Digest = remaining[i+1:]
+		remaining = remaining[:i]
+		if !digestRegexp.MatchString(ref.Digest) {
+			return Reference{}, fmt.Errorf("invalid reference %q: invalid digest %q", s, ref.Digest)
+		}
+	}
+	// 标签是最后一个 / 之后的冒号后面的部分，仓库地址中的端口号不是标签
+	if i := strings.LastIndex(remaining, ":"); i > strings.LastIndex(remaining, "/") {
+		ref.Tag = remaining[i+1:]
+		remaining = remaining[:i]
+		if !tagRegexp.MatchString(ref.Tag) {
+			return Reference{}, fmt.Errorf("invalid reference %q: invalid tag %q", s, ref.Tag)
+		}
+	}
+	ref.Name = remaining
+	if err := validateName(ref.Name); err != nil {
+		return Reference{}, fmt.Errorf("invalid reference %q: %v", s, err)
+	}
+	if ref.Tag == "" && ref.Digest == "" {
+		ref.Tag = DefaultTag
+	}
+	return ref, nil
+}
+
+// validateName 检查仓库名是否合法
+func validateName(name string) error {
+	if name == "" {
+		return fmt.Errorf("repository name must not be empty")
+	}
+	if len(name) > 255 {
+		return fmt.Errorf("repository name must not be longer than 255 characters")
+	}
+	components := strings.Split(name, "/")
+	// 第一部分包含 .、: 或为 localhost 时视为仓库地址
+	if len(components) > 1 && (strings.ContainsAny(components[0], ".:") || components[0] == "localhost") {
+		if !domainRegexp.MatchString(components[0]) {
+			return fmt.Errorf("invalid registry address %q", components[0])
+		}
+		components = components[1:]
+	}
+	for _, c := range components {
+		if !componentRegexp.MatchString(c) {
+			return fmt.Errorf("repository name must be lowercase alphanumeric components separated by '/', got %q", name)
+		}
+	}
+	return nil
+}
+
+// String 返回引用的完整形式 name[:tag][@digest]
+func (r Reference) String() string {
+	s := r.Name
+	if r.Tag != "" {
+		s += ":" + r.Tag
+	}
+	if r.Digest != "" {
+		s += "@" + r.Digest
+	}
+	return s
+}
+
+// IsDigestFormat 判断字符串是否为完整的 sha256:<hex> 摘要
+func IsDigestFormat(s string) bool {
+	return digestRegexp.MatchString(s)
+}
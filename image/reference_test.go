@@ -0,0 +1,46 @@
+package image
+
+import "testing"
+
+func TestParseReference(t *testing.T) {
+	digest := "sha256:" + "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	tests := []struct {
+		in      string
+		want    Reference
+		wantErr bool
+	}{
+		{in: "busybox", want: Reference{Name: "busybox", Tag: "latest"}},
+		{in: "busybox:1.36", want: Reference{Name: "busybox", Tag: "1.36"}},
+		{in: "library/busybox:musl", want: Reference{Name: "library/busybox", Tag: "musl"}},
+		{in: "localhost:5000/busybox", want: Reference{Name: "localhost:5000/busybox", Tag: "latest"}},
+		{in: "localhost:5000/a/b:v1", want: Reference{Name: "localhost:5000/a/b", Tag: "v1"}},
+		{in: "registry.example.com/team/app:1.0-rc.1", want: Reference{Name: "registry.example.com/team/app", Tag: "1.0-rc.1"}},
+		{in: "busybox@" + digest, want: Reference{Name: "busybox", Digest: digest}},
+		{in: "busybox:1.36@" + digest, want: Reference{Name: "busybox", Tag: "1.36", Digest: digest}},
+		{in: "my-app_v2/a__b.c", want: Reference{Name: "my-app_v2/a__b.c", Tag: "latest"}},
+
+		{in: "", wantErr: true},
+		{in: ":latest", wantErr: true},
+		{in: "BusyBox", wantErr: true},
+		{in: "busybox:", wantErr: true},
+		{in: "busybox:-bad", wantErr: true},
+		{in: "busybox@sha256:abc", wantErr: true},
+		{in: "busybox@md5:" + digest[7:], wantErr: true},
+		{in: "a//b", wantErr: true},
+		{in: "-busybox", wantErr: true},
+		{in: "bad_host:5000/busybox", wantErr: true},
+		{in: "localhost:port/busybox", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseReference(tt.in)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseReference(%q) = %+v, want an error", tt.in, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("ParseReference(%q) = %+v, %v, want %+v", tt.in, got, err, tt.want)
+		}
+	}
+}
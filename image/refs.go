@@ -0,0 +1,242 @@
+package image
+
+import (
+	"encoding/json"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// ------------------------
+// 镜像层的引用计数
+//   containers/<name>      容器使用的存储驱动和镜像层（只读层），创建容器工作空间前写入，删除工作空间后删除
+// 镜像层的引用数是使用它的镜像数加上容器数；引用数为 0 的层由 GarbageCollect 删除
+// 镜像被强制删除后，仍在使用的层保留到最后一个容器被删除
+// ------------------------
+
+func containerRefsDir() string { return filepath.Join(StoreRoot, "containers") }
+
+// ContainerRef 记录一个容器使用的镜像层
+type ContainerRef struct {
+	Driver string   `json:"driver"` // 解压镜像层和创建可写层的存储驱动
+	Layers []string `json:"layers"` // 镜像层的 diff ID，按从下到上的顺序排列
+}
+
+// AcquireLayers 记录容器使用的镜像层，之后这些层不会被垃圾回收
+func AcquireLayers(containerName, driver string, diffIDs []string) error {
+	content, err := json.Marshal(ContainerRef{Driver: driver, Layers: diffIDs})
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(containerRefsDir(), 0700); err != nil {
+		return err
+	}
+	path := filepath.Join(containerRefsDir(), containerName)
+	if err := ioutil.WriteFile(path+".tmp", content, 0600); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// ReleaseLayers 删除容器对镜像层的引用，没有记录时忽略
+func ReleaseLayers(containerName string) error {
+	if err := os.Remove(filepath.Join(containerRefsDir(), containerName)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// ContainerRefs 返回所有容器对镜像层的引用，容器名称到引用
+func ContainerRefs() (map[string]ContainerRef, error) {
+	refs := map[string]ContainerRef{}
+	files, err := ioutil.ReadDir(containerRefsDir())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return refs, nil
+		}
+		return nil, err
+	}
+	for _, file := range files {
+		if strings.HasSuffix(file.Name(), ".tmp") {
+			continue
+		}
+		path := filepath.Join(containerRefsDir(), file.Name())
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		var ref ContainerRef
+		if err := json.Unmarshal(content, &ref); err != nil {
+			return nil, fmt.Errorf("parse %s error %v", path, err)
+		}
+		refs[file.Name()] = ref
+	}
+	return refs, nil
+}
+
+// LayerRefs 返回各镜像层的引用数，即使用它的镜像数和容器数之和
+func LayerRefs() (map[string]int, error) {
+	counts := map[string]int{}
+	ids, err := ListImages()
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range ids {
+		img, err := GetImage(id)
+		if err != nil {
+			return nil, err
+		}
+		for _, diffID := range uniqueLayers(img.RootFS.DiffIDs) {
+			counts[diffID]++
+		}
+	}
+	refs, err := ContainerRefs()
+	if err != nil {
+		return nil, err
+	}
+	for _, ref := range refs {
+		for _, diffID := range uniqueLayers(ref.Layers) {
+			counts[diffID]++
+		}
+	}
+	return counts, nil
+}
+
+// uniqueLayers 去掉重复的层，同一镜像中相同的层（例如两个相同的空层）只计一次引用
+func uniqueLayers(diffIDs []string) []string {
+	seen := map[string]bool{}
+	var layers []string
+	for _, diffID := range diffIDs {
+		if !seen[diffID] {
+			seen[diffID] = true
+			layers = append(layers, diffID)
+		}
+	}
+	return layers
+}
+
+// ExtractedLayer 是镜像层由某个存储驱动解压后的目录
+type ExtractedLayer struct {
+	DiffID string
+	Driver string
+	Path   string
+}
+
+// ExtractedLayers 返回所有存储驱动解压的镜像层，不包括解压中的临时目录
+func ExtractedLayers() ([]ExtractedLayer, error) {
+	drivers, err := ioutil.ReadDir(layersDir())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var layers []ExtractedLayer
+	for _, driver := range drivers {
+		dir := filepath.Join(layersDir(), driver.Name())
+		entries, err := ioutil.ReadDir(dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if strings.HasPrefix(entry.Name(), ".") {
+				continue
+			}
+			layers = append(layers, ExtractedLayer{
+				DiffID: "sha256:" + entry.Name(),
+				Driver: driver.Name(),
+				Path:   filepath.Join(dir, entry.Name()),
+			})
+		}
+	}
+	return layers, nil
+}
+
+// LayerSize 返回镜像层未压缩 tar 包的大小
+func LayerSize(diffID string) int64 {
+	info, err := os.Stat(blobPath(diffID))
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
+
+// Lease 持有镜像存储的共享锁，返回释放锁的函数，释放函数可以多次调用
+// pull、load、build、commit 创建的层在镜像配置写入之前没有引用，run 在记录引用之前解析镜像，持有期间不进行垃圾回收
+func Lease() (func(), error) {
+	f, err := lockStore(unix.LOCK_SH)
+	if err != nil {
+		return nil, err
+	}
+	var once sync.Once
+	return func() { once.Do(func() { f.Close() }) }, nil
+}
+
+// lockStore 对镜像存储的锁文件加 flock，关闭文件即释放锁
+func lockStore(how int) (*os.File, error) {
+	if err := os.MkdirAll(StoreRoot, 0700); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(filepath.Join(StoreRoot, "lock"), os.O_RDONLY|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err := unix.Flock(int(f.Fd()), how); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// GarbageCollect 删除引用数为 0 的镜像层：各存储驱动解压的目录、tar 包和仓库中的摘要记录
+// 有其他进程持有 Lease 时跳过，由之后的垃圾回收删除
+func GarbageCollect() error {
+	lock, err := lockStore(unix.LOCK_EX | unix.LOCK_NB)
+	if err == unix.EWOULDBLOCK {
+		log.Debugf("Image store is in use, skip garbage collection")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+
+	counts, err := LayerRefs()
+	if err != nil {
+		return err
+	}
+	extracted, err := ExtractedLayers()
+	if err != nil {
+		return err
+	}
+	for _, layer := range extracted {
+		if counts[layer.DiffID] > 0 {
+			continue
+		}
+		if err := os.RemoveAll(layer.Path); err != nil {
+			return fmt.Errorf("remove layer %s error %v", layer.Path, err)
+		}
+		log.Infof("Removed extracted layer %s of driver %s", layer.DiffID, layer.Driver)
+	}
+	blobs, err := ioutil.ReadDir(blobsDir())
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, blob := range blobs {
+		diffID := "sha256:" + blob.Name()
+		if strings.HasPrefix(blob.Name(), ".") || counts[diffID] > 0 {
+			continue
+		}
+		if err := os.Remove(blobPath(diffID)); err != nil {
+			return fmt.Errorf("remove layer %s error %v", diffID, err)
+		}
+		os.Remove(filepath.Join(distributionDir(), blob.Name()))
+		log.Infof("Removed layer %s", diffID)
+	}
+	return nil
+}
@@ -0,0 +1,218 @@
+package image
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// ------------------------
+// 镜像归档：save 输出的 tar 包同时是 OCI image layout 和 docker save 格式
+//   oci-layout             OCI 布局版本
+//   index.json             OCI index，每个标签一项，名字记录在 annotations 中
+//   manifest.json          docker load 使用的清单
+//   blobs/sha256/<hex>     镜像层（未压缩 tar 包）、镜像配置和 OCI manifest
+//   signatures/<hex>.json  镜像的签名（如果有），以 OCI manifest 的摘要命名
+// ------------------------
+
+// 镜像归档中的文件名和 annotations
+const (
+	ociLayoutFile             = "oci-layout"
+	ociIndexFile              = "index.json"
+	dockerManifestFile        = "manifest.json"
+	ociLayoutVersion          = "1.0.0"
+	AnnotationRefName         = "org.opencontainers.image.ref.name"
+	AnnotationDockerImageName = "io.containerd.image.name"
+)
+
+// ociLayout 是 oci-layout 文件的内容
+type ociLayout struct {
+	ImageLayoutVersion string `json:"imageLayoutVersion"`
+}
+
+// dockerManifestItem 是 docker save 格式 manifest.json 中的一个镜像
+type dockerManifestItem struct {
+	Config   string   `json:"Config"`
+	RepoTags []string `json:"RepoTags"`
+	Layers   []string `json:"Layers"`
+}
+
+// blobName 返回 blob 在镜像归档中的路径
+func blobName(digest string) string {
+	return "blobs/sha256/" + digestHex(digest)
+}
+
+// signatureName 返回镜像签名在镜像归档中的路径
+func signatureName(manifestDigest string) string {
+	return "signatures/" + digestHex(manifestDigest) + ".json"
+}
+
+// Save 把镜像写为 tar 包，names 可以是 name:tag 或镜像 ID；按标签引用时归档中记录该标签
+// 多个引用指向同一镜像时镜像只写一次，各镜像共用的层也只写一次
+func Save(names []string, w io.Writer) error {
+	var ids []string
+	tags := map[string][]string{}
+	for _, name := range names {
+		id, _, err := Resolve(name)
+		if err != nil {
+			return err
+		}
+		if _, ok := tags[id]; !ok {
+			ids = append(ids, id)
+			tags[id] = []string{}
+		}
+		repoTags, err := RepoTags(id)
+		if err != nil {
+			return err
+		}
+		if ref, err := ParseReference(name); err == nil {
+			for _, tag := range repoTags {
+				if tag == ref.String() && !contains(tags[id], tag) {
+					tags[id] = append(tags[id], tag)
+				}
+			}
+		}
+	}
+
+	tw := tar.NewWriter(w)
+	written := map[string]bool{}
+	for _, dir := range []string{"blobs/", "blobs/sha256/"} {
+		if err := tw.WriteHeader(&tar.Header{Typeflag: tar.TypeDir, Name: dir, Mode: 0755, ModTime: time.Unix(0, 0)}); err != nil {
+			return err
+		}
+	}
+
+	index := Index{SchemaVersion: 2, MediaType: MediaTypeOCIIndex, Manifests: []Descriptor{}}
+	var dockerManifest []dockerManifestItem
+	for _, id := range ids {
+		img, err := GetImage(id)
+		if err != nil {
+			return err
+		}
+		config, err := ConfigBytes(id)
+		if err != nil {
+			return err
+		}
+		item := dockerManifestItem{Config: blobName(id), RepoTags: tags[id], Layers: []string{}}
+		for _, diffID := range img.RootFS.DiffIDs {
+			if err := writeBlobFile(tw, written, diffID, blobPath(diffID)); err != nil {
+				return fmt.Errorf("write layer %s error %v", diffID, err)
+			}
+			item.Layers = append(item.Layers, blobName(diffID))
+		}
+		if err := writeBlob(tw, written, id, config); err != nil {
+			return err
+		}
+		manifestBytes, err := ManifestOf(config)
+		if err != nil {
+			return err
+		}
+		manifestDigest := DigestOf(manifestBytes)
+		if err := writeBlob(tw, written, manifestDigest, manifestBytes); err != nil {
+			return err
+		}
+		signatures, err := Signatures(manifestDigest)
+		if err != nil {
+			return err
+		}
+		if len(signatures) > 0 {
+			content, err := json.Marshal(signatures)
+			if err != nil {
+				return err
+			}
+			if err := writeTarFile(tw, signatureName(manifestDigest), int64(len(content)), bytes.NewReader(content)); err != nil {
+				return err
+			}
+		}
+
+		desc := Descriptor{MediaType: MediaTypeOCIManifest, Digest: manifestDigest, Size: int64(len(manifestBytes))}
+		if len(tags[id]) == 0 {
+			index.Manifests = append(index.Manifests, desc)
+		}
+		for _, tag := range tags[id] {
+			ref, _ := ParseReference(tag)
+			tagged := desc
+			tagged.Annotations = map[string]string{
+				AnnotationDockerImageName: tag,
+				AnnotationRefName:         ref.Tag,
+			}
+			index.Manifests = append(index.Manifests, tagged)
+		}
+		dockerManifest = append(dockerManifest, item)
+	}
+
+	files := []struct {
+		name  string
+		value interface{}
+	}{
+		{ociLayoutFile, ociLayout{ImageLayoutVersion: ociLayoutVersion}},
+		{ociIndexFile, index},
+		{dockerManifestFile, dockerManifest},
+	}
+	for _, f := range files {
+		content, err := json.Marshal(f.value)
+		if err != nil {
+			return err
+		}
+		if err := writeTarFile(tw, f.name, int64(len(content)), bytes.NewReader(content)); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+// writeBlob 把内容写为 blobs/sha256/<hex>，已写过的 blob 跳过
+func writeBlob(tw *tar.Writer, written map[string]bool, digest string, content []byte) error {
+	if written[digest] {
+		return nil
+	}
+	written[digest] = true
+	return writeTarFile(tw, blobName(digest), int64(len(content)), bytes.NewReader(content))
+}
+
+// writeBlobFile 把文件写为 blobs/sha256/<hex>，已写过的 blob 跳过
+func writeBlobFile(tw *tar.Writer, written map[string]bool, digest, path string) error {
+	if written[digest] {
+		return nil
+	}
+	written[digest] = true
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	return writeTarFile(tw, blobName(digest), info.Size(), f)
+}
+
+// writeTarFile 写入一个普通文件，时间固定，使同样的镜像得到同样的归档
+func writeTarFile(tw *tar.Writer, name string, size int64, r io.Reader) error {
+	if err := tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     0644,
+		Size:     size,
+		ModTime:  time.Unix(0, 0),
+	}); err != nil {
+		return err
+	}
+	_, err := io.Copy(tw, r)
+	return err
+}
+
+// contains 判断字符串是否在列表中
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
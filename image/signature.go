@@ -0,0 +1,152 @@
+package image
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// ------------------------
+// 镜像签名
+//   signatures/<hex>.json  以镜像 manifest 摘要命名，内容为该 manifest 的所有签名
+// 签名的对象是镜像的 OCI manifest（save 写入归档的 manifest，各层为未压缩的 tar 包），
+// 它只由镜像配置和各层决定，同一镜像在任何主机上经过 build、pull、load 得到的摘要都相同
+// 签名的生成和校验见 trust 包
+// ------------------------
+
+func signaturesDir() string { return filepath.Join(StoreRoot, "signatures") }
+
+// Signature 是对镜像 manifest 摘要的签名
+type Signature struct {
+	KeyID     string `json:"keyId"`     // 签名公钥的 sha256 摘要
+	Payload   []byte `json:"payload"`   // 被签名的内容，记录仓库名和 manifest 摘要
+	Signature []byte `json:"signature"` // 对 Payload 的 ed25519 签名
+}
+
+// Verifier 在 pull、load 保存镜像配置之前检查镜像的签名，names 为镜像将被标记的仓库名
+type Verifier func(manifestDigest string, names []string) error
+
+// ManifestOf 由镜像配置生成镜像的 OCI manifest，各层必须已在镜像存储中
+func ManifestOf(config []byte) ([]byte, error) {
+	img := &Image{}
+	if err := json.Unmarshal(config, img); err != nil {
+		return nil, fmt.Errorf("parse image config error %v", err)
+	}
+	manifest := Manifest{
+		SchemaVersion: 2,
+		MediaType:     MediaTypeOCIManifest,
+		Config:        Descriptor{MediaType: MediaTypeOCIConfig, Digest: DigestOf(config), Size: int64(len(config))},
+		Layers:        []Descriptor{},
+	}
+	for _, diffID := range img.RootFS.DiffIDs {
+		info, err := os.Stat(blobPath(diffID))
+		if err != nil {
+			return nil, fmt.Errorf("layer %s does not exist", diffID)
+		}
+		manifest.Layers = append(manifest.Layers, Descriptor{MediaType: MediaTypeOCILayer, Digest: diffID, Size: info.Size()})
+	}
+	return json.Marshal(manifest)
+}
+
+// ManifestDigest 返回镜像 OCI manifest 的摘要
+func ManifestDigest(id string) (string, error) {
+	config, err := ConfigBytes(id)
+	if err != nil {
+		return "", err
+	}
+	manifest, err := ManifestOf(config)
+	if err != nil {
+		return "", err
+	}
+	return DigestOf(manifest), nil
+}
+
+// Signatures 返回 manifest 的所有签名，没有签名时返回空列表
+func Signatures(manifestDigest string) ([]Signature, error) {
+	path := filepath.Join(signaturesDir(), digestHex(manifestDigest)+".json")
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var signatures []Signature
+	if err := json.Unmarshal(content, &signatures); err != nil {
+		return nil, fmt.Errorf("parse %s error %v", path, err)
+	}
+	return signatures, nil
+}
+
+// AddSignatures 保存 manifest 的签名，已有的相同签名跳过
+func AddSignatures(manifestDigest string, signatures []Signature) error {
+	existing, err := Signatures(manifestDigest)
+	if err != nil {
+		return err
+	}
+	for _, s := range signatures {
+		found := false
+		for _, e := range existing {
+			if e.KeyID == s.KeyID && bytes.Equal(e.Payload, s.Payload) && bytes.Equal(e.Signature, s.Signature) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			existing = append(existing, s)
+		}
+	}
+	return writeSignatures(manifestDigest, existing)
+}
+
+// ImportSignatures 保存 pull、load 得到的签名后用 verify 检查镜像
+// 检查不通过时恢复原有的签名，不把未通过校验的镜像带来的签名留在镜像存储中
+func ImportSignatures(manifestDigest string, signatures []Signature, names []string, verify Verifier) error {
+	previous, err := Signatures(manifestDigest)
+	if err != nil {
+		return err
+	}
+	if len(signatures) > 0 {
+		if err := AddSignatures(manifestDigest, signatures); err != nil {
+			return err
+		}
+	}
+	if verify == nil {
+		return nil
+	}
+	if err := verify(manifestDigest, names); err != nil {
+		if len(signatures) > 0 {
+			if restoreErr := writeSignatures(manifestDigest, previous); restoreErr != nil {
+				log.Errorf("Restore signatures of %s error %v", manifestDigest, restoreErr)
+			}
+		}
+		return err
+	}
+	return nil
+}
+
+// writeSignatures 替换 manifest 的所有签名，没有签名时删除签名文件
+func writeSignatures(manifestDigest string, signatures []Signature) error {
+	path := filepath.Join(signaturesDir(), digestHex(manifestDigest)+".json")
+	if len(signatures) == 0 {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	content, err := json.Marshal(signatures)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(signaturesDir(), 0700); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path+".tmp", content, 0600); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
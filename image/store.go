@@ -0,0 +1,553 @@
+package image
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-docker/archive"
+	"go-docker/storage"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ------------------------
+// 镜像存储目录布局
+//   blobs/sha256/<hex>     镜像层的未压缩 tar 包，以内容摘要（diff ID）命名
+//   layers/<driver>/<hex>/ 按存储驱动解压后的镜像层，作为容器的只读层；首次使用时从 blobs 中解压
+//   imagedb/sha256/<hex>   镜像配置，文件名即镜像 ID
+//   repositories.json      name:tag 和 name@digest（仓库中的 manifest 摘要）到镜像 ID 的映射
+//   distribution/<hex>     以 diff ID 命名，内容为该层在仓库中（压缩后）的摘要，push 时跳过仓库中已有的层
+//   buildcache/<hex>       build 的缓存，文件名为构建步骤的缓存键，内容为该步骤生成的镜像层
+//   containers/<name>      容器使用的镜像层，见 refs.go
+//   signatures/<hex>.json  镜像的签名，见 signature.go
+// ------------------------
+
+var StoreRoot = "/root/image"
+
+// ErrImageNotFound 表示引用或 ID 没有对应的镜像
+var ErrImageNotFound = errors.New("no such image")
+
+func blobsDir() string              { return filepath.Join(StoreRoot, "blobs", "sha256") }
+func layersDir() string             { return filepath.Join(StoreRoot, "layers") }
+func imagedbDir() string            { return filepath.Join(StoreRoot, "imagedb", "sha256") }
+func repositoriesPath() string      { return filepath.Join(StoreRoot, "repositories.json") }
+func buildCacheDir() string         { return filepath.Join(StoreRoot, "buildcache") }
+func distributionDir() string       { return filepath.Join(StoreRoot, "distribution") }
+func blobPath(digest string) string { return filepath.Join(blobsDir(), digestHex(digest)) }
+func layerPath(driver storage.Driver, diffID string) string {
+	return filepath.Join(layersDir(), driver.Name(), digestHex(diffID))
+}
+func configPath(id string) string { return filepath.Join(imagedbDir(), digestHex(id)) }
+
+// digestHex 去掉摘要的 sha256: 前缀
+func digestHex(digest string) string {
+	return strings.TrimPrefix(digest, "sha256:")
+}
+
+// DigestOf 返回内容的 sha256 摘要
+func DigestOf(content []byte) string {
+	sum := sha256.Sum256(content)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// writeContentAddressed 把数据写入 dir 下以其 sha256 摘要命名的文件，返回摘要
+// 先写临时文件再重命名，同样内容的文件已存在时直接复用
+func writeContentAddressed(dir string, r io.Reader) (string, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	tmp, err := ioutil.TempFile(dir, ".tmp-")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	hasher := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(tmp, hasher), r); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	digest := "sha256:" + hex.EncodeToString(hasher.Sum(nil))
+	if err := os.Rename(tmp.Name(), filepath.Join(dir, digestHex(digest))); err != nil {
+		return "", err
+	}
+	return digest, nil
+}
+
+// CreateLayer 把 tar 包（可以是 gzip、bzip2、xz、zstd 压缩的）保存为镜像层并解压，返回未压缩 tar 包的摘要（diff ID）
+func CreateLayer(r io.Reader) (string, error) {
+	layer, err := archive.DecompressStream(r)
+	if err != nil {
+		return "", err
+	}
+	defer layer.Close()
+	diffID, err := writeContentAddressed(blobsDir(), layer)
+	if err != nil {
+		return "", fmt.Errorf("write layer blob error %v", err)
+	}
+	if err := extractLayer(storage.Default(), diffID); err != nil {
+		return "", err
+	}
+	return diffID, nil
+}
+
+// extractLayer 用存储驱动把镜像层解压到 layers/<driver>/<hex>，已解压过的层直接复用
+// 先解压到临时目录再重命名，避免中途失败留下不完整的层
+func extractLayer(driver storage.Driver, diffID string) error {
+	dest := layerPath(driver, diffID)
+	if _, err := os.Stat(dest); err == nil {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), 0700); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempDir(filepath.Dir(dest), ".tmp-")
+	if err != nil {
+		return err
+	}
+	blob, err := os.Open(blobPath(diffID))
+	if err != nil {
+		os.RemoveAll(tmp)
+		return err
+	}
+	// 解压的同时校验 blob 的摘要，防止使用被篡改的层
+	err = driver.ApplyDiff(tmp, archive.NewVerifyingReader(blob, diffID))
+	blob.Close()
+	if err != nil {
+		os.RemoveAll(tmp)
+		return fmt.Errorf("extract layer %s error %v", diffID, err)
+	}
+	if err := os.Rename(tmp, dest); err != nil {
+		os.RemoveAll(tmp)
+		if _, statErr := os.Stat(dest); statErr == nil {
+			return nil
+		}
+		return err
+	}
+	return nil
+}
+
+// CreateImage 保存镜像配置，返回镜像 ID；镜像引用的层必须已经存在
+func CreateImage(img *Image) (string, error) {
+	for _, diffID := range img.RootFS.DiffIDs {
+		if !HasLayer(diffID) {
+			return "", fmt.Errorf("layer %s does not exist", diffID)
+		}
+	}
+	config, err := json.Marshal(img)
+	if err != nil {
+		return "", err
+	}
+	return writeContentAddressed(imagedbDir(), bytes.NewReader(config))
+}
+
+// NewImage 构造由给定各层和运行配置组成的镜像配置
+func NewImage(diffIDs []string, config Config) *Image {
+	return &Image{
+		Created:      time.Now().UTC(),
+		Architecture: runtime.GOARCH,
+		OS:           "linux",
+		Config:       config,
+		RootFS:       RootFS{Type: "layers", DiffIDs: diffIDs},
+	}
+}
+
+// StoreConfig 按原样保存从仓库或归档中获得的镜像配置，返回镜像 ID（即配置内容的摘要）
+// 不重新序列化配置，保证镜像 ID 与来源一致；镜像引用的层必须已经存在
+func StoreConfig(config []byte) (string, error) {
+	img := &Image{}
+	if err := json.Unmarshal(config, img); err != nil {
+		return "", fmt.Errorf("parse image config error %v", err)
+	}
+	for _, diffID := range img.RootFS.DiffIDs {
+		if !HasLayer(diffID) {
+			return "", fmt.Errorf("layer %s does not exist", diffID)
+		}
+	}
+	return writeContentAddressed(imagedbDir(), bytes.NewReader(config))
+}
+
+// ConfigBytes 返回镜像配置的原始内容，push 和 save 时按原样输出
+func ConfigBytes(id string) ([]byte, error) {
+	config, err := ioutil.ReadFile(configPath(id))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("%w: %s", ErrImageNotFound, id)
+		}
+		return nil, err
+	}
+	return config, nil
+}
+
+// GetImage 读取镜像配置
+func GetImage(id string) (*Image, error) {
+	config, err := ConfigBytes(id)
+	if err != nil {
+		return nil, err
+	}
+	img := &Image{}
+	if err := json.Unmarshal(config, img); err != nil {
+		return nil, fmt.Errorf("parse image config %s error %v", id, err)
+	}
+	return img, nil
+}
+
+// ListImages 返回所有镜像的 ID
+func ListImages() ([]string, error) {
+	files, err := ioutil.ReadDir(imagedbDir())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var ids []string
+	for _, f := range files {
+		if strings.HasPrefix(f.Name(), ".") {
+			continue
+		}
+		ids = append(ids, "sha256:"+f.Name())
+	}
+	return ids, nil
+}
+
+// Resolve 把镜像引用解析为镜像 ID，依次尝试 name:tag、name@digest、完整镜像 ID 和唯一的镜像 ID 前缀
+func Resolve(s string) (string, *Image, error) {
+	id, err := resolveID(s)
+	if err != nil {
+		return "", nil, err
+	}
+	img, err := GetImage(id)
+	if err != nil {
+		return "", nil, err
+	}
+	return id, img, nil
+}
+
+// resolveID 把镜像引用解析为镜像 ID
+func resolveID(s string) (string, error) {
+	if ref, err := ParseReference(s); err == nil {
+		repos, err := loadRepositories()
+		if err != nil {
+			return "", err
+		}
+		if ref.Digest != "" {
+			// name@digest 先按 pull 时记录的 manifest 摘要查找，再按镜像 ID 查找
+			if id, ok := repos[ref.Name+"@"+ref.Digest]; ok {
+				return id, nil
+			}
+			if _, err := os.Stat(configPath(ref.Digest)); err == nil {
+				return ref.Digest, nil
+			}
+		} else if id, ok := repos[ref.String()]; ok {
+			return id, nil
+		}
+	}
+
+	// 按镜像 ID 或 ID 前缀查找
+	prefix := digestHex(s)
+	if prefix == "" || strings.Trim(prefix, "0123456789abcdef") != "" {
+		return "", fmt.Errorf("%w: %s", ErrImageNotFound, s)
+	}
+	ids, err := ListImages()
+	if err != nil {
+		return "", err
+	}
+	match := ""
+	for _, id := range ids {
+		if strings.HasPrefix(digestHex(id), prefix) {
+			if match != "" {
+				return "", fmt.Errorf("image ID prefix %s is ambiguous", s)
+			}
+			match = id
+		}
+	}
+	if match == "" {
+		return "", fmt.Errorf("%w: %s", ErrImageNotFound, s)
+	}
+	return match, nil
+}
+
+// loadRepositories 读取 name:tag 到镜像 ID 的映射
+func loadRepositories() (map[string]string, error) {
+	repos := map[string]string{}
+	content, err := ioutil.ReadFile(repositoriesPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return repos, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(content, &repos); err != nil {
+		return nil, fmt.Errorf("parse %s error %v", repositoriesPath(), err)
+	}
+	return repos, nil
+}
+
+// saveRepositories 写入 name:tag 到镜像 ID 的映射，先写临时文件再重命名
+func saveRepositories(repos map[string]string) error {
+	content, err := json.Marshal(repos)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(StoreRoot, 0700); err != nil {
+		return err
+	}
+	tmp := repositoriesPath() + ".tmp"
+	if err := ioutil.WriteFile(tmp, content, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, repositoriesPath())
+}
+
+// Tag 为镜像添加 name:tag 引用，已有的同名引用被覆盖
+func Tag(id string, ref Reference) error {
+	if ref.Tag == "" || ref.Digest != "" {
+		return fmt.Errorf("%s is not a name:tag reference", ref)
+	}
+	if _, err := os.Stat(configPath(id)); err != nil {
+		return fmt.Errorf("%w: %s", ErrImageNotFound, id)
+	}
+	repos, err := loadRepositories()
+	if err != nil {
+		return err
+	}
+	repos[ref.String()] = id
+	return saveRepositories(repos)
+}
+
+// AddDigest 记录镜像在仓库中的 manifest 摘要，之后可以通过 name@digest 引用镜像
+func AddDigest(id, name, digest string) error {
+	if !IsDigestFormat(digest) {
+		return fmt.Errorf("invalid digest %s", digest)
+	}
+	if _, err := os.Stat(configPath(id)); err != nil {
+		return fmt.Errorf("%w: %s", ErrImageNotFound, id)
+	}
+	repos, err := loadRepositories()
+	if err != nil {
+		return err
+	}
+	repos[name+"@"+digest] = id
+	return saveRepositories(repos)
+}
+
+// Untag 删除一个 name:tag 引用
+func Untag(ref Reference) error {
+	repos, err := loadRepositories()
+	if err != nil {
+		return err
+	}
+	if _, ok := repos[ref.String()]; !ok {
+		return fmt.Errorf("%w: %s", ErrImageNotFound, ref)
+	}
+	delete(repos, ref.String())
+	return saveRepositories(repos)
+}
+
+// RepoTags 返回指向镜像的所有 name:tag 引用
+func RepoTags(id string) ([]string, error) {
+	return references(id, false)
+}
+
+// RepoDigests 返回指向镜像的所有 name@digest 引用
+func RepoDigests(id string) ([]string, error) {
+	return references(id, true)
+}
+
+// references 返回指向镜像的 name:tag 或 name@digest 引用
+func references(id string, digests bool) ([]string, error) {
+	repos, err := loadRepositories()
+	if err != nil {
+		return nil, err
+	}
+	var tags []string
+	for ref, refID := range repos {
+		if refID == id && strings.Contains(ref, "@") == digests {
+			tags = append(tags, ref)
+		}
+	}
+	sort.Strings(tags)
+	return tags, nil
+}
+
+// DeleteImage 删除镜像配置和指向它的所有引用，再回收不再被其他镜像和容器使用的层
+func DeleteImage(id string) error {
+	if _, err := os.Stat(configPath(id)); err != nil {
+		return fmt.Errorf("%w: %s", ErrImageNotFound, id)
+	}
+	repos, err := loadRepositories()
+	if err != nil {
+		return err
+	}
+	for ref, refID := range repos {
+		if refID == id {
+			delete(repos, ref)
+		}
+	}
+	if err := saveRepositories(repos); err != nil {
+		return err
+	}
+	if digest, err := ManifestDigest(id); err == nil {
+		os.Remove(filepath.Join(signaturesDir(), digestHex(digest)+".json"))
+	}
+	if err := os.Remove(configPath(id)); err != nil {
+		return err
+	}
+	return GarbageCollect()
+}
+
+// LayerDirs 按从下到上的顺序返回镜像各层由存储驱动解压后的目录，还没有解压的层先解压
+func LayerDirs(img *Image, driver storage.Driver) ([]string, error) {
+	var dirs []string
+	for _, diffID := range img.RootFS.DiffIDs {
+		if !HasLayer(diffID) {
+			return nil, fmt.Errorf("layer %s does not exist", diffID)
+		}
+		if err := extractLayer(driver, diffID); err != nil {
+			return nil, err
+		}
+		dirs = append(dirs, layerPath(driver, diffID))
+	}
+	return dirs, nil
+}
+
+// Size 返回镜像各层未压缩 tar 包的总大小
+func Size(img *Image) int64 {
+	var size int64
+	for _, diffID := range img.RootFS.DiffIDs {
+		if info, err := os.Stat(blobPath(diffID)); err == nil {
+			size += info.Size()
+		}
+	}
+	return size
+}
+
+// Inspect 返回镜像的详细信息
+func Inspect(id string) (*InspectInfo, error) {
+	img, err := GetImage(id)
+	if err != nil {
+		return nil, err
+	}
+	tags, err := RepoTags(id)
+	if err != nil {
+		return nil, err
+	}
+	digests, err := RepoDigests(id)
+	if err != nil {
+		return nil, err
+	}
+	if tags == nil {
+		tags = []string{}
+	}
+	if digests == nil {
+		digests = []string{}
+	}
+	return &InspectInfo{
+		ID:           id,
+		RepoTags:     tags,
+		RepoDigests:  digests,
+		Created:      img.Created,
+		Author:       img.Author,
+		Architecture: img.Architecture,
+		Os:           img.OS,
+		Config:       img.Config,
+		RootFS:       img.RootFS,
+		Size:         Size(img),
+	}, nil
+}
+
+// ImportTar 把根文件系统的 tar 包导入为单层镜像并打上标签，返回镜像 ID
+func ImportTar(path string, ref Reference) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	diffID, err := CreateLayer(f)
+	if err != nil {
+		return "", err
+	}
+	img := NewImage([]string{diffID}, Config{})
+	img.History = []History{{Created: img.Created, Comment: "Imported from " + path}}
+	id, err := CreateImage(img)
+	if err != nil {
+		return "", err
+	}
+	if err := Tag(id, ref); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// GetBuildCache 返回构建缓存中缓存键对应的镜像层，层已被删除时视为未命中
+func GetBuildCache(key string) (string, bool) {
+	content, err := ioutil.ReadFile(filepath.Join(buildCacheDir(), digestHex(key)))
+	if err != nil {
+		return "", false
+	}
+	diffID := strings.TrimSpace(string(content))
+	return diffID, HasLayer(diffID)
+}
+
+// SetBuildCache 记录构建步骤生成的镜像层
+func SetBuildCache(key, diffID string) error {
+	if err := os.MkdirAll(buildCacheDir(), 0700); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(buildCacheDir(), digestHex(key)), []byte(diffID), 0600)
+}
+
+// LayerBlob 打开镜像层的未压缩 tar 包
+func LayerBlob(diffID string) (*os.File, error) {
+	return os.Open(blobPath(diffID))
+}
+
+// HasLayer 判断镜像层是否已经存在（未压缩的 tar 包已保存）
+func HasLayer(diffID string) bool {
+	_, err := os.Stat(blobPath(diffID))
+	return err == nil
+}
+
+// distributionRecord 是镜像层在仓库中的摘要和媒体类型，压缩方式不同的同一层摘要也不同
+type distributionRecord struct {
+	Digest    string `json:"digest"`
+	MediaType string `json:"mediaType"`
+}
+
+// SetDistributionDigest 记录镜像层在仓库中（压缩后）的摘要及其媒体类型
+func SetDistributionDigest(diffID, digest, mediaType string) error {
+	if err := os.MkdirAll(distributionDir(), 0700); err != nil {
+		return err
+	}
+	content, err := json.Marshal(&distributionRecord{Digest: digest, MediaType: mediaType})
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(distributionDir(), digestHex(diffID)), content, 0600)
+}
+
+// DistributionDigest 返回 pull 或 push 时记录的镜像层在仓库中的摘要和媒体类型
+// 之前的版本只记录了摘要，媒体类型未知，视为没有记录
+func DistributionDigest(diffID string) (string, string, bool) {
+	content, err := ioutil.ReadFile(filepath.Join(distributionDir(), digestHex(diffID)))
+	if err != nil {
+		return "", "", false
+	}
+	record := distributionRecord{}
+	if err := json.Unmarshal(content, &record); err != nil || record.Digest == "" || record.MediaType == "" {
+		return "", "", false
+	}
+	return record.Digest, record.MediaType, true
+}
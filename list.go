@@ -26,8 +26,8 @@ func ListContainers() {
 	var containers []*container.ContainerInfo
 	// 遍历目录中的所有文件
 	for _, file := range files {
-		// 如果文件名为 "network"，则跳过该文件（这是一个特殊文件，不是容器的配置文件）
-		if file.Name() == "network" {
+		// 如果文件名为 "network" 或 "plugins"，则跳过该文件（网络配置和数据卷插件的目录，不是容器的配置文件）
+		if file.Name() == "network" || file.Name() == "plugins" {
 			continue
 		}
 		// 获取容器的配置信息
@@ -89,3 +89,21 @@ func getContainerInfo(file os.FileInfo) (*container.ContainerInfo, error) {
 	// 返回容器的配置信息
 	return &containerInfo, nil
 }
+
+// inspectContainers 以 JSON 数组的形式输出容器的详细信息
+func inspectContainers(containerNames []string) error {
+	infos := []*container.ContainerInfo{}
+	for _, containerName := range containerNames {
+		containerInfo, err := getContainerInfoByName(containerName)
+		if err != nil {
+			return fmt.Errorf("no such container: %s", containerName)
+		}
+		infos = append(infos, containerInfo)
+	}
+	infoJson, err := json.MarshalIndent(infos, "", "    ")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(os.Stdout, string(infoJson))
+	return nil
+}
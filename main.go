@@ -3,6 +3,7 @@ package main
 import (
 	log "github.com/sirupsen/logrus"
 	"github.com/urfave/cli"
+	"go-docker/storage"
 	"os"
 )
 
@@ -19,8 +20,42 @@ func main() {
 
 	// 定义应用支持的命令
 	app.Commands = []cli.Command{
-		initCommand, // 初始化命令
-		runCommand,  // 运行命令
+		initCommand,     // 初始化命令
+		runCommand,      // 运行命令
+		listCommand,     // 列出容器
+		inspectCommand,  // 查看容器详细信息
+		logCommand,      // 查看容器日志
+		execCommand,     // 在容器中执行命令
+		stopCommand,     // 停止容器
+		removeCommand,   // 删除容器
+		commitCommand,   // 提交容器为镜像
+		snapshotCommand, // 容器可写层快照
+		buildCommand,    // 按照 Dockerfile 构建镜像
+		imagesCommand,   // 列出镜像
+		historyCommand,  // 查看镜像的构建历史
+		rmiCommand,      // 删除镜像
+		tagCommand,      // 为镜像添加标签
+		pullCommand,     // 从仓库拉取镜像
+		pushCommand,     // 把镜像推送到仓库
+		saveCommand,     // 把镜像保存为 tar 包
+		loadCommand,     // 从归档中导入镜像
+		imageCommand,    // 镜像管理命令
+		networkCommand,  // 容器网络命令
+		volumeCommand,   // 数据卷管理命令
+		trustCommand,    // 镜像签名命令
+		systemCommand,   // 系统管理命令
+		createCommand,   // OCI：按 bundle 创建容器
+		startCommand,    // OCI：启动已创建的容器
+		stateCommand,    // OCI：输出容器状态
+		deleteCommand,   // OCI：删除容器
+	}
+
+	// 全局选项
+	app.Flags = []cli.Flag{
+		cli.StringFlag{
+			Name:  "storage-driver",
+			Usage: "storage driver: overlay, aufs or vfs (probe the kernel by default)",
+		},
 	}
 
 	// 在应用执行前进行一些设置
@@ -29,6 +64,10 @@ func main() {
 		log.SetFormatter(&log.JSONFormatter{})
 		// 设置日志输出到标准输出
 		log.SetOutput(os.Stdout)
+		// 指定存储驱动时检查内核是否支持
+		if name := context.GlobalString("storage-driver"); name != "" {
+			return storage.SetDefault(name)
+		}
 		return nil
 	}
 
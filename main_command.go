@@ -6,15 +6,25 @@ import (
 	"github.com/urfave/cli"
 	"go-docker/cgroups/subsystems"
 	"go-docker/container"
+	"go-docker/image"
 	"go-docker/network"
+	"go-docker/registry"
+	"go-docker/trust"
 	"os"
+	"path/filepath"
 )
 
 // 定义 runCommand 命令：创建一个新的容器，带有命名空间和 cgroups 限制
 var runCommand = cli.Command{
 	Name:  "run",                                                                                        // 命令名称
 	Usage: `Create a container with namespace and cgroups limit ie: mydocker run -ti [image] [command]`, // 命令用法说明
+	// -h 用于设置主机名（与 docker 一致），因此隐藏默认的 help, h 选项，改为只提供 --help
+	HideHelp: true,
 	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "help", // 显示帮助，由 cli 自动处理
+			Usage: "show help",
+		},
 		cli.BoolFlag{
 			Name:  "ti", // 启用 TTY
 			Usage: "enable tty",
@@ -39,28 +49,93 @@ var runCommand = cli.Command{
 			Name:  "name", // 设置容器名称
 			Usage: "container name",
 		},
-		cli.StringFlag{
-			Name:  "v", // 设置容器挂载的卷
-			Usage: "volume",
+		cli.StringSliceFlag{
+			Name:  "v", // 挂载宿主机目录或数据卷，可以指定多个
+			Usage: "bind mount a volume: [host-dir|volume-name:]container-dir[:ro|rw][,propagation|nocopy]",
+		},
+		cli.StringSliceFlag{
+			Name:  "mount", // 以 key=value 的形式指定挂载，可以指定多个
+			Usage: "attach a filesystem mount: type=bind|volume|tmpfs,source=...,target=...[,readonly][,bind-propagation=...][,volume-nocopy][,volume-driver=...]",
+		},
+		cli.StringSliceFlag{
+			Name:  "storage-opt", // 可写层的存储选项，目前只支持 size
+			Usage: "storage driver options for the container, ie: size=10G",
+		},
+		cli.StringSliceFlag{
+			Name:  "volumes-from", // 挂载其他容器的数据卷和 bind 挂载，可以指定多个
+			Usage: "mount volumes from the specified container: container[:ro|rw]",
+		},
+		cli.StringSliceFlag{
+			Name:  "tmpfs", // 挂载 tmpfs，可以指定多个
+			Usage: "mount a tmpfs directory: container-dir[:size=...,mode=...,exec]",
+		},
+		cli.BoolFlag{
+			Name:  "read-only", // 以只读方式挂载容器的根文件系统
+			Usage: "mount the container's root filesystem as read only",
 		},
 		cli.StringSliceFlag{
 			Name:  "e", // 设置环境变量
 			Usage: "set environment",
 		},
+		cli.StringSliceFlag{
+			Name:  "env-file", // 从文件读取环境变量
+			Usage: "read in a file of environment variables",
+		},
 		cli.StringFlag{
-			Name:  "net", // 设置容器网络
-			Usage: "container network",
+			Name:  "workdir, w", // 设置容器内的工作目录
+			Usage: "working directory inside the container",
+		},
+		cli.StringFlag{
+			Name:  "user, u", // 设置运行用户
+			Usage: "username or UID, ie: user[:group]",
+		},
+		cli.StringFlag{
+			Name:  "hostname, h", // 设置容器主机名
+			Usage: "container host name",
+		},
+		cli.StringFlag{
+			Name:  "entrypoint", // 覆盖命令前缀
+			Usage: "entrypoint executed with the container command as its arguments",
+		},
+		cli.StringFlag{
+			Name:  "net", // 设置容器网络，或网络命名空间模式
+			Usage: "container network, or network namespace mode: host|none|container:<name>",
+		},
+		cli.StringFlag{
+			Name:  "pid", // 设置 PID 命名空间模式
+			Usage: "pid namespace mode: host|container:<name>",
+		},
+		cli.StringFlag{
+			Name:  "ipc", // 设置 IPC 命名空间模式
+			Usage: "ipc namespace mode: host|container:<name>",
+		},
+		cli.StringFlag{
+			Name:  "uts", // 设置 UTS 命名空间模式
+			Usage: "uts namespace mode: host|container:<name>",
+		},
+		cli.BoolFlag{
+			Name:  "init", // 由 init 进程作为 PID 1 回收僵尸进程并转发信号
+			Usage: "run an init inside the container that forwards signals and reaps processes",
+		},
+		cli.StringSliceFlag{
+			Name:  "ulimit", // 设置资源限制，如 nofile=1024:2048
+			Usage: "ulimit options, ie: nofile=1024:2048",
 		},
 		cli.StringSliceFlag{
 			Name:  "p", // 设置端口映射
 			Usage: "port mapping",
 		},
+		cli.StringFlag{
+			Name:  "rootfs", // 以目录作为根文件系统，不使用镜像
+			Usage: "use a root filesystem directory instead of an image, ie: mydocker run --rootfs /path command",
+		},
 	},
 	// 处理命令的执行逻辑
 	Action: func(context *cli.Context) error {
-		// 检查是否传递了容器命令
-		if len(context.Args()) < 1 {
-			return fmt.Errorf("Missing container command")
+		// 指定 --rootfs 时所有参数都是容器命令，否则第一个参数是镜像名称
+		rootfs := context.String("rootfs")
+		if rootfs == "" && len(context.Args()) < 1 {
+			return fmt.Errorf("Missing image name")
 		}
 
 		// 将命令行参数中的容器命令保存到数组中
@@ -70,8 +145,11 @@ var runCommand = cli.Command{
 		}
 
 		// 获取镜像名称（命令行第一个参数）
-		imageName := cmdArray[0]
-		cmdArray = cmdArray[1:]
+		imageName := ""
+		if rootfs == "" {
+			imageName = cmdArray[0]
+			cmdArray = cmdArray[1:]
+		}
 
 		// 检查是否启用了 TTY 和 detach 两个参数，不能同时使用
 		createTty := context.Bool("ti")
@@ -90,16 +168,125 @@ var runCommand = cli.Command{
 
 		log.Infof("createTty %v", createTty)
 
-		// 获取容器名称、卷、网络、环境变量和端口映射
+		// 获取容器名称、挂载、网络、环境变量和端口映射
 		containerName := context.String("name")
-		volume := context.String("v")
+		var mounts []container.MountPoint
+		for _, v := range context.StringSlice("v") {
+			m, err := container.ParseVolume(v)
+			if err != nil {
+				return err
+			}
+			mounts = append(mounts, m)
+		}
+		for _, v := range context.StringSlice("mount") {
+			m, err := container.ParseMount(v)
+			if err != nil {
+				return err
+			}
+			mounts = append(mounts, m)
+		}
+		for _, v := range context.StringSlice("tmpfs") {
+			m, err := container.ParseTmpfs(v)
+			if err != nil {
+				return err
+			}
+			mounts = append(mounts, m)
+		}
+		if err := container.ValidateMountPoints(mounts); err != nil {
+			return err
+		}
+		mounts, err := volumesFrom(context.StringSlice("volumes-from"), mounts)
+		if err != nil {
+			return err
+		}
+		storageSize, err := parseStorageOpts(context.StringSlice("storage-opt"))
+		if err != nil {
+			return err
+		}
 		network := context.String("net")
 		envSlice := context.StringSlice("e")
 		portmapping := context.StringSlice("p")
 
+		// 获取各命名空间的共享模式
+		nsConf := container.NamespaceConfig{
+			Net: network,
+			Pid: context.String("pid"),
+			Ipc: context.String("ipc"),
+			Uts: context.String("uts"),
+		}
+		if err := nsConf.Validate(); err != nil {
+			return err
+		}
+
+		// --env-file 中的变量先于 -e 设置，同名变量以 -e 为准
+		var fileEnvs []string
+		for _, envFile := range context.StringSlice("env-file") {
+			envs, err := container.ParseEnvFile(envFile)
+			if err != nil {
+				return fmt.Errorf("read env file %s error %v", envFile, err)
+			}
+			fileEnvs = append(fileEnvs, envs...)
+		}
+		envSlice = append(fileEnvs, envSlice...)
+
+		workdir := context.String("workdir")
+		if workdir != "" && !filepath.IsAbs(workdir) {
+			return fmt.Errorf("the working directory %s is invalid, it needs to be an absolute path", workdir)
+		}
+		hostname := context.String("hostname")
+		if hostname != "" && !nsConf.IsPrivate("uts") {
+			return fmt.Errorf("conflicting options: hostname and the uts namespace mode %s", nsConf.Uts)
+		}
+
+		// 解析镜像，并把镜像的默认运行配置与命令行参数合并；根文件系统目录没有默认运行配置
+		imageID := ""
+		imgConfig := image.Config{}
+		if rootfs != "" {
+			abs, err := filepath.Abs(rootfs)
+			if err != nil {
+				return err
+			}
+			if info, err := os.Stat(abs); err != nil || !info.IsDir() {
+				return fmt.Errorf("rootfs %s is not a directory", rootfs)
+			}
+			rootfs = abs
+		} else {
+			id, img, err := resolveImage(imageName)
+			if err != nil {
+				return err
+			}
+			if err := verifyImage(imageName, id); err != nil {
+				return err
+			}
+			imageID, imgConfig = id, img.Config
+		}
+		config, err := mergeRunConfig(imgConfig, context.String("entrypoint"), cmdArray, envSlice,
+			workdir, context.String("user"), portmapping)
+		if err != nil {
+			return err
+		}
+
+		// 构造发送给容器 init 进程的设置
+		spec := &container.InitSpec{
+			Args:     append(append([]string{}, config.Entrypoint...), config.Cmd...),
+			Env:      config.Env,
+			Cwd:      config.WorkingDir,
+			User:     config.User,
+			Hostname: hostname,
+			Init:     context.Bool("init"),
+
+			ReadonlyRoot: context.Bool("read-only"),
+		}
+		for _, u := range context.StringSlice("ulimit") {
+			rlimit, err := container.ParseRlimit(u)
+			if err != nil {
+				return err
+			}
+			spec.Rlimits = append(spec.Rlimits, rlimit)
+		}
+
 		// 调用 Run 函数启动容器
-		Run(createTty, cmdArray, &resConf, containerName, volume, imageName, envSlice, network, portmapping)
-		return nil
+		return Run(createTty, spec, config, &resConf, containerName, mounts, imageName, imageID, rootfs, storageSize, network, portmapping, &nsConf)
 	},
 }
 
@@ -126,6 +313,19 @@ var listCommand = cli.Command{
 	},
 }
 
+// 定义 inspectCommand 命令：查看容器的详细信息
+var inspectCommand = cli.Command{
+	Name:  "inspect",                                                // 命令名称
+	Usage: "display detailed information on one or more containers", // 命令用法说明
+	Action: func(context *cli.Context) error {
+		// 检查是否提供了容器名称
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("Missing container name")
+		}
+		return inspectContainers(context.Args())
+	},
+}
+
 // 定义 logCommand 命令：打印指定容器的日志
 var logCommand = cli.Command{
 	Name:  "logs",                      // 命令名称
@@ -149,7 +349,7 @@ var execCommand = cli.Command{
 	Action: func(context *cli.Context) error {
 		// 如果是回调操作，返回
 		if os.Getenv(ENV_EXEC_PID) != "" {
-			log.Infof("pid callback pid %s", os.Getgid())
+			log.Infof("pid callback pid %d", os.Getgid())
 			return nil
 		}
 
@@ -203,8 +403,22 @@ var removeCommand = cli.Command{
 
 // 定义 commitCommand 命令：将容器提交为镜像
 var commitCommand = cli.Command{
-	Name:  "commit",                        // 命令名称
-	Usage: "commit a container into image", // 命令用法说明
+	Name:  "commit",                                                                                                     // 命令名称
+	Usage: "commit a container into image ie: mydocker commit [-a author] [-m message] [-c change] [container] [image]", // 命令用法说明
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "author, a", // 镜像作者，如 "John Hannibal Smith <hannibal@a-team.com>"
+			Usage: "author of the image",
+		},
+		cli.StringFlag{
+			Name:  "message, m", // 提交信息，记录在镜像的构建历史中
+			Usage: "commit message",
+		},
+		cli.StringSliceFlag{
+			Name:  "change, c", // 修改镜像配置的 Dockerfile 风格指令
+			Usage: "apply Dockerfile instruction to the created image: CMD|ENTRYPOINT|ENV|EXPOSE|LABEL|USER|WORKDIR",
+		},
+	},
 	Action: func(context *cli.Context) error {
 		// 检查是否提供了容器名称和镜像名称
 		if len(context.Args()) < 2 {
@@ -213,11 +427,461 @@ var commitCommand = cli.Command{
 		containerName := context.Args().Get(0)
 		imageName := context.Args().Get(1)
 		// 调用 commitContainer 函数将容器提交为镜像
-		commitContainer(containerName, imageName)
+		return commitContainer(containerName, imageName, context.String("author"), context.String("message"), context.StringSlice("change"))
+	},
+}
+
+// 定义 buildCommand 命令：按照 Dockerfile 构建镜像
+var buildCommand = cli.Command{
+	Name:  "build",                                                                                       // 命令名称
+	Usage: "build an image from a Dockerfile ie: mydocker build -t [name:tag] [-f Dockerfile] [context]", // 命令用法说明
+	Flags: []cli.Flag{
+		cli.StringSliceFlag{
+			Name:  "tag, t", // 镜像标签，可以指定多个
+			Usage: "name and optionally a tag in the name:tag format",
+		},
+		cli.StringFlag{
+			Name:  "file, f", // Dockerfile 路径，默认为构建上下文中的 Dockerfile
+			Usage: "name of the Dockerfile (default: [context]/Dockerfile)",
+		},
+		cli.BoolFlag{
+			Name:  "no-cache", // 不使用构建缓存
+			Usage: "do not use cache when building the image",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		// 检查是否提供了构建上下文
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("Missing build context")
+		}
+		return buildImage(context.Args().Get(0), context.String("file"), context.StringSlice("tag"), context.Bool("no-cache"))
+	},
+}
+
+// 定义 imagesCommand 命令：列出镜像
+var imagesCommand = cli.Command{
+	Name:  "images",      // 命令名称
+	Usage: "list images", // 命令用法说明
+	Action: func(context *cli.Context) error {
+		return listImages()
+	},
+}
+
+// 定义 historyCommand 命令：查看镜像的构建历史
+var historyCommand = cli.Command{
+	Name:  "history",                                                   // 命令名称
+	Usage: "show the history of an image ie: mydocker history [image]", // 命令用法说明
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "no-trunc", // 不截断生成步骤的命令
+			Usage: "don't truncate output",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		// 检查是否提供了镜像
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("Missing image name")
+		}
+		return imageHistory(context.Args().Get(0), context.Bool("no-trunc"))
+	},
+}
+
+// 定义 rmiCommand 命令：删除镜像
+var rmiCommand = cli.Command{
+	Name:  "rmi",                                                      // 命令名称
+	Usage: "remove one or more images ie: mydocker rmi [-f] image...", // 命令用法说明
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "force, f", // 强制删除有多个标签或正被容器使用的镜像
+			Usage: "force removal of the image",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		// 检查是否提供了镜像
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("Missing image name")
+		}
+		for _, imageName := range context.Args() {
+			if err := removeImage(imageName, context.Bool("force")); err != nil {
+				return err
+			}
+		}
 		return nil
 	},
 }
 
+// 定义 tagCommand 命令：为镜像添加标签
+var tagCommand = cli.Command{
+	Name:  "tag",                                                                     // 命令名称
+	Usage: "create a tag that refers to an image ie: mydocker tag [source] [target]", // 命令用法说明
+	Action: func(context *cli.Context) error {
+		// 检查是否提供了源镜像和目标引用
+		if len(context.Args()) < 2 {
+			return fmt.Errorf("Missing source image and target name")
+		}
+		return tagImage(context.Args().Get(0), context.Args().Get(1))
+	},
+}
+
+// 定义 saveCommand 命令：把镜像保存为 tar 包
+var saveCommand = cli.Command{
+	Name:  "save",                                                                          // 命令名称
+	Usage: "save one or more images to a tar archive ie: mydocker save -o [file] image...", // 命令用法说明
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "output, o", // 输出文件，默认为标准输出
+			Usage: "write to a file, instead of STDOUT",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		// 检查是否提供了镜像
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("Missing image name")
+		}
+		return saveImages(context.Args(), context.String("output"))
+	},
+}
+
+// 定义 loadCommand 命令：从 docker save 或 OCI 镜像布局归档中导入镜像
+var loadCommand = cli.Command{
+	Name:  "load",                                                                     // 命令名称
+	Usage: "load images from a tar archive or OCI layout ie: mydocker load -i [file]", // 命令用法说明
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "input, i", // 输入的 tar 包或 OCI 镜像布局目录，默认为标准输入
+			Usage: "read from tar archive file or OCI layout directory, instead of STDIN",
+		},
+		cli.BoolFlag{
+			Name:  "quiet, q", // 不输出导入的镜像
+			Usage: "suppress the load output",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		return loadImages(context.String("input"), context.Bool("quiet"))
+	},
+}
+
+// registryFlags 是 pull 和 push 共用的仓库访问参数
+var registryFlags = []cli.Flag{
+	cli.BoolFlag{
+		Name:  "insecure", // 使用 HTTP 访问仓库
+		Usage: "access the registry over plain HTTP",
+	},
+	cli.StringFlag{
+		Name:  "username, u", // 仓库用户名
+		Usage: "registry username",
+	},
+	cli.StringFlag{
+		Name:  "password, p", // 仓库密码或访问令牌
+		Usage: "registry password or token",
+	},
+}
+
+// 定义 pullCommand 命令：从仓库拉取镜像
+var pullCommand = cli.Command{
+	Name:  "pull",                                                                 // 命令名称
+	Usage: "pull an image from a registry ie: mydocker pull [name[:tag|@digest]]", // 命令用法说明
+	Flags: registryFlags,
+	Action: func(context *cli.Context) error {
+		// 检查是否提供了镜像
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("Missing image name")
+		}
+		_, err := registry.Pull(context.Args().Get(0), context.Bool("insecure"), context.String("username"), context.String("password"), trust.Verify)
+		return err
+	},
+}
+
+// 定义 pushCommand 命令：把镜像推送到仓库
+var pushCommand = cli.Command{
+	Name:  "push",                                                       // 命令名称
+	Usage: "push an image to a registry ie: mydocker push [name[:tag]]", // 命令用法说明
+	Flags: registryFlags,
+	Action: func(context *cli.Context) error {
+		// 检查是否提供了镜像
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("Missing image name")
+		}
+		_, err := registry.Push(context.Args().Get(0), context.Bool("insecure"), context.String("username"), context.String("password"))
+		return err
+	},
+}
+
+// 定义 imageCommand 命令：镜像管理命令
+var imageCommand = cli.Command{
+	Name:  "image",         // 命令名称
+	Usage: "manage images", // 命令用法说明
+	Subcommands: []cli.Command{
+		{
+			Name:   "history", // 查看镜像的构建历史，同 history
+			Usage:  historyCommand.Usage,
+			Flags:  historyCommand.Flags,
+			Action: historyCommand.Action,
+		},
+		{
+			Name:  "inspect",                                            // 查看镜像详细信息
+			Usage: "display detailed information on one or more images", // 命令用法说明
+			Action: func(context *cli.Context) error {
+				// 检查是否提供了镜像
+				if len(context.Args()) < 1 {
+					return fmt.Errorf("Missing image name")
+				}
+				return inspectImages(context.Args())
+			},
+		},
+		{
+			Name:   "ls", // 列出镜像，同 images
+			Usage:  imagesCommand.Usage,
+			Action: imagesCommand.Action,
+		},
+		{
+			Name:   "load", // 导入镜像，同 load
+			Usage:  loadCommand.Usage,
+			Flags:  loadCommand.Flags,
+			Action: loadCommand.Action,
+		},
+		{
+			Name:   "pull", // 拉取镜像，同 pull
+			Usage:  pullCommand.Usage,
+			Flags:  pullCommand.Flags,
+			Action: pullCommand.Action,
+		},
+		{
+			Name:   "push", // 推送镜像，同 push
+			Usage:  pushCommand.Usage,
+			Flags:  pushCommand.Flags,
+			Action: pushCommand.Action,
+		},
+		{
+			Name:   "rm", // 删除镜像，同 rmi
+			Usage:  rmiCommand.Usage,
+			Flags:  rmiCommand.Flags,
+			Action: rmiCommand.Action,
+		},
+		{
+			Name:   "save", // 保存镜像，同 save
+			Usage:  saveCommand.Usage,
+			Flags:  saveCommand.Flags,
+			Action: saveCommand.Action,
+		},
+		{
+			Name:   "tag", // 为镜像添加标签，同 tag
+			Usage:  tagCommand.Usage,
+			Action: tagCommand.Action,
+		},
+	},
+}
+
+// 定义 trustCommand 命令：镜像签名命令
+var trustCommand = cli.Command{
+	Name:  "trust",                  // 命令名称
+	Usage: "manage trust on images", // 命令用法说明
+	Subcommands: []cli.Command{
+		{
+			Name:  "key",                 // 管理签名密钥
+			Usage: "manage signing keys", // 命令用法说明
+			Subcommands: []cli.Command{
+				{
+					Name:  "generate",                                                            // 生成密钥
+					Usage: "generate an ed25519 key pair ie: mydocker trust key generate [name]", // 命令用法说明
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  "dir", // 公钥的输出目录，默认为当前目录
+							Usage: "directory to write the public key to",
+						},
+					},
+					Action: func(context *cli.Context) error {
+						if len(context.Args()) < 1 {
+							return fmt.Errorf("Missing key name")
+						}
+						return generateKey(context.Args().Get(0), context.String("dir"))
+					},
+				},
+			},
+		},
+		{
+			Name:  "sign",                                                                 // 为镜像签名
+			Usage: "sign an image ie: mydocker trust sign --key [name|path] [name[:tag]]", // 命令用法说明
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "key, k", // 私钥的名称或路径
+					Usage: "name of a generated key, or path to an ed25519 private key",
+				},
+			},
+			Action: func(context *cli.Context) error {
+				if len(context.Args()) < 1 {
+					return fmt.Errorf("Missing image name")
+				}
+				if context.String("key") == "" {
+					return fmt.Errorf("Missing signing key")
+				}
+				return signImage(context.Args().Get(0), context.String("key"))
+			},
+		},
+		{
+			Name:  "inspect",                         // 查看镜像的签名
+			Usage: "show the signatures of an image", // 命令用法说明
+			Action: func(context *cli.Context) error {
+				if len(context.Args()) < 1 {
+					return fmt.Errorf("Missing image name")
+				}
+				return inspectTrust(context.Args().Get(0))
+			},
+		},
+	},
+}
+
+// 定义 systemCommand 命令：系统管理命令
+var systemCommand = cli.Command{
+	Name:  "system",          // 命令名称
+	Usage: "manage mydocker", // 命令用法说明
+	Subcommands: []cli.Command{
+		{
+			Name:  "df",              // 查看磁盘占用
+			Usage: "show disk usage", // 命令用法说明
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "verbose, v", // 逐个列出容器可写层的大小和上限
+					Usage: "show detailed information on space usage",
+				},
+			},
+			Action: func(context *cli.Context) error {
+				return systemDiskUsage(context.Bool("verbose"))
+			},
+		},
+	},
+}
+
+// 定义 volumeCommand 命令：数据卷管理命令
+var volumeCommand = cli.Command{
+	Name:  "volume",         // 命令名称
+	Usage: "manage volumes", // 命令用法说明
+	Subcommands: []cli.Command{
+		{
+			Name:  "create",                                                                               // 创建数据卷
+			Usage: "create a volume ie: mydocker volume create [-d driver] [-o k=v] [--label k=v] [name]", // 命令用法说明
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "driver, d", // 数据卷驱动，local 或数据卷插件的名称
+					Usage: "volume driver name",
+					Value: "local",
+				},
+				cli.StringSliceFlag{
+					Name:  "opt, o", // 传给驱动的选项，可以指定多个
+					Usage: "set driver specific options, key=value",
+				},
+				cli.StringSliceFlag{
+					Name:  "label", // 数据卷的标签，可以指定多个
+					Usage: "set metadata on a volume, key=value",
+				},
+			},
+			Action: func(context *cli.Context) error {
+				return createVolume(context.Args().Get(0), context.String("driver"), context.StringSlice("opt"), context.StringSlice("label"))
+			},
+		},
+		{
+			Name:  "ls",           // 列出数据卷
+			Usage: "list volumes", // 命令用法说明
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "quiet, q", // 只输出数据卷名称
+					Usage: "only display volume names",
+				},
+			},
+			Action: func(context *cli.Context) error {
+				return listVolumes(context.Bool("quiet"))
+			},
+		},
+		{
+			Name:  "inspect",                                 // 查看数据卷的详细信息
+			Usage: "display detailed information on volumes", // 命令用法说明
+			Action: func(context *cli.Context) error {
+				if len(context.Args()) < 1 {
+					return fmt.Errorf("Missing volume name")
+				}
+				return inspectVolumes(context.Args())
+			},
+		},
+		{
+			Name:  "rm",                                               // 删除数据卷
+			Usage: "remove volumes that are not in use by containers", // 命令用法说明
+			Action: func(context *cli.Context) error {
+				if len(context.Args()) < 1 {
+					return fmt.Errorf("Missing volume name")
+				}
+				return removeVolumes(context.Args())
+			},
+		},
+		{
+			Name:  "prune",                           // 删除未使用的数据卷
+			Usage: "remove unused anonymous volumes", // 命令用法说明
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "all, a", // 同时删除未使用的具名数据卷
+					Usage: "remove all unused volumes, not just anonymous ones",
+				},
+			},
+			Action: func(context *cli.Context) error {
+				return pruneVolumes(context.Bool("all"))
+			},
+		},
+	},
+}
+
+// 定义 snapshotCommand 命令：容器可写层快照
+var snapshotCommand = cli.Command{
+	Name:  "snapshot",                                   // 命令名称
+	Usage: "manage write-layer snapshots of containers", // 命令用法说明
+	Subcommands: []cli.Command{
+		{
+			Name:  "create",                                                                            // 创建快照
+			Usage: "copy the write layer of a container ie: mydocker snapshot create container [name]", // 命令用法说明
+			Action: func(context *cli.Context) error {
+				if len(context.Args()) < 1 {
+					return fmt.Errorf("Missing container name")
+				}
+				return createSnapshot(context.Args().Get(0), context.Args().Get(1))
+			},
+		},
+		{
+			Name:  "ls",                            // 列出快照
+			Usage: "list snapshots of a container", // 命令用法说明
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "quiet, q", // 只输出快照名称
+					Usage: "only display snapshot names",
+				},
+			},
+			Action: func(context *cli.Context) error {
+				if len(context.Args()) < 1 {
+					return fmt.Errorf("Missing container name")
+				}
+				return listSnapshots(context.Args().Get(0), context.Bool("quiet"))
+			},
+		},
+		{
+			Name:  "restore",                                                                      // 回滚到快照
+			Usage: "stop a container, restore its write layer from a snapshot and start it again", // 命令用法说明
+			Action: func(context *cli.Context) error {
+				if len(context.Args()) < 2 {
+					return fmt.Errorf("Missing container name or snapshot name")
+				}
+				return restoreSnapshot(context.Args().Get(0), context.Args().Get(1))
+			},
+		},
+		{
+			Name:  "rm",                              // 删除快照
+			Usage: "remove snapshots of a container", // 命令用法说明
+			Action: func(context *cli.Context) error {
+				if len(context.Args()) < 2 {
+					return fmt.Errorf("Missing container name or snapshot name")
+				}
+				return removeSnapshots(context.Args().Get(0), context.Args()[1:])
+			},
+		},
+	},
+}
+
 // 定义 networkCommand 命令：容器网络命令
 var networkCommand = cli.Command{
 	Name:  "network",                    // 命令名称
@@ -279,3 +943,68 @@ var networkCommand = cli.Command{
 		},
 	},
 }
+
+// 定义 createCommand 命令：按照 OCI bundle 创建容器（OCI 运行时接口）
+var createCommand = cli.Command{
+	Name:  "create",                                                                        // 命令名称
+	Usage: "create a container from an OCI bundle ie: mydocker create --bundle [dir] [id]", // 命令用法说明
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "bundle, b", // bundle 目录，默认为当前目录
+			Value: ".",
+			Usage: "path to the root of the bundle directory",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		// 检查是否提供了容器 ID
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("Missing container id")
+		}
+		return createBundleContainer(context.Args().Get(0), context.String("bundle"))
+	},
+}
+
+// 定义 startCommand 命令：执行由 create 创建的容器中的用户命令
+var startCommand = cli.Command{
+	Name:  "start",                               // 命令名称
+	Usage: "start a container created by create", // 命令用法说明
+	Action: func(context *cli.Context) error {
+		// 检查是否提供了容器 ID
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("Missing container id")
+		}
+		return startBundleContainer(context.Args().Get(0))
+	},
+}
+
+// 定义 stateCommand 命令：以 OCI 格式输出容器状态
+var stateCommand = cli.Command{
+	Name:  "state",                                // 命令名称
+	Usage: "output the state of an OCI container", // 命令用法说明
+	Action: func(context *cli.Context) error {
+		// 检查是否提供了容器 ID
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("Missing container id")
+		}
+		return stateBundleContainer(context.Args().Get(0))
+	},
+}
+
+// 定义 deleteCommand 命令：删除由 create 创建的容器
+var deleteCommand = cli.Command{
+	Name:  "delete",                                        // 命令名称
+	Usage: "delete a container created from an OCI bundle", // 命令用法说明
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "force, f", // 强制删除运行中的容器
+			Usage: "kill the container if it is still running",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		// 检查是否提供了容器 ID
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("Missing container id")
+		}
+		return deleteBundleContainer(context.Args().Get(0), context.Bool("force"))
+	},
+}
@@ -65,7 +65,7 @@ func (nw *Network) dump(dumpPath string) error {
 	// 打开文件（以写入模式）
 	nwFile, err := os.OpenFile(nwPath, os.O_TRUNC|os.O_WRONLY|os.O_CREATE, 0644)
 	if err != nil {
-		logrus.Errorf("error：", err)
+		logrus.Errorf("error：%v", err)
 		return err
 	}
 	defer nwFile.Close()
@@ -73,14 +73,14 @@ func (nw *Network) dump(dumpPath string) error {
 	// 将网络信息转为JSON格式
 	nwJson, err := json.Marshal(nw)
 	if err != nil {
-		logrus.Errorf("error：", err)
+		logrus.Errorf("error：%v", err)
 		return err
 	}
 
 	// 写入文件
 	_, err = nwFile.Write(nwJson)
 	if err != nil {
-		logrus.Errorf("error：", err)
+		logrus.Errorf("error：%v", err)
 		return err
 	}
 	return nil
@@ -116,7 +116,7 @@ func (nw *Network) load(dumpPath string) error {
 	// 将JSON内容解析为网络结构体
 	err = json.Unmarshal(nwJson[:n], nw)
 	if err != nil {
-		logrus.Errorf("Error load nw info", err)
+		logrus.Errorf("Error load nw info %v", err)
 		return err
 	}
 	return nil
@@ -320,8 +320,43 @@ func configPortMapping(ep *Endpoint, cinfo *container.ContainerInfo) error {
 	return nil
 }
 
+// 为 --net none 的容器启用回环接口，容器只拥有 lo 一个网络设备
+func configLoopback(cinfo *container.ContainerInfo) error {
+	f, err := os.OpenFile(fmt.Sprintf("/proc/%s/ns/net", cinfo.Pid), os.O_RDONLY, 0)
+	if err != nil {
+		return fmt.Errorf("error get container net namespace, %v", err)
+	}
+	defer f.Close()
+
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	// 获取当前的网络命名空间，配置完成后恢复
+	origns, err := netns.Get()
+	if err != nil {
+		return fmt.Errorf("error get current netns, %v", err)
+	}
+	defer origns.Close()
+
+	if err = netns.Set(netns.NsHandle(f.Fd())); err != nil {
+		return fmt.Errorf("error set netns, %v", err)
+	}
+	defer netns.Set(origns)
+
+	return setInterfaceUP("lo")
+}
+
 // 连接网络到容器
+// networkName 也可以是命名空间模式：none 只启用回环接口；host 和 container:<name> 共享已有的网络命名空间，不需要创建 veth
 func Connect(networkName string, cinfo *container.ContainerInfo) error {
+	if networkName == container.NamespaceNone {
+		return configLoopback(cinfo)
+	}
+	if _, shared := container.SharedContainer(networkName); shared || networkName == container.NamespaceHost {
+		logrus.Infof("container %s shares network namespace %s, skip endpoint setup", cinfo.Name, networkName)
+		return nil
+	}
+
 	network, ok := networks[networkName]
 	if !ok {
 		return fmt.Errorf("No Such Network: %s", networkName)
@@ -27,6 +27,12 @@ __attribute__((constructor)) void enter_namespace(void) {
 		//fprintf(stdout, "missing mydocker_cmd env skip nsenter");
 		return;
 	}
+	// 复制后从环境变量中删除，避免它们泄漏到容器内执行的命令中
+	mydocker_pid = strdup(mydocker_pid);
+	mydocker_cmd = strdup(mydocker_cmd);
+	unsetenv("mydocker_pid");
+	unsetenv("mydocker_cmd");
+
 	int i;
 	char nspath[1024];
 	char *namespaces[] = { "ipc", "uts", "net", "pid", "mnt" };
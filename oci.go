@@ -0,0 +1,268 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"go-docker/cgroups"
+	"go-docker/container"
+	"go-docker/oci"
+	"golang.org/x/sys/unix"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// execFifoName 是 OCI 容器在 create 和 start 之间同步使用的 fifo 文件名
+const execFifoName = "exec.fifo"
+
+// createBundleContainer 按照 OCI bundle 创建容器（对应 runtime-spec 的 create 操作）
+// 容器完成全部初始化后阻塞在 exec fifo 上，直到 start 才执行 process.args
+func createBundleContainer(containerID, bundle string) error {
+	bundle, err := filepath.Abs(bundle)
+	if err != nil {
+		return err
+	}
+	dirURL := fmt.Sprintf(container.DefaultInfoLocation, containerID)
+	if exist, _ := container.PathExists(dirURL); exist {
+		return fmt.Errorf("container %s already exists", containerID)
+	}
+
+	spec, err := oci.LoadSpec(bundle)
+	if err != nil {
+		return fmt.Errorf("load bundle %s error %v", bundle, err)
+	}
+	if spec.Process.Terminal {
+		return fmt.Errorf("process.terminal is not supported, the container inherits the caller's stdio")
+	}
+	// createContainer 和 startContainer 钩子需要在容器的命名空间中执行，不支持
+	if spec.Hooks != nil && (len(spec.Hooks.CreateContainer) > 0 || len(spec.Hooks.StartContainer) > 0) {
+		return fmt.Errorf("createContainer and startContainer hooks are not supported")
+	}
+	nsConf, err := spec.NamespaceConfig()
+	if err != nil {
+		return err
+	}
+	initSpec := spec.InitSpec()
+
+	// 创建容器信息目录和 exec fifo
+	if err := os.MkdirAll(dirURL, 0622); err != nil {
+		return err
+	}
+	initSpec.ExecFifo = filepath.Join(dirURL, execFifoName)
+	if err := unix.Mkfifo(initSpec.ExecFifo, 0622); err != nil {
+		deleteContainerInfo(containerID)
+		return fmt.Errorf("create exec fifo error %v", err)
+	}
+
+	parent, writePipe, errPipe := container.NewBundleProcess(spec.RootfsPath(bundle), nsConf)
+	if parent == nil {
+		deleteContainerInfo(containerID)
+		return fmt.Errorf("new parent process error")
+	}
+	if err := startParentProcess(parent, nsConf); err != nil {
+		deleteContainerInfo(containerID)
+		return err
+	}
+
+	cgroupManager := cgroups.NewCgroupManager(containerID)
+	// 创建过程中的任何失败都需要杀掉容器进程并清理
+	fail := func(err error) error {
+		writePipe.Close()
+		parent.Process.Kill()
+		parent.Wait()
+		cgroupManager.Destroy()
+		deleteContainerInfo(containerID)
+		return err
+	}
+
+	containerInfo := &container.ContainerInfo{
+		Id:          containerID,
+		Pid:         strconv.Itoa(parent.Process.Pid),
+		Name:        containerID,
+		Command:     strings.Join(initSpec.Args, " "),
+		Args:        initSpec.Args,
+		CreatedTime: time.Now().Format("2006-01-02 15:04:05"),
+		Status:      container.CREATED,
+		Namespaces:  *nsConf,
+		WorkingDir:  initSpec.Cwd,
+		User:        initSpec.User,
+		Hostname:    initSpec.Hostname,
+		Env:         initSpec.Env,
+		Bundle:      bundle,
+	}
+	if err := writeContainerInfo(containerInfo); err != nil {
+		return fail(err)
+	}
+
+	// 设置资源限制并将其应用到容器进程
+	cgroupManager.Set(spec.ResourceConfig())
+	cgroupManager.Apply(parent.Process.Pid)
+
+	// prestart 和 createRuntime 钩子在运行时的命名空间中执行，此时 init 进程还在等待 InitSpec，
+	// 命名空间已经创建，但还没有挂载文件系统和 pivot_root
+	if spec.Hooks != nil {
+		state := bundleState(containerInfo, spec)
+		state.Status = oci.StatusCreating
+		if err := oci.RunHooks("prestart", spec.Hooks.Prestart, state); err != nil {
+			return fail(err)
+		}
+		if err := oci.RunHooks("createRuntime", spec.Hooks.CreateRuntime, state); err != nil {
+			return fail(err)
+		}
+	}
+
+	// 发送 InitSpec 给容器，等待 init 进程完成初始化（此时 init 阻塞在 exec fifo 上）
+	if err := container.SendInitSpec(initSpec, writePipe); err != nil {
+		return fail(err)
+	}
+	if err := container.WaitInitResult(errPipe); err != nil {
+		return fail(err)
+	}
+	return nil
+}
+
+// startBundleContainer 执行由 create 创建的容器中的用户命令（对应 runtime-spec 的 start 操作）
+func startBundleContainer(containerID string) error {
+	containerInfo, spec, err := getBundleContainer(containerID)
+	if err != nil {
+		return err
+	}
+	if status := bundleStatus(containerInfo); status != container.CREATED {
+		return fmt.Errorf("container %s is %s, only created containers can be started", containerID, status)
+	}
+
+	// 以读方式打开 exec fifo，init 进程写入后随即执行用户命令
+	fifoPath := filepath.Join(fmt.Sprintf(container.DefaultInfoLocation, containerID), execFifoName)
+	fifo, err := os.OpenFile(fifoPath, os.O_RDONLY, 0)
+	if err != nil {
+		return fmt.Errorf("open exec fifo error %v", err)
+	}
+	_, err = ioutil.ReadAll(fifo)
+	fifo.Close()
+	if err != nil {
+		return fmt.Errorf("read exec fifo error %v", err)
+	}
+	os.Remove(fifoPath)
+
+	containerInfo.Status = container.RUNNING
+	if err := writeContainerInfo(containerInfo); err != nil {
+		return err
+	}
+
+	// poststart 钩子失败只记录警告，不影响容器运行
+	if spec.Hooks != nil {
+		if err := oci.RunHooks("poststart", spec.Hooks.Poststart, bundleState(containerInfo, spec)); err != nil {
+			log.Warnf("%v", err)
+		}
+	}
+	return nil
+}
+
+// stateBundleContainer 以 runtime-spec 定义的 JSON 格式输出容器状态
+func stateBundleContainer(containerID string) error {
+	containerInfo, spec, err := getBundleContainer(containerID)
+	if err != nil {
+		return err
+	}
+	stateJson, err := json.MarshalIndent(bundleState(containerInfo, spec), "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(os.Stdout, string(stateJson))
+	return nil
+}
+
+// deleteBundleContainer 删除容器的 cgroup 和容器信息（对应 runtime-spec 的 delete 操作）
+// 运行中的容器需要 force 才能删除，此时先用 SIGKILL 杀掉容器进程；bundle 本身不会被删除
+func deleteBundleContainer(containerID string, force bool) error {
+	containerInfo, spec, err := getBundleContainer(containerID)
+	if err != nil {
+		return err
+	}
+	if status := bundleStatus(containerInfo); status != container.STOP {
+		if !force {
+			return fmt.Errorf("container %s is %s, stop it first or use --force", containerID, status)
+		}
+		pid, _ := strconv.Atoi(containerInfo.Pid)
+		if err := syscall.Kill(pid, syscall.SIGKILL); err != nil {
+			return fmt.Errorf("kill container %s error %v", containerID, err)
+		}
+		for i := 0; i < 100 && processAlive(containerInfo.Pid); i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	cgroups.NewCgroupManager(containerID).Destroy()
+	deleteContainerInfo(containerID)
+
+	// poststop 钩子失败只记录警告
+	if spec.Hooks != nil {
+		containerInfo.Status = container.STOP
+		if err := oci.RunHooks("poststop", spec.Hooks.Poststop, bundleState(containerInfo, spec)); err != nil {
+			log.Warnf("%v", err)
+		}
+	}
+	return nil
+}
+
+// getBundleContainer 读取由 create 创建的容器信息和它的 bundle 配置
+func getBundleContainer(containerID string) (*container.ContainerInfo, *oci.Spec, error) {
+	containerInfo, err := getContainerInfoByName(containerID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("container %s does not exist", containerID)
+	}
+	if containerInfo.Bundle == "" {
+		return nil, nil, fmt.Errorf("container %s was not created from an OCI bundle", containerID)
+	}
+	spec, err := oci.LoadSpec(containerInfo.Bundle)
+	if err != nil {
+		return nil, nil, fmt.Errorf("load bundle %s error %v", containerInfo.Bundle, err)
+	}
+	return containerInfo, spec, nil
+}
+
+// bundleStatus 返回容器的当前状态，容器进程已经退出时为 stopped
+func bundleStatus(containerInfo *container.ContainerInfo) string {
+	if containerInfo.Status != container.STOP && !processAlive(containerInfo.Pid) {
+		return container.STOP
+	}
+	return containerInfo.Status
+}
+
+// bundleState 构造 runtime-spec 定义的容器状态
+func bundleState(containerInfo *container.ContainerInfo, spec *oci.Spec) *oci.State {
+	state := &oci.State{
+		Version:     oci.Version,
+		ID:          containerInfo.Id,
+		Status:      bundleStatus(containerInfo),
+		Bundle:      containerInfo.Bundle,
+		Annotations: spec.Annotations,
+	}
+	if state.Status != container.STOP {
+		state.Pid, _ = strconv.Atoi(containerInfo.Pid)
+	}
+	return state
+}
+
+// processAlive 判断进程是否存在，已退出但尚未被回收的僵尸进程视为不存在
+func processAlive(pid string) bool {
+	pidInt, err := strconv.Atoi(strings.TrimSpace(pid))
+	if err != nil || pidInt <= 0 {
+		return false
+	}
+	if syscall.Kill(pidInt, 0) != nil {
+		return false
+	}
+	// /proc/<pid>/stat 的格式为 pid (comm) state ...，comm 中可能包含空格和括号
+	stat, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/stat", pidInt))
+	if err != nil {
+		return true
+	}
+	i := strings.LastIndex(string(stat), ")")
+	return i < 0 || len(stat) < i+3 || stat[i+2] != 'Z'
+}
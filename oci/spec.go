@@ -0,0 +1,241 @@
+package oci
+
+import (
+	"encoding/json"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"go-docker/cgroups/subsystems"
+	"go-docker/container"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+)
+
+// Version 是支持的 OCI runtime-spec 版本，也用于 state 输出
+const Version = "1.0.2"
+
+// SpecConfigName 是 bundle 中的配置文件名
+const SpecConfigName = "config.json"
+
+// ------------------------
+// OCI runtime-spec 配置（只包含 mydocker 支持的字段）
+// ------------------------
+
+type Spec struct {
+	Version     string            `json:"ociVersion"`
+	Process     *Process          `json:"process,omitempty"`
+	Root        *Root             `json:"root,omitempty"`
+	Hostname    string            `json:"hostname,omitempty"`
+	Mounts      []Mount           `json:"mounts,omitempty"`
+	Hooks       *Hooks            `json:"hooks,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	Linux       *Linux            `json:"linux,omitempty"`
+}
+
+// Process 描述容器中运行的用户进程
+type Process struct {
+	Terminal bool          `json:"terminal,omitempty"`
+	User     User          `json:"user"`
+	Args     []string      `json:"args"`
+	Env      []string      `json:"env,omitempty"`
+	Cwd      string        `json:"cwd"`
+	Rlimits  []POSIXRlimit `json:"rlimits,omitempty"`
+}
+
+// User 描述用户进程的身份
+type User struct {
+	UID            uint32   `json:"uid"`
+	GID            uint32   `json:"gid"`
+	AdditionalGids []uint32 `json:"additionalGids,omitempty"`
+}
+
+// POSIXRlimit 描述一项资源限制
+type POSIXRlimit struct {
+	Type string `json:"type"`
+	Hard uint64 `json:"hard"`
+	Soft uint64 `json:"soft"`
+}
+
+// Root 描述容器的根文件系统
+type Root struct {
+	Path     string `json:"path"`
+	Readonly bool   `json:"readonly,omitempty"`
+}
+
+// Mount 描述一个挂载点
+type Mount struct {
+	Destination string   `json:"destination"`
+	Type        string   `json:"type,omitempty"`
+	Source      string   `json:"source,omitempty"`
+	Options     []string `json:"options,omitempty"`
+}
+
+// Hooks 描述容器生命周期中执行的钩子
+type Hooks struct {
+	Prestart        []Hook `json:"prestart,omitempty"`
+	CreateRuntime   []Hook `json:"createRuntime,omitempty"`
+	CreateContainer []Hook `json:"createContainer,omitempty"` // 不支持，见 createBundleContainer
+	Poststart       []Hook `json:"poststart,omitempty"`
+	Poststop        []Hook `json:"poststop,omitempty"`
+	StartContainer  []Hook `json:"startContainer,omitempty"` // 不支持，见 createBundleContainer
+}
+
+// Hook 描述一个钩子程序
+type Hook struct {
+	Path    string   `json:"path"`
+	Args    []string `json:"args,omitempty"`
+	Env     []string `json:"env,omitempty"`
+	Timeout *int     `json:"timeout,omitempty"`
+}
+
+// Linux 描述 Linux 平台相关的配置
+type Linux struct {
+	Namespaces        []LinuxNamespace `json:"namespaces,omitempty"`
+	Resources         *LinuxResources  `json:"resources,omitempty"`
+	RootfsPropagation string           `json:"rootfsPropagation,omitempty"`
+}
+
+// LinuxNamespace 描述一个命名空间，Path 非空表示加入已有的命名空间
+type LinuxNamespace struct {
+	Type string `json:"type"`
+	Path string `json:"path,omitempty"`
+}
+
+// LinuxResources 描述 cgroup 资源限制
+type LinuxResources struct {
+	Memory *LinuxMemory `json:"memory,omitempty"`
+	CPU    *LinuxCPU    `json:"cpu,omitempty"`
+}
+
+// LinuxMemory 描述内存限制
+type LinuxMemory struct {
+	Limit *int64 `json:"limit,omitempty"`
+}
+
+// LinuxCPU 描述 CPU 限制
+type LinuxCPU struct {
+	Shares *uint64 `json:"shares,omitempty"`
+	Cpus   string  `json:"cpus,omitempty"`
+}
+
+// LoadSpec 读取 bundle 目录下的 config.json
+func LoadSpec(bundle string) (*Spec, error) {
+	content, err := ioutil.ReadFile(filepath.Join(bundle, SpecConfigName))
+	if err != nil {
+		return nil, err
+	}
+	spec := &Spec{}
+	if err := json.Unmarshal(content, spec); err != nil {
+		return nil, fmt.Errorf("parse %s error %v", SpecConfigName, err)
+	}
+	if spec.Process == nil || len(spec.Process.Args) == 0 {
+		return nil, fmt.Errorf("%s: process.args must not be empty", SpecConfigName)
+	}
+	if spec.Root == nil || spec.Root.Path == "" {
+		return nil, fmt.Errorf("%s: root.path must not be empty", SpecConfigName)
+	}
+	return spec, nil
+}
+
+// RootfsPath 返回根文件系统的绝对路径，root.path 为相对路径时相对于 bundle
+func (s *Spec) RootfsPath(bundle string) string {
+	if filepath.IsAbs(s.Root.Path) {
+		return s.Root.Path
+	}
+	return filepath.Join(bundle, s.Root.Path)
+}
+
+// NamespaceConfig 把 linux.namespaces 转换为 mydocker 的命名空间模式
+// 未列出的命名空间与运行时共享（即宿主机），带 path 的加入已有的命名空间，其余新建
+func (s *Spec) NamespaceConfig() (*container.NamespaceConfig, error) {
+	ns := &container.NamespaceConfig{
+		Net: container.NamespaceHost,
+		Pid: container.NamespaceHost,
+		Ipc: container.NamespaceHost,
+		Uts: container.NamespaceHost,
+	}
+	hasMount := false
+	if s.Linux != nil {
+		for _, n := range s.Linux.Namespaces {
+			mode := ""
+			if n.Path != "" {
+				mode = container.NamespacePath(n.Path)
+			}
+			switch n.Type {
+			case "network":
+				ns.Net = mode
+			case "pid":
+				ns.Pid = mode
+			case "ipc":
+				ns.Ipc = mode
+			case "uts":
+				ns.Uts = mode
+			case "mount":
+				if n.Path != "" {
+					return nil, fmt.Errorf("joining an existing mount namespace is not supported")
+				}
+				hasMount = true
+			default:
+				return nil, fmt.Errorf("namespace type %s is not supported", n.Type)
+			}
+		}
+	}
+	// init 进程依赖 pivot_root，必须拥有自己的挂载命名空间
+	if !hasMount {
+		return nil, fmt.Errorf("a mount namespace is required")
+	}
+	return ns, ns.Validate()
+}
+
+// InitSpec 把 process、root.readonly、hostname 和 mounts 转换为发送给容器 init 进程的设置
+func (s *Spec) InitSpec() *container.InitSpec {
+	p := s.Process
+	initSpec := &container.InitSpec{
+		Args:               p.Args,
+		Env:                p.Env,
+		Cwd:                p.Cwd,
+		User:               fmt.Sprintf("%d:%d", p.User.UID, p.User.GID),
+		Hostname:           s.Hostname,
+		AdditionalGids:     p.User.AdditionalGids,
+		OnlyAdditionalGids: true,
+		ReadonlyRoot:       s.Root.Readonly,
+	}
+	if s.Linux != nil {
+		initSpec.RootPropagation = s.Linux.RootfsPropagation
+	}
+	for _, r := range p.Rlimits {
+		initSpec.Rlimits = append(initSpec.Rlimits, container.Rlimit{Type: r.Type, Hard: r.Hard, Soft: r.Soft})
+	}
+	for _, m := range s.Mounts {
+		// cgroup 命名空间不受支持，cgroup 文件系统不挂载到容器中
+		if m.Type == "cgroup" || m.Type == "cgroup2" {
+			log.Warnf("Skip unsupported %s mount at %s", m.Type, m.Destination)
+			continue
+		}
+		initSpec.Mounts = append(initSpec.Mounts, container.Mount{
+			Source:      m.Source,
+			Destination: m.Destination,
+			Type:        m.Type,
+			Options:     m.Options,
+		})
+	}
+	return initSpec
+}
+
+// ResourceConfig 把 linux.resources 转换为 cgroup 资源限制
+func (s *Spec) ResourceConfig() *subsystems.ResourceConfig {
+	res := &subsystems.ResourceConfig{}
+	if s.Linux == nil || s.Linux.Resources == nil {
+		return res
+	}
+	if m := s.Linux.Resources.Memory; m != nil && m.Limit != nil {
+		res.MemoryLimit = strconv.FormatInt(*m.Limit, 10)
+	}
+	if c := s.Linux.Resources.CPU; c != nil {
+		if c.Shares != nil {
+			res.CpuShare = strconv.FormatUint(*c.Shares, 10)
+		}
+		res.CpuSet = c.Cpus
+	}
+	return res
+}
@@ -0,0 +1,78 @@
+package oci
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"os/exec"
+	"time"
+)
+
+// State 是 runtime-spec 定义的容器状态，由 state 命令输出，也通过标准输入传给钩子
+// Status 取值为 created、running 或 stopped，与 container 包中的容器状态一致
+type State struct {
+	Version     string            `json:"ociVersion"`
+	ID          string            `json:"id"`
+	Status      string            `json:"status"`
+	Pid         int               `json:"pid,omitempty"`
+	Bundle      string            `json:"bundle"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// StatusCreating 是执行 prestart 和 createRuntime 钩子时传给钩子的容器状态
+const StatusCreating = "creating"
+
+// RunHooks 依次执行钩子，容器状态以 JSON 形式写入钩子的标准输入
+// 任意一个钩子失败或超时即返回错误
+func RunHooks(kind string, hooks []Hook, state *State) error {
+	if len(hooks) == 0 {
+		return nil
+	}
+	stateJson, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	for _, h := range hooks {
+		if err := runHook(h, stateJson); err != nil {
+			return fmt.Errorf("%s hook %s error %v", kind, h.Path, err)
+		}
+		log.Infof("Run %s hook %s", kind, h.Path)
+	}
+	return nil
+}
+
+// runHook 执行单个钩子，Args 的第一个元素是 argv[0]
+func runHook(h Hook, stateJson []byte) error {
+	cmd := exec.Command(h.Path)
+	if len(h.Args) > 0 {
+		cmd.Args = h.Args
+	}
+	cmd.Env = h.Env
+	cmd.Stdin = bytes.NewReader(stateJson)
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+
+	var timeout <-chan time.Time
+	if h.Timeout != nil && *h.Timeout > 0 {
+		timeout = time.After(time.Duration(*h.Timeout) * time.Second)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			return fmt.Errorf("%v, output: %s", err, output.String())
+		}
+		return nil
+	case <-timeout:
+		cmd.Process.Kill()
+		<-done
+		return fmt.Errorf("timed out after %ds", *h.Timeout)
+	}
+}
@@ -0,0 +1,264 @@
+package registry
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ------------------------
+// Docker Registry HTTP API v2 客户端
+// ------------------------
+
+const (
+	// DefaultDomain 是引用中没有仓库地址时使用的仓库，与 docker 一致
+	DefaultDomain = "docker.io"
+	// defaultEndpoint 是 docker.io 实际的 API 地址
+	defaultEndpoint = "https://registry-1.docker.io"
+	// officialRepoPrefix 是 docker.io 上只有一级名字的官方镜像的前缀
+	officialRepoPrefix = "library/"
+)
+
+// Client 访问仓库中的一个镜像库，例如 localhost:5000 上的 library/busybox
+type Client struct {
+	Endpoint   string       // 仓库 API 地址 scheme://host[:port]，不带 /v2/
+	Repository string       // 仓库中的镜像库路径
+	Username   string       // 用户名，为空时匿名访问
+	Password   string       // 密码或访问令牌
+	HTTPClient *http.Client // 发送请求使用的 HTTP 客户端
+
+	actions string // 申请令牌时请求的权限，pull 或 pull,push
+	token   string // 仓库认证服务发放的 bearer 令牌
+	basic   bool   // 仓库要求 basic 认证
+}
+
+// SplitName 把镜像名拆分为仓库地址和镜像库路径
+// 没有仓库地址时使用 docker.io，docker.io 上只有一级名字的镜像位于 library/ 下
+func SplitName(name string) (string, string) {
+	domain, path := DefaultDomain, name
+	if i := strings.Index(name, "/"); i >= 0 {
+		first := name[:i]
+		if strings.ContainsAny(first, ".:") || first == "localhost" {
+			domain, path = first, name[i+1:]
+		}
+	}
+	if domain == "index.docker.io" {
+		domain = DefaultDomain
+	}
+	if domain == DefaultDomain && !strings.Contains(path, "/") {
+		path = officialRepoPrefix + path
+	}
+	return domain, path
+}
+
+// NewClient 为镜像名对应的镜像库创建客户端，actions 为 pull 或 pull,push
+// localhost 和回环地址上的仓库以及 insecure 为 true 时使用 HTTP，否则使用 HTTPS
+func NewClient(name string, insecure bool, username, password, actions string) *Client {
+	domain, path := SplitName(name)
+	endpoint := "https://" + domain
+	if domain == DefaultDomain {
+		endpoint = defaultEndpoint
+	} else if insecure || isLocalhost(domain) {
+		endpoint = "http://" + domain
+	}
+	return &Client{
+		Endpoint:   endpoint,
+		Repository: path,
+		Username:   username,
+		Password:   password,
+		HTTPClient: &http.Client{Timeout: 30 * time.Minute},
+		actions:    actions,
+	}
+}
+
+// isLocalhost 判断仓库地址是否为本机
+func isLocalhost(domain string) bool {
+	host := domain
+	if h, _, err := net.SplitHostPort(domain); err == nil {
+		host = h
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// url 返回镜像库下的 API 地址，例如 manifests/latest
+func (c *Client) url(suffix string) string {
+	return fmt.Sprintf("%s/v2/%s/%s", strings.TrimSuffix(c.Endpoint, "/"), c.Repository, suffix)
+}
+
+// do 发送请求，返回 401 时按 WWW-Authenticate 的要求获取令牌或使用 basic 认证后重试一次
+// 请求体以字节数组传入，以便重试时重新发送
+func (c *Client) do(method, rawURL string, header http.Header, body []byte) (*http.Response, error) {
+	resp, err := c.send(method, rawURL, header, body)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	challenge := resp.Header.Get("WWW-Authenticate")
+	resp.Body.Close()
+	if err := c.authenticate(challenge); err != nil {
+		return nil, err
+	}
+	return c.send(method, rawURL, header, body)
+}
+
+// send 发送一次请求，附带已有的认证信息
+func (c *Client) send(method, rawURL string, header http.Header, body []byte) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequest(method, rawURL, reader)
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	switch {
+	case c.token != "":
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	case c.basic:
+		req.SetBasicAuth(c.Username, c.Password)
+	}
+	return c.HTTPClient.Do(req)
+}
+
+// authenticate 处理仓库的认证要求：Basic 直接使用用户名密码，Bearer 向认证服务申请令牌
+func (c *Client) authenticate(challenge string) error {
+	scheme, params := parseChallenge(challenge)
+	switch strings.ToLower(scheme) {
+	case "basic":
+		if c.Username == "" || c.basic {
+			return fmt.Errorf("unauthorized: authentication required")
+		}
+		c.basic = true
+		return nil
+	case "bearer":
+		if c.token != "" {
+			return fmt.Errorf("unauthorized: access to %s denied", c.Repository)
+		}
+		return c.fetchToken(params["realm"], params["service"])
+	default:
+		return fmt.Errorf("unauthorized: unsupported authentication challenge %q", challenge)
+	}
+}
+
+// fetchToken 向认证服务申请镜像库的访问令牌，有用户名时使用 basic 认证，否则申请匿名令牌
+func (c *Client) fetchToken(realm, service string) error {
+	if realm == "" {
+		return fmt.Errorf("bearer challenge has no realm")
+	}
+	u, err := url.Parse(realm)
+	if err != nil {
+		return fmt.Errorf("invalid token realm %q: %v", realm, err)
+	}
+	query := u.Query()
+	if service != "" {
+		query.Set("service", service)
+	}
+	query.Set("scope", fmt.Sprintf("repository:%s:%s", c.Repository, c.actions))
+	u.RawQuery = query.Encode()
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return err
+	}
+	if c.Username != "" {
+		req.SetBasicAuth(c.Username, c.Password)
+	}
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("fetch token error %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("fetch token from %s: %s", realm, resp.Status)
+	}
+	// 认证服务可能返回 token 或 OAuth2 风格的 access_token
+	tokenResp := struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&tokenResp); err != nil {
+		return fmt.Errorf("decode token response error %v", err)
+	}
+	c.token = tokenResp.Token
+	if c.token == "" {
+		c.token = tokenResp.AccessToken
+	}
+	if c.token == "" {
+		return fmt.Errorf("token response from %s has no token", realm)
+	}
+	return nil
+}
+
+// parseChallenge 解析 WWW-Authenticate，例如 Bearer realm="https://auth",service="registry",scope="repository:a:pull,push"
+func parseChallenge(challenge string) (string, map[string]string) {
+	params := map[string]string{}
+	challenge = strings.TrimSpace(challenge)
+	i := strings.IndexAny(challenge, " \t")
+	if i < 0 {
+		return challenge, params
+	}
+	scheme, rest := challenge[:i], challenge[i+1:]
+	for {
+		rest = strings.TrimLeft(rest, " \t,")
+		eq := strings.Index(rest, "=")
+		if eq <= 0 {
+			break
+		}
+		key := strings.ToLower(strings.TrimSpace(rest[:eq]))
+		rest = rest[eq+1:]
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			// 带引号的值中可能有逗号，例如 scope 中的 pull,push
+			end := strings.Index(rest[1:], `"`)
+			if end < 0 {
+				value, rest = rest[1:], ""
+			} else {
+				value, rest = rest[1:end+1], rest[end+2:]
+			}
+		} else if comma := strings.Index(rest, ","); comma >= 0 {
+			value, rest = rest[:comma], rest[comma:]
+		} else {
+			value, rest = rest, ""
+		}
+		params[key] = value
+	}
+	return scheme, params
+}
+
+// checkResponse 检查响应状态码，不符合预期时关闭响应并返回仓库给出的错误信息
+func checkResponse(resp *http.Response, method, rawURL string, expected ...int) error {
+	for _, code := range expected {
+		if resp.StatusCode == code {
+			return nil
+		}
+	}
+	defer resp.Body.Close()
+	// 仓库的错误响应为 {"errors":[{"code":"...","message":"..."}]}
+	errResp := struct {
+		Errors []struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"errors"`
+	}{}
+	content, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if json.Unmarshal(content, &errResp) == nil && len(errResp.Errors) > 0 {
+		var messages []string
+		for _, e := range errResp.Errors {
+			messages = append(messages, fmt.Sprintf("%s: %s", e.Code, e.Message))
+		}
+		return fmt.Errorf("%s %s: %s", method, rawURL, strings.Join(messages, "; "))
+	}
+	return fmt.Errorf("%s %s: %s", method, rawURL, resp.Status)
+}
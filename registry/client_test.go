@@ -0,0 +1,96 @@
+package registry
+
+import (
+	"go-docker/image"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestParseChallenge(t *testing.T) {
+	tests := []struct {
+		challenge string
+		scheme    string
+		params    map[string]string
+	}{
+		{
+			challenge: `Bearer realm="https://auth.docker.io/token",service="registry.docker.io",scope="repository:library/busybox:pull,push"`,
+			scheme:    "Bearer",
+			params: map[string]string{
+				"realm":   "https://auth.docker.io/token",
+				"service": "registry.docker.io",
+				"scope":   "repository:library/busybox:pull,push",
+			},
+		},
+		{
+			challenge: `Basic realm="Registry Realm"`,
+			scheme:    "Basic",
+			params:    map[string]string{"realm": "Registry Realm"},
+		},
+		{
+			challenge: `Bearer realm=https://auth/token, service=registry`,
+			scheme:    "Bearer",
+			params:    map[string]string{"realm": "https://auth/token", "service": "registry"},
+		},
+		{
+			challenge: "Basic",
+			scheme:    "Basic",
+			params:    map[string]string{},
+		},
+	}
+	for _, tt := range tests {
+		scheme, params := parseChallenge(tt.challenge)
+		if scheme != tt.scheme || !reflect.DeepEqual(params, tt.params) {
+			t.Errorf("parseChallenge(%q) = %q, %v, want %q, %v", tt.challenge, scheme, params, tt.scheme, tt.params)
+		}
+	}
+}
+
+// 收到 bearer 质询后带着用户名密码向 realm 申请令牌，重试原请求，之后的请求直接使用令牌
+func TestBearerChallenge(t *testing.T) {
+	reg := newFakeRegistry(t, "library/test")
+	reg.username, reg.password = "alice", "secret"
+	reg.addManifest("latest", image.MediaTypeManifest, image.Manifest{SchemaVersion: 2, MediaType: image.MediaTypeManifest})
+
+	c := reg.client("alice", "secret", "pull")
+	if _, _, err := c.getManifest("latest", ""); err != nil {
+		t.Fatalf("getManifest: %v", err)
+	}
+	if c.token != fakeToken {
+		t.Errorf("token = %q, want %q", c.token, fakeToken)
+	}
+	if _, _, err := c.getManifest("latest", ""); err != nil {
+		t.Fatalf("getManifest with token: %v", err)
+	}
+	want := []string{"repository:library/test:pull"}
+	if !reflect.DeepEqual(reg.scopes, want) {
+		t.Errorf("token requests scopes = %v, want %v", reg.scopes, want)
+	}
+}
+
+func TestBearerChallengeBadCredentials(t *testing.T) {
+	reg := newFakeRegistry(t, "library/test")
+	reg.username, reg.password = "alice", "secret"
+
+	c := reg.client("alice", "wrong", "pull")
+	resp, err := c.do(http.MethodGet, c.url("manifests/latest"), nil, nil)
+	if err == nil {
+		resp.Body.Close()
+		t.Fatal("expected token request with wrong password to fail")
+	}
+}
+
+// 令牌被拒绝时不再重复申请，返回错误而不是无限重试
+func TestBearerTokenRejected(t *testing.T) {
+	reg := newFakeRegistry(t, "library/test")
+	c := reg.client("", "", "pull")
+	c.token = "stale-token"
+	resp, err := c.do(http.MethodGet, c.url("manifests/latest"), nil, nil)
+	if err == nil {
+		resp.Body.Close()
+		t.Fatal("expected rejected token to return an error")
+	}
+	if len(reg.scopes) != 0 {
+		t.Errorf("unexpected token requests %v", reg.scopes)
+	}
+}
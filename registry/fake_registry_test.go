@@ -0,0 +1,210 @@
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"go-docker/image"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeRegistry 是测试用的进程内仓库，实现 pull 和 push 用到的 API，并要求 bearer 认证
+//
+//	GET  /token                        认证服务，检查 basic 认证和 scope 后发放令牌
+//	GET/HEAD /v2/<repo>/manifests/<ref>
+//	PUT  /v2/<repo>/manifests/<tag>
+//	GET/HEAD /v2/<repo>/blobs/<digest>
+//	POST /v2/<repo>/blobs/uploads/      返回相对地址 /upload/<id>?offset=0
+//	PATCH /upload/<id>                  检查 Content-Range 与已收到的数据连续，返回新的 Location
+//	PUT  /upload/<id>?digest=           校验摘要后保存 blob
+type fakeRegistry struct {
+	t      *testing.T
+	server *httptest.Server
+	repo   string
+
+	username, password string // 认证服务要求的用户名密码，为空时发放匿名令牌
+
+	mu        sync.Mutex
+	manifests map[string]fakeManifest // 标签或摘要 -> manifest
+	blobs     map[string][]byte
+	uploads   map[string][]byte
+	scopes    []string // 认证服务收到的 scope
+	patches   int      // 收到的 PATCH 请求数
+}
+
+type fakeManifest struct {
+	mediaType string
+	content   []byte
+}
+
+const fakeToken = "test-token"
+
+func newFakeRegistry(t *testing.T, repo string) *fakeRegistry {
+	r := &fakeRegistry{
+		t:         t,
+		repo:      repo,
+		manifests: map[string]fakeManifest{},
+		blobs:     map[string][]byte{},
+		uploads:   map[string][]byte{},
+	}
+	r.server = httptest.NewServer(http.HandlerFunc(r.serve))
+	t.Cleanup(r.server.Close)
+	return r
+}
+
+// client 返回访问测试仓库中镜像库的客户端
+func (r *fakeRegistry) client(username, password, actions string) *Client {
+	host := strings.TrimPrefix(r.server.URL, "http://")
+	return NewClient(host+"/"+r.repo, false, username, password, actions)
+}
+
+// addManifest 以标签和摘要两种引用保存 manifest，返回其摘要
+func (r *fakeRegistry) addManifest(tag, mediaType string, v interface{}) string {
+	content, err := json.Marshal(v)
+	if err != nil {
+		r.t.Fatal(err)
+	}
+	digest := image.DigestOf(content)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if tag != "" {
+		r.manifests[tag] = fakeManifest{mediaType, content}
+	}
+	r.manifests[digest] = fakeManifest{mediaType, content}
+	return digest
+}
+
+func (r *fakeRegistry) addBlob(content []byte) string {
+	digest := image.DigestOf(content)
+	r.mu.Lock()
+	r.blobs[digest] = content
+	r.mu.Unlock()
+	return digest
+}
+
+func (r *fakeRegistry) serve(w http.ResponseWriter, req *http.Request) {
+	if req.URL.Path == "/token" {
+		r.serveToken(w, req)
+		return
+	}
+	if req.Header.Get("Authorization") != "Bearer "+fakeToken {
+		w.Header().Set("WWW-Authenticate",
+			fmt.Sprintf(`Bearer realm="%s/token",service="fake-registry",scope="repository:%s:pull,push"`, r.server.URL, r.repo))
+		http.Error(w, `{"errors":[{"code":"UNAUTHORIZED","message":"authentication required"}]}`, http.StatusUnauthorized)
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	prefix := "/v2/" + r.repo + "/"
+	switch {
+	case strings.HasPrefix(req.URL.Path, "/upload/"):
+		r.serveUpload(w, req)
+	case strings.HasPrefix(req.URL.Path, prefix+"manifests/"):
+		r.serveManifest(w, req, strings.TrimPrefix(req.URL.Path, prefix+"manifests/"))
+	case req.URL.Path == prefix+"blobs/uploads/" && req.Method == http.MethodPost:
+		id := strconv.Itoa(len(r.uploads) + 1)
+		r.uploads[id] = []byte{}
+		w.Header().Set("Location", "/upload/"+id+"?offset=0")
+		w.WriteHeader(http.StatusAccepted)
+	case strings.HasPrefix(req.URL.Path, prefix+"blobs/"):
+		content, ok := r.blobs[strings.TrimPrefix(req.URL.Path, prefix+"blobs/")]
+		if !ok {
+			http.NotFound(w, req)
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+		if req.Method == http.MethodGet {
+			w.Write(content)
+		}
+	default:
+		http.NotFound(w, req)
+	}
+}
+
+// serveToken 检查 basic 认证后发放令牌，记录请求的 scope
+func (r *fakeRegistry) serveToken(w http.ResponseWriter, req *http.Request) {
+	if r.username != "" {
+		if u, p, ok := req.BasicAuth(); !ok || u != r.username || p != r.password {
+			http.Error(w, "bad credentials", http.StatusUnauthorized)
+			return
+		}
+	}
+	if req.URL.Query().Get("service") != "fake-registry" {
+		http.Error(w, "bad service", http.StatusBadRequest)
+		return
+	}
+	r.mu.Lock()
+	r.scopes = append(r.scopes, req.URL.Query().Get("scope"))
+	r.mu.Unlock()
+	json.NewEncoder(w).Encode(map[string]string{"access_token": fakeToken})
+}
+
+func (r *fakeRegistry) serveManifest(w http.ResponseWriter, req *http.Request, reference string) {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead:
+		m, ok := r.manifests[reference]
+		if !ok {
+			http.NotFound(w, req)
+			return
+		}
+		w.Header().Set("Content-Type", m.mediaType)
+		w.Header().Set("Docker-Content-Digest", image.DigestOf(m.content))
+		if req.Method == http.MethodGet {
+			w.Write(m.content)
+		}
+	case http.MethodPut:
+		content, _ := ioutil.ReadAll(req.Body)
+		m := fakeManifest{req.Header.Get("Content-Type"), content}
+		r.manifests[reference] = m
+		r.manifests[image.DigestOf(content)] = m
+		w.Header().Set("Docker-Content-Digest", image.DigestOf(content))
+		w.WriteHeader(http.StatusCreated)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// serveUpload 处理分块上传，offset 查询参数必须与已收到的数据长度一致，以确认客户端使用了上一次响应的 Location
+func (r *fakeRegistry) serveUpload(w http.ResponseWriter, req *http.Request) {
+	id := strings.TrimPrefix(req.URL.Path, "/upload/")
+	data, ok := r.uploads[id]
+	if !ok {
+		http.NotFound(w, req)
+		return
+	}
+	if offset := req.URL.Query().Get("offset"); offset != strconv.Itoa(len(data)) {
+		http.Error(w, fmt.Sprintf("stale upload location, offset %s, received %d", offset, len(data)), http.StatusRequestedRangeNotSatisfiable)
+		return
+	}
+	body, _ := ioutil.ReadAll(req.Body)
+	switch req.Method {
+	case http.MethodPatch:
+		r.patches++
+		expected := fmt.Sprintf("%d-%d", len(data), len(data)+len(body)-1)
+		if got := req.Header.Get("Content-Range"); got != expected {
+			http.Error(w, fmt.Sprintf("Content-Range %s, expected %s", got, expected), http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+		r.uploads[id] = append(data, body...)
+		w.Header().Set("Location", fmt.Sprintf("/upload/%s?offset=%d", id, len(r.uploads[id])))
+		w.WriteHeader(http.StatusAccepted)
+	case http.MethodPut:
+		data = append(data, body...)
+		digest := req.URL.Query().Get("digest")
+		if image.DigestOf(data) != digest {
+			http.Error(w, `{"errors":[{"code":"DIGEST_INVALID","message":"digest mismatch"}]}`, http.StatusBadRequest)
+			return
+		}
+		r.blobs[digest] = data
+		delete(r.uploads, id)
+		w.WriteHeader(http.StatusCreated)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
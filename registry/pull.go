@@ -0,0 +1,331 @@
+package registry
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"go-docker/image"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"runtime"
+	"strings"
+)
+
+// maxManifestSize 限制 manifest 和镜像配置的大小，防止仓库返回异常数据占满内存
+const maxManifestSize = 8 << 20
+
+// manifestAccept 是拉取 manifest 时接受的格式
+var manifestAccept = []string{
+	image.MediaTypeManifest,
+	image.MediaTypeManifestList,
+	image.MediaTypeOCIManifest,
+	image.MediaTypeOCIIndex,
+}
+
+// Pull 从仓库拉取镜像，校验 manifest、配置和每一层的摘要后存入镜像存储，返回镜像 ID
+// 本地已有的层不再下载；拉取完成后为镜像打上 name:tag 标签并记录 name@digest
+// 仓库中有镜像的签名时一并导入；verify 不为空时在保存镜像配置之前检查签名，不通过时不导入镜像
+func Pull(name string, insecure bool, username, password string, verify image.Verifier) (string, error) {
+	ref, err := image.ParseReference(name)
+	if err != nil {
+		return "", err
+	}
+	c := NewClient(ref.Name, insecure, username, password, "pull")
+	reference := ref.Tag
+	if ref.Digest != "" {
+		reference = ref.Digest
+	}
+	if ref.Tag != "" {
+		fmt.Printf("%s: Pulling from %s\n", ref.Tag, c.Repository)
+	} else {
+		fmt.Printf("%s: Pulling from %s\n", ref.Digest, c.Repository)
+	}
+
+	manifest, manifestDigest, err := c.getManifest(reference, ref.Digest)
+	if err != nil {
+		return "", err
+	}
+	// 下载的层在镜像配置写入之前没有引用，需要防止被垃圾回收
+	release, err := image.Lease()
+	if err != nil {
+		return "", err
+	}
+	defer release()
+
+	// 镜像配置
+	if !image.IsDigestFormat(manifest.Config.Digest) {
+		return "", fmt.Errorf("unsupported config digest %q", manifest.Config.Digest)
+	}
+	config, err := c.getBlob(manifest.Config.Digest)
+	if err != nil {
+		return "", fmt.Errorf("get image config error %v", err)
+	}
+	img := &image.Image{}
+	if err := json.Unmarshal(config, img); err != nil {
+		return "", fmt.Errorf("parse image config error %v", err)
+	}
+	if len(img.RootFS.DiffIDs) != len(manifest.Layers) {
+		return "", fmt.Errorf("image config has %d layers, but manifest has %d", len(img.RootFS.DiffIDs), len(manifest.Layers))
+	}
+	_, err = image.GetImage(manifest.Config.Digest)
+	upToDate := err == nil
+
+	// 按从下到上的顺序下载各层
+	for i, layer := range manifest.Layers {
+		diffID := img.RootFS.DiffIDs[i]
+		short := image.ShortID(layer.Digest)
+		if image.HasLayer(diffID) {
+			fmt.Printf("%s: Already exists\n", short)
+		} else {
+			upToDate = false
+			if err := c.pullLayer(layer, diffID); err != nil {
+				return "", fmt.Errorf("pull layer %s error %v", layer.Digest, err)
+			}
+			fmt.Printf("%s: Pull complete\n", short)
+		}
+		if err := image.SetDistributionDigest(diffID, layer.Digest, layer.MediaType); err != nil {
+			return "", err
+		}
+	}
+
+	// 签名针对镜像的 OCI manifest，各层下载完成后才能计算其摘要
+	ociManifest, err := image.ManifestOf(config)
+	if err != nil {
+		return "", err
+	}
+	ociDigest := image.DigestOf(ociManifest)
+	signatures, err := c.pullSignatures(ociDigest)
+	if err != nil {
+		return "", fmt.Errorf("pull signatures error %v", err)
+	}
+	if err := image.ImportSignatures(ociDigest, signatures, []string{ref.Name}, verify); err != nil {
+		return "", err
+	}
+
+	// 按原样保存配置，镜像 ID 与仓库中的配置摘要一致
+	id, err := image.StoreConfig(config)
+	if err != nil {
+		return "", err
+	}
+	if ref.Tag != "" {
+		if err := image.Tag(id, image.Reference{Name: ref.Name, Tag: ref.Tag}); err != nil {
+			return "", err
+		}
+	}
+	if err := image.AddDigest(id, ref.Name, manifestDigest); err != nil {
+		return "", err
+	}
+	fmt.Printf("Digest: %s\n", manifestDigest)
+	if upToDate {
+		fmt.Printf("Status: Image is up to date for %s\n", ref)
+	} else {
+		fmt.Printf("Status: Downloaded newer image for %s\n", ref)
+	}
+	return id, nil
+}
+
+// getManifest 获取镜像的 manifest 并校验摘要，manifest list 或 index 按本机平台选择其中的镜像
+// expected 为引用中的摘要，为空时使用仓库返回的 Docker-Content-Digest 校验
+func (c *Client) getManifest(reference, expected string) (*image.Manifest, string, error) {
+	content, mediaType, digest, err := c.fetchManifest(reference, expected)
+	if err != nil {
+		return nil, "", err
+	}
+	if mediaType == image.MediaTypeManifestList || mediaType == image.MediaTypeOCIIndex {
+		index := &image.Index{}
+		if err := json.Unmarshal(content, index); err != nil {
+			return nil, "", fmt.Errorf("parse manifest list error %v", err)
+		}
+		platform, err := selectPlatform(index)
+		if err != nil {
+			return nil, "", err
+		}
+		if content, mediaType, _, err = c.fetchManifest(platform.Digest, platform.Digest); err != nil {
+			return nil, "", err
+		}
+		// 记录的是 manifest list 的摘要，与 docker 一致，之后用 name@digest 拉取得到同样的结果
+	}
+	if mediaType != image.MediaTypeManifest && mediaType != image.MediaTypeOCIManifest {
+		return nil, "", fmt.Errorf("unsupported manifest media type %q", mediaType)
+	}
+	manifest := &image.Manifest{}
+	if err := json.Unmarshal(content, manifest); err != nil {
+		return nil, "", fmt.Errorf("parse manifest error %v", err)
+	}
+	if manifest.SchemaVersion != 2 {
+		return nil, "", fmt.Errorf("unsupported manifest schema version %d", manifest.SchemaVersion)
+	}
+	return manifest, digest, nil
+}
+
+// fetchManifest 获取 manifest 的原始内容、媒体类型和摘要
+func (c *Client) fetchManifest(reference, expected string) ([]byte, string, string, error) {
+	rawURL := c.url("manifests/" + reference)
+	header := http.Header{"Accept": manifestAccept}
+	resp, err := c.do(http.MethodGet, rawURL, header, nil)
+	if err != nil {
+		return nil, "", "", err
+	}
+	if err := checkResponse(resp, http.MethodGet, rawURL, http.StatusOK); err != nil {
+		return nil, "", "", err
+	}
+	defer resp.Body.Close()
+	content, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxManifestSize+1))
+	if err != nil {
+		return nil, "", "", err
+	}
+	if len(content) > maxManifestSize {
+		return nil, "", "", fmt.Errorf("manifest %s is too large", reference)
+	}
+	digest := image.DigestOf(content)
+	if expected == "" {
+		expected = resp.Header.Get("Docker-Content-Digest")
+	}
+	if expected != "" && expected != digest {
+		return nil, "", "", fmt.Errorf("manifest digest mismatch: expected %s, got %s", expected, digest)
+	}
+
+	// 优先使用 manifest 中的 mediaType，没有时（OCI 允许省略）使用响应的 Content-Type
+	mediaType := strings.TrimSpace(strings.Split(resp.Header.Get("Content-Type"), ";")[0])
+	probe := struct {
+		MediaType string          `json:"mediaType"`
+		Manifests json.RawMessage `json:"manifests"`
+	}{}
+	if err := json.Unmarshal(content, &probe); err != nil {
+		return nil, "", "", fmt.Errorf("parse manifest error %v", err)
+	}
+	switch {
+	case probe.MediaType != "":
+		mediaType = probe.MediaType
+	case probe.Manifests != nil:
+		mediaType = image.MediaTypeOCIIndex
+	}
+	if mediaType == image.MediaTypeSchema1Manifest || mediaType == image.MediaTypeSchema1Signed {
+		return nil, "", "", fmt.Errorf("schema1 manifests are not supported")
+	}
+	return content, mediaType, digest, nil
+}
+
+// pullSignatures 下载镜像的签名，仓库中没有签名时返回空列表
+func (c *Client) pullSignatures(manifestDigest string) ([]image.Signature, error) {
+	rawURL := c.url("manifests/" + signatureTag(manifestDigest))
+	resp, err := c.do(http.MethodHead, rawURL, http.Header{"Accept": {image.MediaTypeOCIManifest}}, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	content, mediaType, _, err := c.fetchManifest(signatureTag(manifestDigest), "")
+	if err != nil {
+		return nil, err
+	}
+	manifest := &image.Manifest{}
+	if err := json.Unmarshal(content, manifest); err != nil || mediaType != image.MediaTypeOCIManifest ||
+		manifest.Config.MediaType != image.MediaTypeSignatures || !image.IsDigestFormat(manifest.Config.Digest) {
+		return nil, fmt.Errorf("%s is not a signature manifest", signatureTag(manifestDigest))
+	}
+	config, err := c.getBlob(manifest.Config.Digest)
+	if err != nil {
+		return nil, err
+	}
+	var signatures []image.Signature
+	if err := json.Unmarshal(config, &signatures); err != nil {
+		return nil, fmt.Errorf("parse signatures error %v", err)
+	}
+	return signatures, nil
+}
+
+// selectPlatform 从 manifest list 或 index 中选择 linux/<本机架构> 的镜像
+func selectPlatform(index *image.Index) (*image.Descriptor, error) {
+	for i, m := range index.Manifests {
+		if m.Platform != nil && m.Platform.OS == "linux" && m.Platform.Architecture == runtime.GOARCH {
+			return &index.Manifests[i], nil
+		}
+	}
+	return nil, fmt.Errorf("no matching manifest for linux/%s in the manifest list entries", runtime.GOARCH)
+}
+
+// getBlob 获取小的 blob（镜像配置）并校验摘要
+func (c *Client) getBlob(digest string) ([]byte, error) {
+	resp, err := c.openBlob(digest)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	content, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxManifestSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(content) > maxManifestSize {
+		return nil, fmt.Errorf("blob %s is too large", digest)
+	}
+	if got := image.DigestOf(content); got != digest {
+		return nil, fmt.Errorf("blob digest mismatch: expected %s, got %s", digest, got)
+	}
+	return content, nil
+}
+
+// openBlob 请求 blob，仓库可能重定向到对象存储，由 HTTP 客户端跟随
+func (c *Client) openBlob(digest string) (*http.Response, error) {
+	rawURL := c.url("blobs/" + digest)
+	resp, err := c.do(http.MethodGet, rawURL, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkResponse(resp, http.MethodGet, rawURL, http.StatusOK); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// pullLayer 下载一层到临时文件，校验压缩后的摘要，再解压存入镜像存储并校验 diff ID
+func (c *Client) pullLayer(layer image.Descriptor, diffID string) error {
+	switch layer.MediaType {
+	case image.MediaTypeLayer, image.MediaTypeOCILayer, image.MediaTypeOCILayerGzip, image.MediaTypeOCILayerZstd:
+	default:
+		return fmt.Errorf("unsupported layer media type %q", layer.MediaType)
+	}
+	if !image.IsDigestFormat(layer.Digest) {
+		return fmt.Errorf("unsupported layer digest %q", layer.Digest)
+	}
+	resp, err := c.openBlob(layer.Digest)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	tmp, err := ioutil.TempFile("", "mydocker-layer-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	hash := sha256.New()
+	n, err := io.Copy(io.MultiWriter(tmp, hash), resp.Body)
+	if err != nil {
+		return fmt.Errorf("download error %v", err)
+	}
+	if layer.Size > 0 && n != layer.Size {
+		return fmt.Errorf("size mismatch: expected %d, got %d", layer.Size, n)
+	}
+	if got := "sha256:" + hex.EncodeToString(hash.Sum(nil)); got != layer.Digest {
+		return fmt.Errorf("digest mismatch: expected %s, got %s", layer.Digest, got)
+	}
+
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	got, err := image.CreateLayer(tmp)
+	if err != nil {
+		return err
+	}
+	if got != diffID {
+		return fmt.Errorf("diff ID mismatch: expected %s, got %s", diffID, got)
+	}
+	return nil
+}
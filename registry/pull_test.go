@@ -0,0 +1,95 @@
+package registry
+
+import (
+	"go-docker/image"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// otherArch 返回与本机不同的架构，用于构造不应被选中的 manifest list 项
+func otherArch() string {
+	if runtime.GOARCH == "arm64" {
+		return "amd64"
+	}
+	return "arm64"
+}
+
+func testManifest(config string) image.Manifest {
+	return image.Manifest{
+		SchemaVersion: 2,
+		MediaType:     image.MediaTypeManifest,
+		Config:        image.Descriptor{MediaType: image.MediaTypeConfig, Digest: image.DigestOf([]byte(config)), Size: int64(len(config))},
+	}
+}
+
+func TestGetManifestSelectsPlatform(t *testing.T) {
+	for _, listType := range []string{image.MediaTypeManifestList, image.MediaTypeOCIIndex} {
+		t.Run(listType, func(t *testing.T) {
+			reg := newFakeRegistry(t, "library/test")
+			other := reg.addManifest("", image.MediaTypeManifest, testManifest(`{"architecture":"other"}`))
+			native := reg.addManifest("", image.MediaTypeManifest, testManifest(`{"architecture":"native"}`))
+			list := reg.addManifest("latest", listType, image.Index{
+				SchemaVersion: 2,
+				MediaType:     listType,
+				Manifests: []image.Descriptor{
+					{MediaType: image.MediaTypeManifest, Digest: other, Platform: &image.Platform{OS: "linux", Architecture: otherArch()}},
+					{MediaType: image.MediaTypeManifest, Digest: other, Platform: &image.Platform{OS: "windows", Architecture: runtime.GOARCH}},
+					{MediaType: image.MediaTypeManifest, Digest: native, Platform: &image.Platform{OS: "linux", Architecture: runtime.GOARCH}},
+				},
+			})
+
+			manifest, digest, err := reg.client("", "", "pull").getManifest("latest", "")
+			if err != nil {
+				t.Fatalf("getManifest: %v", err)
+			}
+			if want := image.DigestOf([]byte(`{"architecture":"native"}`)); manifest.Config.Digest != want {
+				t.Errorf("selected manifest config %s, want %s", manifest.Config.Digest, want)
+			}
+			// 记录 manifest list 的摘要
+			if digest != list {
+				t.Errorf("digest = %s, want manifest list digest %s", digest, list)
+			}
+		})
+	}
+}
+
+func TestGetManifestNoMatchingPlatform(t *testing.T) {
+	reg := newFakeRegistry(t, "library/test")
+	other := reg.addManifest("", image.MediaTypeManifest, testManifest(`{}`))
+	reg.addManifest("latest", image.MediaTypeManifestList, image.Index{
+		SchemaVersion: 2,
+		MediaType:     image.MediaTypeManifestList,
+		Manifests: []image.Descriptor{
+			{MediaType: image.MediaTypeManifest, Digest: other, Platform: &image.Platform{OS: "linux", Architecture: otherArch()}},
+		},
+	})
+	_, _, err := reg.client("", "", "pull").getManifest("latest", "")
+	if err == nil || !strings.Contains(err.Error(), "no matching manifest") {
+		t.Fatalf("getManifest error = %v, want no matching manifest", err)
+	}
+}
+
+// 没有 mediaType 字段的 OCI index 按 manifests 字段识别
+func TestGetManifestIndexWithoutMediaType(t *testing.T) {
+	reg := newFakeRegistry(t, "library/test")
+	native := reg.addManifest("", image.MediaTypeOCIManifest, image.Manifest{SchemaVersion: 2})
+	reg.addManifest("latest", "application/json", image.Index{
+		SchemaVersion: 2,
+		Manifests: []image.Descriptor{
+			{MediaType: image.MediaTypeOCIManifest, Digest: native, Platform: &image.Platform{OS: "linux", Architecture: runtime.GOARCH}},
+		},
+	})
+	if _, _, err := reg.client("", "", "pull").getManifest("latest", ""); err != nil {
+		t.Fatalf("getManifest: %v", err)
+	}
+}
+
+func TestGetManifestDigestMismatch(t *testing.T) {
+	reg := newFakeRegistry(t, "library/test")
+	reg.addManifest("latest", image.MediaTypeManifest, testManifest(`{}`))
+	_, _, err := reg.client("", "", "pull").getManifest("latest", image.DigestOf([]byte("other")))
+	if err == nil || !strings.Contains(err.Error(), "digest mismatch") {
+		t.Fatalf("getManifest error = %v, want digest mismatch", err)
+	}
+}
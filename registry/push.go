@@ -0,0 +1,334 @@
+package registry
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"go-docker/image"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// chunkSize 是分块上传时每个 PATCH 请求的大小
+const chunkSize = 5 << 20
+
+// Push 把本地镜像推送到仓库：上传仓库中还没有的层和镜像配置，再上传 schema2 manifest（有 OCI 媒体类型的层时为 OCI manifest），
+// 返回 manifest 的摘要
+// 镜像有签名时再把签名上传为 sha256-<hex>.sig 标签，hex 为镜像 OCI manifest 的摘要（与仓库中的 manifest 摘要不同）
+func Push(name string, insecure bool, username, password string) (string, error) {
+	ref, err := image.ParseReference(name)
+	if err != nil {
+		return "", err
+	}
+	if ref.Digest != "" {
+		return "", fmt.Errorf("cannot push a digest reference %s", ref)
+	}
+	id, img, err := image.Resolve(ref.String())
+	if err != nil {
+		return "", err
+	}
+	c := NewClient(ref.Name, insecure, username, password, "pull,push")
+	fmt.Printf("The push refers to repository [%s]\n", ref.Name)
+
+	manifest := &image.Manifest{
+		SchemaVersion: 2,
+		MediaType:     image.MediaTypeManifest,
+	}
+	for _, diffID := range img.RootFS.DiffIDs {
+		layer, err := c.pushLayer(diffID)
+		if err != nil {
+			return "", fmt.Errorf("push layer %s error %v", diffID, err)
+		}
+		manifest.Layers = append(manifest.Layers, *layer)
+	}
+	// 复用的层是 OCI 媒体类型（例如 zstd 压缩）时 docker schema2 manifest 无法描述，改为上传 OCI manifest
+	if hasOCILayer(manifest.Layers) {
+		manifest.MediaType = image.MediaTypeOCIManifest
+		for i := range manifest.Layers {
+			if manifest.Layers[i].MediaType == image.MediaTypeLayer {
+				manifest.Layers[i].MediaType = image.MediaTypeOCILayerGzip
+			}
+		}
+	}
+
+	// 镜像配置按原样上传，其摘要即镜像 ID
+	config, err := image.ConfigBytes(id)
+	if err != nil {
+		return "", err
+	}
+	configMediaType := image.MediaTypeConfig
+	if manifest.MediaType == image.MediaTypeOCIManifest {
+		configMediaType = image.MediaTypeOCIConfig
+	}
+	manifest.Config = image.Descriptor{
+		MediaType: configMediaType,
+		Digest:    id,
+		Size:      int64(len(config)),
+	}
+	if _, exists, err := c.blobExists(id); err != nil {
+		return "", err
+	} else if !exists {
+		if err := c.uploadBlob(bytes.NewReader(config), id); err != nil {
+			return "", fmt.Errorf("push image config error %v", err)
+		}
+	}
+
+	content, err := json.MarshalIndent(manifest, "", "   ")
+	if err != nil {
+		return "", err
+	}
+	digest, err := c.putManifest(ref.Tag, manifest.MediaType, content)
+	if err != nil {
+		return "", err
+	}
+	if err := image.AddDigest(id, ref.Name, digest); err != nil {
+		return "", err
+	}
+	fmt.Printf("%s: digest: %s size: %d\n", ref.Tag, digest, len(content))
+	if err := c.pushSignatures(id); err != nil {
+		return "", fmt.Errorf("push signatures error %v", err)
+	}
+	return digest, nil
+}
+
+// pushSignatures 把镜像的签名上传为 OCI artifact，没有签名时跳过
+func (c *Client) pushSignatures(id string) error {
+	manifestDigest, err := image.ManifestDigest(id)
+	if err != nil {
+		return err
+	}
+	signatures, err := image.Signatures(manifestDigest)
+	if err != nil || len(signatures) == 0 {
+		return err
+	}
+	config, err := json.Marshal(signatures)
+	if err != nil {
+		return err
+	}
+	configDigest := image.DigestOf(config)
+	if _, exists, err := c.blobExists(configDigest); err != nil {
+		return err
+	} else if !exists {
+		if err := c.uploadBlob(bytes.NewReader(config), configDigest); err != nil {
+			return err
+		}
+	}
+	manifest := &image.Manifest{
+		SchemaVersion: 2,
+		MediaType:     image.MediaTypeOCIManifest,
+		Config:        image.Descriptor{MediaType: image.MediaTypeSignatures, Digest: configDigest, Size: int64(len(config))},
+		Layers:        []image.Descriptor{},
+	}
+	content, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	if _, err := c.putManifest(signatureTag(manifestDigest), image.MediaTypeOCIManifest, content); err != nil {
+		return err
+	}
+	fmt.Printf("Pushed %d signature(s) for %s\n", len(signatures), manifestDigest)
+	return nil
+}
+
+// hasOCILayer 判断是否有 OCI 媒体类型的层
+func hasOCILayer(layers []image.Descriptor) bool {
+	for _, layer := range layers {
+		if strings.HasPrefix(layer.MediaType, "application/vnd.oci.image.layer.") {
+			return true
+		}
+	}
+	return false
+}
+
+// signatureTag 返回镜像签名在仓库中的标签
+func signatureTag(manifestDigest string) string {
+	return strings.Replace(manifestDigest, ":", "-", 1) + ".sig"
+}
+
+// pushLayer 上传一层，返回其在 manifest 中的描述
+// 之前拉取或推送时记录过压缩后的摘要且仓库中已有时直接复用（连同记录的媒体类型），否则重新用 gzip 压缩后上传
+func (c *Client) pushLayer(diffID string) (*image.Descriptor, error) {
+	if digest, mediaType, ok := image.DistributionDigest(diffID); ok {
+		size, exists, err := c.blobExists(digest)
+		if err != nil {
+			return nil, err
+		}
+		if exists {
+			fmt.Printf("%s: Layer already exists\n", image.ShortID(digest))
+			return &image.Descriptor{MediaType: mediaType, Digest: digest, Size: size}, nil
+		}
+	}
+
+	// 压缩到临时文件，同时计算摘要
+	blob, err := image.LayerBlob(diffID)
+	if err != nil {
+		return nil, err
+	}
+	defer blob.Close()
+	tmp, err := ioutil.TempFile("", "mydocker-layer-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	hash := sha256.New()
+	gw := gzip.NewWriter(io.MultiWriter(tmp, hash))
+	if _, err := io.Copy(gw, blob); err != nil {
+		return nil, err
+	}
+	if err := gw.Close(); err != nil {
+		return nil, err
+	}
+	size, err := tmp.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	digest := "sha256:" + hex.EncodeToString(hash.Sum(nil))
+	short := image.ShortID(digest)
+
+	if _, exists, err := c.blobExists(digest); err != nil {
+		return nil, err
+	} else if exists {
+		fmt.Printf("%s: Layer already exists\n", short)
+	} else {
+		if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		if err := c.uploadBlob(tmp, digest); err != nil {
+			return nil, err
+		}
+		fmt.Printf("%s: Pushed\n", short)
+	}
+	if err := image.SetDistributionDigest(diffID, digest, image.MediaTypeLayer); err != nil {
+		return nil, err
+	}
+	return &image.Descriptor{MediaType: image.MediaTypeLayer, Digest: digest, Size: size}, nil
+}
+
+// blobExists 判断仓库的镜像库中是否已有 blob，有时返回其大小
+func (c *Client) blobExists(digest string) (int64, bool, error) {
+	rawURL := c.url("blobs/" + digest)
+	resp, err := c.do(http.MethodHead, rawURL, nil, nil)
+	if err != nil {
+		return 0, false, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return 0, false, nil
+	}
+	if err := checkResponse(resp, http.MethodHead, rawURL, http.StatusOK); err != nil {
+		return 0, false, err
+	}
+	resp.Body.Close()
+	return resp.ContentLength, true, nil
+}
+
+// uploadBlob 分块上传 blob：POST 开始上传，每块用 PATCH 发送并带上 Content-Range，最后 PUT ?digest= 完成上传
+// 每次响应的 Location 是下一次请求的地址，可能是相对地址
+func (c *Client) uploadBlob(r io.Reader, digest string) error {
+	rawURL := c.url("blobs/uploads/")
+	resp, err := c.do(http.MethodPost, rawURL, nil, nil)
+	if err != nil {
+		return err
+	}
+	if err := checkResponse(resp, http.MethodPost, rawURL, http.StatusAccepted); err != nil {
+		return err
+	}
+	resp.Body.Close()
+	location, err := resolveLocation(rawURL, resp.Header.Get("Location"))
+	if err != nil {
+		return err
+	}
+
+	buf := make([]byte, chunkSize)
+	var offset int64
+	for {
+		n, err := io.ReadFull(r, buf)
+		if err == io.EOF {
+			break
+		}
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return err
+		}
+		header := http.Header{}
+		header.Set("Content-Type", "application/octet-stream")
+		header.Set("Content-Range", fmt.Sprintf("%d-%d", offset, offset+int64(n)-1))
+		resp, err := c.do(http.MethodPatch, location, header, buf[:n])
+		if err != nil {
+			return err
+		}
+		if err := checkResponse(resp, http.MethodPatch, location, http.StatusAccepted, http.StatusNoContent); err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if location, err = resolveLocation(location, resp.Header.Get("Location")); err != nil {
+			return err
+		}
+		offset += int64(n)
+		if n < chunkSize {
+			break
+		}
+	}
+
+	u, err := url.Parse(location)
+	if err != nil {
+		return err
+	}
+	query := u.Query()
+	query.Set("digest", digest)
+	u.RawQuery = query.Encode()
+	header := http.Header{}
+	header.Set("Content-Type", "application/octet-stream")
+	resp, err = c.do(http.MethodPut, u.String(), header, []byte{})
+	if err != nil {
+		return err
+	}
+	if err := checkResponse(resp, http.MethodPut, u.String(), http.StatusCreated); err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// resolveLocation 把响应中的 Location 解析为绝对地址，没有 Location 时沿用当前地址
+func resolveLocation(base, location string) (string, error) {
+	if location == "" {
+		return base, nil
+	}
+	b, err := url.Parse(base)
+	if err != nil {
+		return "", err
+	}
+	l, err := url.Parse(location)
+	if err != nil {
+		return "", fmt.Errorf("invalid upload location %q: %v", location, err)
+	}
+	return b.ResolveReference(l).String(), nil
+}
+
+// putManifest 上传 manifest 并返回其摘要，仓库返回的摘要必须与内容一致
+func (c *Client) putManifest(tag, mediaType string, content []byte) (string, error) {
+	rawURL := c.url("manifests/" + tag)
+	header := http.Header{}
+	header.Set("Content-Type", mediaType)
+	resp, err := c.do(http.MethodPut, rawURL, header, content)
+	if err != nil {
+		return "", err
+	}
+	if err := checkResponse(resp, http.MethodPut, rawURL, http.StatusCreated, http.StatusOK); err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	digest := image.DigestOf(content)
+	if got := resp.Header.Get("Docker-Content-Digest"); got != "" && got != digest {
+		return "", fmt.Errorf("manifest digest mismatch: registry reported %s, expected %s", got, digest)
+	}
+	return digest, nil
+}
@@ -0,0 +1,95 @@
+package registry
+
+import (
+	"bytes"
+	"go-docker/image"
+	"reflect"
+	"testing"
+)
+
+func TestResolveLocation(t *testing.T) {
+	base := "http://registry:5000/v2/library/test/blobs/uploads/"
+	tests := []struct {
+		location string
+		want     string
+	}{
+		{"", base},
+		{"/v2/library/test/blobs/uploads/abc?_state=x", "http://registry:5000/v2/library/test/blobs/uploads/abc?_state=x"},
+		{"abc", "http://registry:5000/v2/library/test/blobs/uploads/abc"},
+		{"https://storage.example.com/upload/abc", "https://storage.example.com/upload/abc"},
+	}
+	for _, tt := range tests {
+		got, err := resolveLocation(base, tt.location)
+		if err != nil || got != tt.want {
+			t.Errorf("resolveLocation(%q) = %q, %v, want %q", tt.location, got, err, tt.want)
+		}
+	}
+}
+
+// 分块上传时每个 PATCH 都发送到上一次响应给出的相对地址，Content-Range 连续，最后 PUT 校验摘要
+func TestUploadBlobChunked(t *testing.T) {
+	tests := []struct {
+		name    string
+		size    int
+		patches int
+	}{
+		{"empty", 0, 0},
+		{"small", 1024, 1},
+		{"exact chunk", chunkSize, 1},
+		{"multiple chunks", 2*chunkSize + 100, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reg := newFakeRegistry(t, "library/test")
+			content := make([]byte, tt.size)
+			for i := range content {
+				content[i] = byte(i % 251)
+			}
+			digest := image.DigestOf(content)
+
+			if err := reg.client("", "", "pull,push").uploadBlob(bytes.NewReader(content), digest); err != nil {
+				t.Fatalf("uploadBlob: %v", err)
+			}
+			if !bytes.Equal(reg.blobs[digest], content) {
+				t.Errorf("registry stored %d bytes, want %d", len(reg.blobs[digest]), len(content))
+			}
+			if reg.patches != tt.patches {
+				t.Errorf("PATCH requests = %d, want %d", reg.patches, tt.patches)
+			}
+		})
+	}
+}
+
+func TestUploadBlobDigestMismatch(t *testing.T) {
+	reg := newFakeRegistry(t, "library/test")
+	err := reg.client("", "", "pull,push").uploadBlob(bytes.NewReader([]byte("layer")), image.DigestOf([]byte("other")))
+	if err == nil {
+		t.Fatal("expected upload with wrong digest to fail")
+	}
+}
+
+// 仓库中已有之前记录的压缩层时直接复用，manifest 中使用记录的媒体类型
+func TestPushLayerReusesMediaType(t *testing.T) {
+	defer func(old string) { image.StoreRoot = old }(image.StoreRoot)
+	image.StoreRoot = t.TempDir()
+
+	reg := newFakeRegistry(t, "library/test")
+	blob := []byte("zstd compressed layer")
+	digest := reg.addBlob(blob)
+	diffID := image.DigestOf([]byte("uncompressed layer"))
+	if err := image.SetDistributionDigest(diffID, digest, image.MediaTypeOCILayerZstd); err != nil {
+		t.Fatal(err)
+	}
+
+	desc, err := reg.client("", "", "pull,push").pushLayer(diffID)
+	if err != nil {
+		t.Fatalf("pushLayer: %v", err)
+	}
+	want := image.Descriptor{MediaType: image.MediaTypeOCILayerZstd, Digest: digest, Size: int64(len(blob))}
+	if !reflect.DeepEqual(*desc, want) {
+		t.Errorf("pushLayer = %+v, want %+v", *desc, want)
+	}
+	if !hasOCILayer([]image.Descriptor{*desc}) {
+		t.Error("hasOCILayer should report the reused zstd layer")
+	}
+}
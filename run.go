@@ -7,9 +7,13 @@ import (
 	"go-docker/cgroups"
 	"go-docker/cgroups/subsystems"
 	"go-docker/container"
+	"go-docker/image"
 	"go-docker/network"
+	"go-docker/storage"
 	"math/rand"
 	"os"
+	"os/exec"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -17,142 +21,360 @@ import (
 
 // Run 函数用于启动一个容器
 // tty: 是否启用 TTY（终端交互模式）
-// comArray: 容器启动时的命令数组
+// spec: 发送给容器 init 进程的设置，包括用户命令、环境变量等
+// config: 镜像配置与命令行参数合并后的运行配置，记录到容器信息里供 commit 使用
 // res: 容器资源限制配置
 // containerName: 容器名称
-// volume: 容器挂载的卷
-// imageName: 容器镜像名称
-// envSlice: 环境变量
+// mounts: -v、--mount 指定的挂载
+// imageName: 镜像引用（name[:tag]、name@digest 或镜像 ID）
+// imageID: 镜像引用解析得到的镜像 ID
+// rootfs: run --rootfs 指定的根文件系统目录，不为空时以它作为容器唯一的只读层，不使用镜像
+// storageSize: 可写层的大小上限（字节），--storage-opt size，为 0 时不限制
 // nw: 网络名称
 // portmapping: 端口映射配置
-func Run(tty bool, comArray []string, res *subsystems.ResourceConfig, containerName, volume, imageName string,
-	envSlice []string, nw string, portmapping []string) {
+// nsConf: 各命名空间的共享模式
+func Run(tty bool, spec *container.InitSpec, config *image.Config, res *subsystems.ResourceConfig, containerName string,
+	mounts []container.MountPoint, imageName, imageID, rootfs string, storageSize int64, nw string, portmapping []string, nsConf *container.NamespaceConfig) error {
 	// 生成一个随机的容器 ID
 	containerID := randStringBytes(10)
 	// 如果未提供容器名称，使用容器 ID
 	if containerName == "" {
 		containerName = containerID
 	}
+	// 创建容器信息目录来占用名称，目录已经存在说明名称被其他容器使用；创建是原子的，并发启动同名容器时只有一个能成功
+	if err := reserveContainerName(containerName); err != nil {
+		return err
+	}
 
-	// 创建父进程（容器进程）并获取写管道
-	parent, writePipe := container.NewParentProcess(tty, containerName, volume, imageName, envSlice)
-	if parent == nil {
-		log.Errorf("New parent process error")
-		return
+	// 容器的只读层就是镜像的各层，由存储驱动解压和挂载；先记录引用，防止镜像层被垃圾回收
+	// 解析镜像到挂载完成期间持有镜像存储的共享锁，以免记录引用之前或解压时并发的 rmi、rm 回收这些层
+	// 指定 --rootfs 时直接以该目录作为只读层，可写层挂载在它上面
+	driver := storage.Default()
+	layers := []string{rootfs}
+	release := func() {}
+	if rootfs == "" {
+		var err error
+		if release, err = image.Lease(); err != nil {
+			deleteContainerInfo(containerName)
+			return err
+		}
+		img, err := image.GetImage(imageID)
+		if err != nil {
+			release()
+			deleteContainerInfo(containerName)
+			return err
+		}
+		if err := image.AcquireLayers(containerName, driver.Name(), img.RootFS.DiffIDs); err != nil {
+			release()
+			deleteContainerInfo(containerName)
+			return err
+		}
+		if layers, err = image.LayerDirs(img, driver); err != nil {
+			release()
+			image.ReleaseLayers(containerName)
+			deleteContainerInfo(containerName)
+			return err
+		}
 	}
 
-	// 启动父进程（容器进程）
-	if err := parent.Start(); err != nil {
-		log.Error(err)
+	// 创建并挂载数据卷，匿名数据卷在这里确定名称
+	if err := prepareMounts(containerName, mounts); err != nil {
+		release()
+		releaseVolumes(containerName, mounts)
+		image.ReleaseLayers(containerName)
+		deleteContainerInfo(containerName)
+		return err
 	}
 
-	// 记录容器信息
-	containerName, err := recordContainerInfo(parent.Process.Pid, comArray, containerName, containerID, volume)
+	containerInfo := &container.ContainerInfo{
+		Id:           containerID,
+		Name:         containerName,
+		Tty:          tty,
+		Mounts:       mounts,
+		PortMapping:  portmapping,
+		Network:      nw,
+		Namespaces:   *nsConf,
+		Resources:    *res,
+		Entrypoint:   config.Entrypoint,
+		ConfigEnv:    config.Env,
+		ExposedPorts: exposedPortList(config),
+		Image:        imageName,
+		ImageID:      imageID,
+		Driver:       driver.Name(),
+		Rootfs:       rootfs,
+		StorageSize:  storageSize,
+	}
+	// 清理时回收不再使用的层，需要先释放共享锁
+	cleanup := func() {
+		release()
+		deleteContainerInfo(containerName)
+		deleteWorkSpace(driver, mounts, containerName)
+	}
+	parent, cgroupManager, err := startContainer(containerInfo, spec, layers, false, cleanup)
+	// 根文件系统已经挂载，容器运行期间不再持有锁
+	release()
 	if err != nil {
-		log.Errorf("Record container info error %v", err)
+		return err
+	}
+	defer cgroupManager.Destroy()
+
+	// 如果启用了 TTY 模式，则等待父进程（容器进程）结束
+	if tty {
+		parent.Wait()
+		// 容器被快照恢复杀掉时已经转为后台运行，容器信息和可写层要留给恢复后的容器
+		if info, err := getContainerInfoByName(containerName); err == nil && !info.Tty {
+			log.Infof("Container %s was restored from a snapshot and now runs detached", containerName)
+			return nil
+		}
+		// 删除容器信息并清理容器的工作空间
+		deleteContainerInfo(containerName)
+		deleteWorkSpace(driver, mounts, containerName)
+	}
+	return nil
+}
+
+// parseStorageOpts 解析 --storage-opt 的 key=value 选项，返回可写层的大小上限（字节），没有指定时为 0
+func parseStorageOpts(opts []string) (int64, error) {
+	var size int64
+	for _, opt := range opts {
+		kv := strings.SplitN(opt, "=", 2)
+		if len(kv) != 2 {
+			return 0, fmt.Errorf("invalid storage option %q, expected key=value", opt)
+		}
+		switch strings.ToLower(kv[0]) {
+		case "size":
+			parsed, err := storage.ParseSize(kv[1])
+			if err != nil {
+				return 0, fmt.Errorf("invalid storage option %q: %v", opt, err)
+			}
+			if parsed <= 0 {
+				return 0, fmt.Errorf("invalid storage option %q: size must be positive", opt)
+			}
+			size = parsed
+		default:
+			return 0, fmt.Errorf("unknown storage option %q", kv[0])
+		}
+	}
+	return size, nil
+}
+
+// deleteWorkSpace 删除容器的工作空间和快照，释放数据卷并删除匿名数据卷，释放容器对镜像层的引用并回收不再使用的层
+func deleteWorkSpace(driver storage.Driver, mounts []container.MountPoint, containerName string) {
+	container.DeleteWorkSpace(driver, containerName)
+	// 快照属于容器，一起删除；前台运行的容器退出时也在这里删除
+	snapshotURL := fmt.Sprintf(container.SnapshotUrl, containerName)
+	if err := os.RemoveAll(snapshotURL); err != nil {
+		log.Errorf("Remove snapshots %s error %v", snapshotURL, err)
+	}
+	releaseVolumes(containerName, mounts)
+	if err := image.ReleaseLayers(containerName); err != nil {
+		log.Errorf("Release layers of container %s error %v", containerName, err)
 		return
 	}
+	if err := image.GarbageCollect(); err != nil {
+		log.Errorf("Garbage collect error %v", err)
+	}
+}
+
+// startContainer 创建容器的工作空间并启动容器进程，返回时容器 init 进程已完成初始化并开始执行用户命令
+// containerInfo 是要记录的容器信息，调用方填好 ID、名称、是否使用终端、挂载、网络、命名空间、资源限制、镜像和存储驱动等启动前确定的字段，
+// 其余字段（PID、状态以及命令、环境变量等 spec 中的设置）在容器进程启动后由 recordContainerInfo 填写
+// layers 是由存储驱动解压的容器只读层，按从下到上的顺序排列
+// reuseLayer 为 true 时沿用已有的可写层重新启动容器，只有 snapshot restore 使用；否则创建新的可写层
+// 启动失败时总是调用一次 cleanup 后返回错误，由它清理容器信息和工作空间、释放数据卷和镜像层的引用，调用方不再重复释放；
+// 重新启动已有的容器时只需要卸载根文件系统
+// 返回容器进程和容器的 cgroup 管理器，调用方负责等待容器进程、销毁 cgroup 和清理工作空间
+func startContainer(containerInfo *container.ContainerInfo, spec *container.InitSpec, layers []string, reuseLayer bool,
+	cleanup func()) (*exec.Cmd, *cgroups.CgroupManager, error) {
+	driver, err := storage.GetDriver(containerInfo.Driver)
+	if err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	containerID, containerName, tty, mounts, nsConf := containerInfo.Id, containerInfo.Name, containerInfo.Tty, containerInfo.Mounts, &containerInfo.Namespaces
+
+	// 独享 UTS 命名空间时，默认以容器 ID 作为主机名
+	if spec.Hostname == "" && nsConf.IsPrivate("uts") {
+		spec.Hostname = containerID
+	}
+
+	// -v、--mount 指定的挂载由 init 进程在容器的挂载命名空间中完成
+	spec.Mounts = container.InitMounts(mounts)
+	spec.RootPropagation = container.RootPropagation(mounts)
+
+	// 创建父进程（容器进程）并获取通信管道
+	parent, writePipe, errPipe := container.NewParentProcess(tty, containerName, layers, driver, containerInfo.StorageSize, reuseLayer, nsConf)
+	if parent == nil {
+		// 创建日志文件失败时可写层已经创建并挂载，同样需要清理，否则之后同名的容器无法创建可写层
+		cleanup()
+		return nil, nil, fmt.Errorf("new parent process error")
+	}
+
+	// 空的数据卷用镜像中挂载目标路径下的内容初始化
+	mntURL := fmt.Sprintf(container.MntUrl, containerName)
+	if err := populateVolumes(mounts, mntURL); err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+
+	// 构造容器的环境变量：标准变量加上 -e/--env-file，不继承宿主机的环境变量
+	home, err := container.LookupHome(mntURL, spec.User)
+	if err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	spec.Env = container.BuildContainerEnv(spec.Hostname, home, tty, spec.Env)
+
+	// 启动父进程（容器进程）
+	if err := startParentProcess(parent, nsConf); err != nil {
+		cleanup()
+		return nil, nil, err
+	}
 
 	// 使用容器 ID 创建 cgroup 管理器
 	cgroupManager := cgroups.NewCgroupManager(containerID)
-	defer cgroupManager.Destroy()
+
+	// 启动之后的任何失败都需要杀掉容器进程并清理，避免留下半启动的容器
+	fail := func(err error) (*exec.Cmd, *cgroups.CgroupManager, error) {
+		writePipe.Close()
+		parent.Process.Kill()
+		parent.Wait()
+		cgroupManager.Destroy()
+		cleanup()
+		return nil, nil, err
+	}
+
+	// 记录容器信息
+	if _, err := recordContainerInfo(parent.Process.Pid, spec, containerInfo); err != nil {
+		return fail(fmt.Errorf("record container info error %v", err))
+	}
+
 	// 设置资源限制并将其应用到容器进程
-	cgroupManager.Set(res)
+	cgroupManager.Set(&containerInfo.Resources)
 	cgroupManager.Apply(parent.Process.Pid)
 
-	// 如果指定了网络配置，则连接容器到指定的网络
-	if nw != "" {
+	// 如果指定了网络配置，则连接容器到指定的网络（none/host/container:<name> 模式由 network.Connect 处理）
+	if containerInfo.Network != "" {
 		network.Init()
-		containerInfo := &container.ContainerInfo{
-			Id:          containerID,
-			Pid:         strconv.Itoa(parent.Process.Pid),
-			Name:        containerName,
-			PortMapping: portmapping,
-		}
 		// 将容器连接到网络
-		if err := network.Connect(nw, containerInfo); err != nil {
-			log.Errorf("Error Connect Network %v", err)
-			return
+		if err := network.Connect(containerInfo.Network, containerInfo); err != nil {
+			return fail(fmt.Errorf("connect network error %v", err))
 		}
 	}
 
-	// 发送初始化命令给容器
-	sendInitCommand(comArray, writePipe)
+	// 发送 InitSpec 给容器，并等待 init 进程完成初始化
+	log.Infof("command all is %q", spec.Args)
+	if err := container.SendInitSpec(spec, writePipe); err != nil {
+		return fail(err)
+	}
+	if err := container.WaitInitResult(errPipe); err != nil {
+		return fail(err)
+	}
+	return parent, cgroupManager, nil
+}
 
-	// 如果启用了 TTY 模式，则等待父进程（容器进程）结束
-	if tty {
-		parent.Wait()
-		// 删除容器信息并清理容器的工作空间
-		deleteContainerInfo(containerName)
-		container.DeleteWorkSpace(volume, containerName)
+// recordContainerInfo 函数用于记录容器的相关信息
+// containerPID: 容器进程的 PID
+// spec: 发送给容器 init 进程的设置，命令、环境变量、工作目录、用户等从这里记录
+// containerInfo: startContainer 的调用方填好的容器信息，补全后写入容器信息目录
+// 创建时间为空时使用当前时间，重新启动的容器保留原来的创建时间
+func recordContainerInfo(containerPID int, spec *container.InitSpec, containerInfo *container.ContainerInfo) (string, error) {
+	if containerInfo.CreatedTime == "" {
+		// 获取当前时间作为容器创建时间
+		containerInfo.CreatedTime = time.Now().Format("2006-01-02 15:04:05")
 	}
+	containerInfo.Pid = strconv.Itoa(containerPID)
+	containerInfo.Status = container.RUNNING
+	// 将命令数组拼接为一个命令字符串，仅用于展示
+	containerInfo.Command = strings.Join(spec.Args, " ")
+	containerInfo.Args = spec.Args
+	containerInfo.WorkingDir = spec.Cwd
+	containerInfo.User = spec.User
+	containerInfo.Hostname = spec.Hostname
+	containerInfo.Env = spec.Env
+	containerInfo.Init = spec.Init
+	containerInfo.Rlimits = spec.Rlimits
+	containerInfo.ReadonlyRoot = spec.ReadonlyRoot
 
-}
+	if err := writeContainerInfo(containerInfo); err != nil {
+		return "", err
+	}
 
-// sendInitCommand 函数用于发送容器初始化命令
-// comArray: 容器启动时的命令数组
-// writePipe: 用于写入容器命令的管道
-func sendInitCommand(comArray []string, writePipe *os.File) {
-	// 将命令数组拼接为一个命令字符串
-	command := strings.Join(comArray, " ")
-	log.Infof("command all is %s", command)
-	// 将命令写入管道并关闭管道
-	writePipe.WriteString(command)
-	writePipe.Close()
+	// 返回容器名称
+	return containerInfo.Name, nil
 }
 
-// recordContainerInfo 函数用于记录容器的相关信息
-// containerPID: 容器进程的 PID
-// commandArray: 容器启动命令
-// containerName: 容器名称
-// id: 容器 ID
-// volume: 容器挂载的卷
-func recordContainerInfo(containerPID int, commandArray []string, containerName, id, volume string) (string, error) {
-	// 获取当前时间作为容器创建时间
-	createTime := time.Now().Format("2006-01-02 15:04:05")
-	// 将命令数组拼接为一个命令字符串
-	command := strings.Join(commandArray, "")
-	// 构建容器信息对象
-	containerInfo := &container.ContainerInfo{
-		Id:          id,
-		Pid:         strconv.Itoa(containerPID),
-		Command:     command,
-		CreatedTime: createTime,
-		Status:      container.RUNNING,
-		Name:        containerName,
-		Volume:      volume,
+// exposedPortList 返回运行配置中暴露的端口，按字典序排列
+func exposedPortList(config *image.Config) []string {
+	var exposedPorts []string
+	for port := range config.ExposedPorts {
+		exposedPorts = append(exposedPorts, port)
 	}
+	sort.Strings(exposedPorts)
+	return exposedPorts
+}
 
+// writeContainerInfo 把容器信息以 JSON 格式写入容器信息目录下的 config.json
+func writeContainerInfo(containerInfo *container.ContainerInfo) error {
 	// 将容器信息对象转为 JSON 字符串
 	jsonBytes, err := json.Marshal(containerInfo)
 	if err != nil {
 		log.Errorf("Record container info error %v", err)
-		return "", err
+		return err
 	}
 	jsonStr := string(jsonBytes)
 
 	// 创建容器信息保存目录
-	dirUrl := fmt.Sprintf(container.DefaultInfoLocation, containerName)
+	dirUrl := fmt.Sprintf(container.DefaultInfoLocation, containerInfo.Name)
 	if err := os.MkdirAll(dirUrl, 0622); err != nil {
 		log.Errorf("Mkdir error %s error %v", dirUrl, err)
-		return "", err
+		return err
 	}
 	// 创建容器信息文件
 	fileName := dirUrl + "/" + container.ConfigName
 	file, err := os.Create(fileName)
-	defer file.Close()
 	if err != nil {
 		log.Errorf("Create file %s error %v", fileName, err)
-		return "", err
+		return err
 	}
+	defer file.Close()
 	// 将容器信息写入文件
 	if _, err := file.WriteString(jsonStr); err != nil {
 		log.Errorf("File write string error %v", err)
-		return "", err
+		return err
 	}
+	return nil
+}
 
-	// 返回容器名称
-	return containerName, nil
+// startParentProcess 启动容器 init 进程
+// 启动前先加入 container:<name> 或 path:<file> 指定的命名空间，子进程会从当前线程继承它们
+func startParentProcess(parent *exec.Cmd, nsConf *container.NamespaceConfig) error {
+	defer container.CloseChildPipes(parent)
+	restoreNs, err := nsConf.Enter(GetContainerPidByName)
+	if err != nil {
+		return fmt.Errorf("enter namespaces error %v", err)
+	}
+	err = parent.Start()
+	restoreNs()
+	if err != nil {
+		return fmt.Errorf("start container process error %v", err)
+	}
+	return nil
+}
+
+// reserveContainerName 创建容器信息目录，名称已被使用时返回错误
+func reserveContainerName(containerName string) error {
+	if err := os.MkdirAll(fmt.Sprintf(container.DefaultInfoLocation, ""), 0622); err != nil {
+		return err
+	}
+	dirURL := fmt.Sprintf(container.DefaultInfoLocation, containerName)
+	if err := os.Mkdir(dirURL, 0622); err != nil {
+		if os.IsExist(err) {
+			return fmt.Errorf("container name %s is already in use", containerName)
+		}
+		return err
+	}
+	return nil
 }
 
 // deleteContainerInfo 函数用于删除容器的相关信息
@@ -0,0 +1,349 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"go-docker/container"
+	"go-docker/image"
+	"go-docker/storage"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+)
+
+// ------------------------
+// 容器可写层快照：在容器中做有风险的操作之前保存一个恢复点，不需要生成镜像
+//   <SnapshotUrl>/<snapshot>/layer           可写层目录的完整副本，内部结构由存储驱动决定
+//   <SnapshotUrl>/<snapshot>/snapshot.json   快照的元数据
+// 复制使用 cp --reflink=auto，文件系统支持时只复制元数据；运行中的容器创建快照时不会暂停，
+// 正在写入的文件可能不完整
+// restore 停止容器，把快照复制回可写层后在同一镜像上以后台方式重新启动容器
+// 快照属于容器，删除容器时一起删除
+// ------------------------
+
+// snapshotNameRegexp 是快照名称的格式
+var snapshotNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// snapshotStopTimeout 是 restore 等待容器进程响应 SIGTERM 的时间，超时后用 SIGKILL 杀掉
+const snapshotStopTimeout = 10 * time.Second
+
+// snapshotInfo 是快照的元数据
+type snapshotInfo struct {
+	Name        string `json:"name"`          // 快照名称
+	Container   string `json:"container"`     // 容器名称
+	ContainerID string `json:"containerId"`   // 容器 ID，防止恢复到之后创建的同名容器
+	Driver      string `json:"storageDriver"` // 可写层的存储驱动
+	CreatedTime string `json:"createTime"`    // 创建时间
+	Size        int64  `json:"size"`          // 快照占用的磁盘空间（字节）
+}
+
+// snapshotDir 返回容器 containerName 的快照 name 所在的目录，name 为空时返回容器所有快照的目录
+func snapshotDir(containerName, name string) string {
+	return filepath.Join(fmt.Sprintf(container.SnapshotUrl, containerName), name)
+}
+
+// getSnapshot 读取快照的元数据
+func getSnapshot(containerName, name string) (*snapshotInfo, error) {
+	if !snapshotNameRegexp.MatchString(name) {
+		return nil, fmt.Errorf("invalid snapshot name %q", name)
+	}
+	content, err := ioutil.ReadFile(filepath.Join(snapshotDir(containerName, name), "snapshot.json"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("no such snapshot: %s", name)
+		}
+		return nil, err
+	}
+	snapshot := &snapshotInfo{}
+	if err := json.Unmarshal(content, snapshot); err != nil {
+		return nil, fmt.Errorf("parse snapshot %s error %v", name, err)
+	}
+	return snapshot, nil
+}
+
+// snapshotContainer 读取容器信息并确认容器有可写层；由 OCI bundle 创建的容器直接使用 bundle 中的根文件系统，没有可写层
+func snapshotContainer(containerName string) (*container.ContainerInfo, error) {
+	containerInfo, err := getContainerInfoByName(containerName)
+	if err != nil {
+		return nil, fmt.Errorf("container %s does not exist", containerName)
+	}
+	if containerInfo.Bundle != "" {
+		return nil, fmt.Errorf("container %s was created from an OCI bundle and has no write layer", containerName)
+	}
+	if exist, _ := container.PathExists(fmt.Sprintf(container.WriteLayerUrl, containerName)); !exist {
+		return nil, fmt.Errorf("container %s has no write layer", containerName)
+	}
+	return containerInfo, nil
+}
+
+// createSnapshot 把容器的可写层复制为快照 name，name 为空时生成随机名称，输出快照名称
+func createSnapshot(containerName, name string) error {
+	containerInfo, err := snapshotContainer(containerName)
+	if err != nil {
+		return err
+	}
+	if name == "" {
+		name = randStringBytes(10)
+	}
+	if !snapshotNameRegexp.MatchString(name) {
+		return fmt.Errorf("invalid snapshot name %q, only [a-zA-Z0-9][a-zA-Z0-9_.-] are allowed", name)
+	}
+	dir := snapshotDir(containerName, name)
+	if exist, _ := container.PathExists(dir); exist {
+		return fmt.Errorf("snapshot %s of container %s already exists", name, containerName)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "layer"), 0700); err != nil {
+		log.Errorf("Mkdir %s error %v", dir, err)
+		return err
+	}
+	writeURL := fmt.Sprintf(container.WriteLayerUrl, containerName)
+	if err := copyLayerDir(writeURL, filepath.Join(dir, "layer")); err != nil {
+		log.Errorf("Copy write layer of container %s error %v", containerName, err)
+		os.RemoveAll(dir)
+		return err
+	}
+
+	snapshot := &snapshotInfo{
+		Name:        name,
+		Container:   containerName,
+		ContainerID: containerInfo.Id,
+		Driver:      containerInfo.Driver,
+		CreatedTime: time.Now().Format("2006-01-02 15:04:05"),
+		Size:        dirSize(filepath.Join(dir, "layer")),
+	}
+	content, err := json.Marshal(snapshot)
+	if err != nil {
+		os.RemoveAll(dir)
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "snapshot.json"), content, 0600); err != nil {
+		log.Errorf("Write snapshot %s error %v", name, err)
+		os.RemoveAll(dir)
+		return err
+	}
+	fmt.Fprintln(os.Stdout, name)
+	return nil
+}
+
+// listSnapshots 按创建时间顺序列出容器的快照，quiet 时只输出名称
+func listSnapshots(containerName string, quiet bool) error {
+	if _, err := getContainerInfoByName(containerName); err != nil {
+		return fmt.Errorf("container %s does not exist", containerName)
+	}
+	files, err := ioutil.ReadDir(snapshotDir(containerName, ""))
+	if err != nil && !os.IsNotExist(err) {
+		log.Errorf("Read snapshots of container %s error %v", containerName, err)
+		return err
+	}
+	var snapshots []*snapshotInfo
+	for _, file := range files {
+		snapshot, err := getSnapshot(containerName, file.Name())
+		if err != nil {
+			log.Warnf("Skip snapshot %s: %v", file.Name(), err)
+			continue
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+	sort.SliceStable(snapshots, func(i, j int) bool { return snapshots[i].CreatedTime < snapshots[j].CreatedTime })
+
+	if quiet {
+		for _, snapshot := range snapshots {
+			fmt.Fprintln(os.Stdout, snapshot.Name)
+		}
+		return nil
+	}
+	w := tabwriter.NewWriter(os.Stdout, 12, 1, 3, ' ', 0)
+	fmt.Fprint(w, "SNAPSHOT\tCREATED\tSIZE\n")
+	for _, snapshot := range snapshots {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", snapshot.Name, snapshot.CreatedTime, humanSize(snapshot.Size))
+	}
+	if err := w.Flush(); err != nil {
+		log.Errorf("Flush error %v", err)
+		return err
+	}
+	return nil
+}
+
+// removeSnapshots 删除容器的快照，输出删除的快照名称
+func removeSnapshots(containerName string, names []string) error {
+	var failed []string
+	for _, name := range names {
+		if _, err := getSnapshot(containerName, name); err != nil {
+			log.Errorf("Remove snapshot %s error %v", name, err)
+			failed = append(failed, name)
+			continue
+		}
+		if err := os.RemoveAll(snapshotDir(containerName, name)); err != nil {
+			log.Errorf("Remove snapshot %s error %v", name, err)
+			failed = append(failed, name)
+			continue
+		}
+		fmt.Fprintln(os.Stdout, name)
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to remove snapshots: %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// restoreSnapshot 把容器回滚到快照 name：停止容器，卸载根文件系统，用快照替换可写层的内容，
+// 再用记录的运行配置在同一镜像上以后台方式重新启动容器；已停止的容器也会被启动
+func restoreSnapshot(containerName, name string) error {
+	containerInfo, err := snapshotContainer(containerName)
+	if err != nil {
+		return err
+	}
+	snapshot, err := getSnapshot(containerName, name)
+	if err != nil {
+		return err
+	}
+	if snapshot.ContainerID != containerInfo.Id {
+		return fmt.Errorf("snapshot %s was taken from another container named %s", name, containerName)
+	}
+	if snapshot.Driver != containerInfo.Driver {
+		return fmt.Errorf("snapshot %s uses storage driver %s, but container %s uses %s", name, snapshot.Driver, containerName, containerInfo.Driver)
+	}
+	driver, err := storage.GetDriver(containerInfo.Driver)
+	if err != nil {
+		return err
+	}
+	// 先确认镜像层还在，再停止容器
+	layers := []string{containerInfo.Rootfs}
+	if containerInfo.Rootfs == "" {
+		img, err := image.GetImage(containerInfo.ImageID)
+		if err != nil {
+			return err
+		}
+		if layers, err = image.LayerDirs(img, driver); err != nil {
+			return err
+		}
+	}
+
+	// 停止容器，之后的步骤失败时容器保持停止状态
+	if processAlive(containerInfo.Pid) {
+		// -ti 启动的容器由前台的 mydocker run 等待，先把容器标记为后台运行，
+		// 它看到标记后不再删除容器信息和可写层，恢复后的容器在后台运行
+		attached := containerInfo.Tty
+		containerInfo.Tty = false
+		if attached {
+			if err := writeContainerInfo(containerInfo); err != nil {
+				return err
+			}
+		}
+		if err := killContainerProcess(containerInfo.Pid); err != nil {
+			if attached {
+				containerInfo.Tty = true
+				writeContainerInfo(containerInfo)
+			}
+			return err
+		}
+	}
+	containerInfo.Status = container.STOP
+	containerInfo.Pid = " "
+	if err := writeContainerInfo(containerInfo); err != nil {
+		return err
+	}
+	mntURL := fmt.Sprintf(container.MntUrl, containerName)
+	if exist, _ := container.PathExists(mntURL); exist {
+		if err := container.DeleteMountPoint(driver, containerName); err != nil {
+			return fmt.Errorf("unmount rootfs of container %s error %v", containerName, err)
+		}
+	}
+
+	// 可写层目录可能是大小限制的挂载点，只替换其中的内容
+	writeURL := fmt.Sprintf(container.WriteLayerUrl, containerName)
+	if err := clearDir(writeURL); err != nil {
+		log.Errorf("Clear write layer %s error %v", writeURL, err)
+		return err
+	}
+	if err := copyLayerDir(filepath.Join(snapshotDir(containerName, name), "layer"), writeURL); err != nil {
+		log.Errorf("Copy snapshot %s to write layer %s error %v", name, writeURL, err)
+		return err
+	}
+	return restartContainer(containerInfo, driver, layers)
+}
+
+// restartContainer 用容器信息中记录的运行配置重新启动已停止的容器，沿用原来的容器 ID、创建时间、可写层和数据卷
+// 启动失败时卸载根文件系统，容器信息恢复为停止状态
+func restartContainer(containerInfo *container.ContainerInfo, driver storage.Driver, layers []string) error {
+	spec := &container.InitSpec{
+		Args:         containerInfo.Args,
+		Env:          containerInfo.Env,
+		Cwd:          containerInfo.WorkingDir,
+		User:         containerInfo.User,
+		Hostname:     containerInfo.Hostname,
+		Rlimits:      containerInfo.Rlimits,
+		Init:         containerInfo.Init,
+		ReadonlyRoot: containerInfo.ReadonlyRoot,
+	}
+	stopped := *containerInfo
+	cleanup := func() {
+		container.DeleteMountPoint(driver, containerInfo.Name)
+		writeContainerInfo(&stopped)
+	}
+	_, cgroupManager, err := startContainer(containerInfo, spec, layers, true, cleanup)
+	if err != nil {
+		return err
+	}
+	defer cgroupManager.Destroy()
+	return nil
+}
+
+// killContainerProcess 用 SIGTERM 停止容器进程并等待它退出，超时后用 SIGKILL 杀掉
+func killContainerProcess(pid string) error {
+	pidInt, err := strconv.Atoi(strings.TrimSpace(pid))
+	if err != nil {
+		return fmt.Errorf("invalid container pid %q", pid)
+	}
+	if err := syscall.Kill(pidInt, syscall.SIGTERM); err != nil {
+		return fmt.Errorf("stop container process %d error %v", pidInt, err)
+	}
+	for deadline := time.Now().Add(snapshotStopTimeout); processAlive(pid) && time.Now().Before(deadline); {
+		time.Sleep(100 * time.Millisecond)
+	}
+	if processAlive(pid) {
+		log.Warnf("Container process %d did not exit in %s, killing it", pidInt, snapshotStopTimeout)
+		if err := syscall.Kill(pidInt, syscall.SIGKILL); err != nil {
+			return fmt.Errorf("kill container process %d error %v", pidInt, err)
+		}
+		for i := 0; i < 100 && processAlive(pid); i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	if processAlive(pid) {
+		return fmt.Errorf("container process %d did not exit", pidInt)
+	}
+	return nil
+}
+
+// copyLayerDir 把可写层目录 src 中的内容复制到已存在的目录 dst
+// cp -a 保留属主、权限、时间、硬链接、扩展属性（overlay 的不透明目录）和设备文件（overlay 的白障）
+func copyLayerDir(src, dst string) error {
+	if output, err := exec.Command("cp", "-a", "--reflink=auto", src+"/.", dst).CombinedOutput(); err != nil {
+		return fmt.Errorf("copy %s to %s error %v: %s", src, dst, err, output)
+	}
+	return nil
+}
+
+// clearDir 删除目录 dir 中的所有内容，保留目录本身
+func clearDir(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if err := os.RemoveAll(filepath.Join(dir, entry.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	log "github.com/sirupsen/logrus"
 	"go-docker/container"
+	"go-docker/storage"
 	"io/ioutil"
 	"os"
 	"strconv"
@@ -58,7 +59,7 @@ func stopContainer(containerName string) {
 
 	// 将更新后的容器信息写回到文件
 	if err := ioutil.WriteFile(configFilePath, newContentBytes, 0622); err != nil {
-		log.Errorf("Write file %s error", configFilePath, err)
+		log.Errorf("Write file %s error %v", configFilePath, err)
 	}
 }
 
@@ -112,6 +113,11 @@ func removeContainer(containerName string) {
 		return
 	}
 
-	// 删除容器的工作空间
-	container.DeleteWorkSpace(containerInfo.Volume, containerName)
+	// 用创建容器时的存储驱动删除容器的工作空间
+	driver, err := storage.GetDriver(containerInfo.Driver)
+	if err != nil {
+		log.Errorf("Get storage driver of container %s error %v", containerName, err)
+		return
+	}
+	deleteWorkSpace(driver, containerInfo.Mounts, containerName)
 }
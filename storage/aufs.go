@@ -0,0 +1,66 @@
+package storage
+
+import (
+	"fmt"
+	"go-docker/archive"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// aufsDriver 使用 AUFS：可写层目录中的 diff 作为可写分支，镜像层作为只读分支
+// AUFS 与 OCI 镜像层使用相同的白障文件名，镜像层原样解压即可
+type aufsDriver struct{}
+
+func (d *aufsDriver) Name() string { return "aufs" }
+
+func aufsSupported() error {
+	if !filesystemSupported("aufs") {
+		exec.Command("modprobe", "aufs").Run()
+		if !filesystemSupported("aufs") {
+			return fmt.Errorf("aufs is not listed in /proc/filesystems")
+		}
+	}
+	return nil
+}
+
+func (d *aufsDriver) ApplyDiff(dir string, layer io.Reader) error {
+	return archive.Untar(layer, dir)
+}
+
+func (d *aufsDriver) CreateLayer(dir string, layers []string) error {
+	return createDirs(filepath.Join(dir, "diff"))
+}
+
+// Mount 挂载 AUFS，注意顺序：可写层在最上面，只读层按从上到下的顺序排在后面
+func (d *aufsDriver) Mount(dir string, layers []string, target string) error {
+	// 分支以 : 分隔、权限以 = 标注，挂载选项以 , 分隔，路径中有这些字符时无法表示
+	for _, path := range append([]string{dir}, layers...) {
+		if strings.ContainsAny(path, ",:=") {
+			return fmt.Errorf("mount aufs on %s: branch path %s contains ',', ':' or '='", target, path)
+		}
+	}
+	branches := []string{filepath.Join(dir, "diff")}
+	for i := len(layers) - 1; i >= 0; i-- {
+		branches = append(branches, layers[i]+"=ro")
+	}
+	dirs := "dirs=" + strings.Join(branches, ":")
+	if output, err := exec.Command("mount", "-t", "aufs", "-o", dirs, "none", target).CombinedOutput(); err != nil {
+		return fmt.Errorf("mount aufs on %s error %v: %s", target, err, output)
+	}
+	return nil
+}
+
+func (d *aufsDriver) Unmount(target string) error {
+	return unmount(target)
+}
+
+func (d *aufsDriver) Diff(dir string, layers []string, w io.Writer) error {
+	return archive.TarLayer(filepath.Join(dir, "diff"), w)
+}
+
+func (d *aufsDriver) Remove(dir string) error {
+	return os.RemoveAll(dir)
+}
@@ -0,0 +1,134 @@
+package storage
+
+import (
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// ------------------------
+// 存储驱动：负责解压镜像层、创建容器可写层、把镜像层和可写层合并挂载为容器的根文件系统
+// 镜像层按驱动分别解压（各驱动表示白障的方式不同），容器可写层目录的内部结构也由驱动决定
+// ------------------------
+
+// Driver 是存储驱动的接口，layers 均为镜像各层解压后的目录，按从下到上的顺序排列
+type Driver interface {
+	// Name 返回驱动名称
+	Name() string
+	// ApplyDiff 把未压缩的镜像层 tar 包解压到空目录 dir，白障转换为驱动使用的形式
+	ApplyDiff(dir string, layer io.Reader) error
+	// CreateLayer 在 dir 中创建容器的可写层
+	CreateLayer(dir string, layers []string) error
+	// Mount 把镜像层和 dir 中的可写层合并挂载到 target
+	Mount(dir string, layers []string, target string) error
+	// Unmount 卸载 target 上的容器根文件系统
+	Unmount(target string) error
+	// Diff 把可写层相对于镜像层的改动按 OCI 镜像层格式打包写入 w
+	Diff(dir string, layers []string, w io.Writer) error
+	// Remove 删除 dir 中的可写层
+	Remove(dir string) error
+}
+
+// drivers 是所有支持的驱动，探测时按此顺序选择第一个可用的驱动
+var drivers = []struct {
+	name      string
+	driver    Driver
+	supported func() error
+}{
+	{"overlay", &overlayDriver{}, overlaySupported},
+	{"aufs", &aufsDriver{}, aufsSupported},
+	{"vfs", &vfsDriver{}, func() error { return nil }},
+}
+
+// ProbeDir 是探测 overlay 是否可用时创建临时目录的位置，应与镜像层和容器可写层在同一文件系统上
+var ProbeDir = "/root"
+
+var (
+	defaultDriver Driver
+	probeOnce     sync.Once
+)
+
+// GetDriver 返回指定名称的驱动，不检查内核是否支持；名称为空时返回 aufs（之前的版本只支持 aufs）
+func GetDriver(name string) (Driver, error) {
+	if name == "" {
+		name = "aufs"
+	}
+	for _, d := range drivers {
+		if d.name == name {
+			return d.driver, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown storage driver %q, supported: overlay, aufs, vfs", name)
+}
+
+// SetDefault 指定默认驱动（--storage-driver），内核不支持时返回错误
+func SetDefault(name string) error {
+	for _, d := range drivers {
+		if d.name == name {
+			if err := d.supported(); err != nil {
+				return fmt.Errorf("storage driver %s is not supported: %v", name, err)
+			}
+			defaultDriver = d.driver
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown storage driver %q, supported: overlay, aufs, vfs", name)
+}
+
+// Default 返回默认驱动，没有用 SetDefault 指定时探测内核，选择第一个可用的驱动
+func Default() Driver {
+	probeOnce.Do(func() {
+		if defaultDriver != nil {
+			return
+		}
+		for _, d := range drivers {
+			if err := d.supported(); err != nil {
+				log.Debugf("Storage driver %s is not supported: %v", d.name, err)
+				continue
+			}
+			defaultDriver = d.driver
+			return
+		}
+	})
+	return defaultDriver
+}
+
+// filesystemSupported 判断内核是否支持某种文件系统
+func filesystemSupported(fs string) bool {
+	content, err := ioutil.ReadFile("/proc/filesystems")
+	if err != nil {
+		return false
+	}
+	for _, line := range strings.Split(string(content), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) > 0 && fields[len(fields)-1] == fs {
+			return true
+		}
+	}
+	return false
+}
+
+// createDirs 创建多个目录
+func createDirs(dirs ...string) error {
+	for _, dir := range dirs {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			log.Errorf("Mkdir %s error %v", dir, err)
+			return err
+		}
+	}
+	return nil
+}
+
+// unmount 卸载挂载点
+func unmount(target string) error {
+	if output, err := exec.Command("umount", target).CombinedOutput(); err != nil {
+		log.Errorf("Unmount %s error %v: %s", target, err, output)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package storage
+
+import (
+	"fmt"
+	"go-docker/archive"
+	"golang.org/x/sys/unix"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// overlayDriver 使用 overlayfs：镜像层作为多个 lowerdir，可写层目录中的 diff 作为 upperdir，work 作为 workdir
+// overlayfs 用 0/0 字符设备表示删除的文件，用 trusted.overlay.opaque=y 扩展属性表示不透明目录
+type overlayDriver struct{}
+
+func (d *overlayDriver) Name() string { return "overlay" }
+
+// overlaySupported 在 ProbeDir 下实际挂载一次 overlay，内核支持 overlay 但底层文件系统不能作为 upperdir 时也视为不可用
+func overlaySupported() error {
+	if !filesystemSupported("overlay") {
+		// overlay 可能编译为模块，尝试加载
+		exec.Command("modprobe", "overlay").Run()
+		if !filesystemSupported("overlay") {
+			return fmt.Errorf("overlay is not listed in /proc/filesystems")
+		}
+	}
+	tmp, err := ioutil.TempDir(ProbeDir, ".overlay-probe-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	lower, upper, work, merged := filepath.Join(tmp, "lower"), filepath.Join(tmp, "upper"), filepath.Join(tmp, "work"), filepath.Join(tmp, "merged")
+	if err := createDirs(lower, upper, work, merged); err != nil {
+		return err
+	}
+	opts := fmt.Sprintf("lowerdir=%s,upperdir=%s,workdir=%s", lower, upper, work)
+	if err := unix.Mount("overlay", merged, "overlay", 0, opts); err != nil {
+		return fmt.Errorf("mount overlay on %s error %v", ProbeDir, err)
+	}
+	return unix.Unmount(merged, 0)
+}
+
+// ApplyDiff 解压镜像层，并把 OCI 白障转换为 overlayfs 的形式
+func (d *overlayDriver) ApplyDiff(dir string, layer io.Reader) error {
+	if err := archive.Untar(layer, dir); err != nil {
+		return err
+	}
+	return convertWhiteouts(dir)
+}
+
+// convertWhiteouts 把 .wh.<name> 转换为 0/0 字符设备，把 .wh..wh..opq 转换为所在目录的不透明属性
+func convertWhiteouts(dir string) error {
+	var whiteouts []string
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(info.Name(), archive.WhiteoutPrefix) {
+			whiteouts = append(whiteouts, path)
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, path := range whiteouts {
+		parent, base := filepath.Dir(path), filepath.Base(path)
+		if err := os.RemoveAll(path); err != nil {
+			return err
+		}
+		switch {
+		case base == archive.WhiteoutOpaqueDir:
+			if err := unix.Lsetxattr(parent, archive.OverlayOpaqueXattr, []byte("y"), 0); err != nil {
+				return fmt.Errorf("set opaque xattr on %s error %v", parent, err)
+			}
+		case strings.HasPrefix(base, archive.WhiteoutMetaPrefix):
+			// AUFS 的内部数据，直接丢弃
+		default:
+			target := filepath.Join(parent, strings.TrimPrefix(base, archive.WhiteoutPrefix))
+			if err := unix.Mknod(target, unix.S_IFCHR, 0); err != nil {
+				return fmt.Errorf("create whiteout %s error %v", target, err)
+			}
+		}
+	}
+	return nil
+}
+
+// CreateLayer 创建 upperdir 和 workdir
+func (d *overlayDriver) CreateLayer(dir string, layers []string) error {
+	return createDirs(filepath.Join(dir, "diff"), filepath.Join(dir, "work"))
+}
+
+// Mount 挂载 overlay，lowerdir 按从上到下的顺序排列；没有镜像层时使用一个空目录作为 lowerdir
+// 挂载选项最长一页，几十层的完整路径就会超出（内核只返回 EINVAL），路径中的 , 和 : 也会破坏选项的格式；
+// 与 docker 的 overlay2 一样，在 dir/l 中为各层创建短的符号链接，在 dir 中执行 mount，选项里只用相对路径
+func (d *overlayDriver) Mount(dir string, layers []string, target string) error {
+	linkDir := filepath.Join(dir, "l")
+	if err := os.RemoveAll(linkDir); err != nil {
+		return err
+	}
+	if err := createDirs(linkDir); err != nil {
+		return err
+	}
+	var lowers []string
+	for i := len(layers) - 1; i >= 0; i-- {
+		layer, err := filepath.Abs(layers[i])
+		if err != nil {
+			return err
+		}
+		link := filepath.Join("l", strconv.Itoa(i))
+		if err := os.Symlink(layer, filepath.Join(dir, link)); err != nil {
+			return err
+		}
+		lowers = append(lowers, link)
+	}
+	if len(lowers) == 0 {
+		if err := createDirs(filepath.Join(dir, "empty")); err != nil {
+			return err
+		}
+		lowers = append(lowers, "empty")
+	}
+	opts := fmt.Sprintf("lowerdir=%s,upperdir=diff,workdir=work", strings.Join(lowers, ":"))
+	if len(opts) >= os.Getpagesize() {
+		return fmt.Errorf("mount overlay on %s: too many layers (%d)", target, len(layers))
+	}
+	cmd := exec.Command("mount", "-t", "overlay", "-o", opts, "overlay", target)
+	cmd.Dir = dir
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("mount overlay on %s error %v: %s", target, err, output)
+	}
+	return nil
+}
+
+func (d *overlayDriver) Unmount(target string) error {
+	return unmount(target)
+}
+
+// Diff 打包 upperdir，TarLayer 把 overlayfs 的白障转换回 OCI 的形式
+func (d *overlayDriver) Diff(dir string, layers []string, w io.Writer) error {
+	return archive.TarLayer(filepath.Join(dir, "diff"), w)
+}
+
+func (d *overlayDriver) Remove(dir string) error {
+	return os.RemoveAll(dir)
+}
@@ -0,0 +1,312 @@
+package storage
+
+import (
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// ------------------------
+// 容器可写层的大小限制（run --storage-opt size=）
+// 可写层所在的文件系统启用了项目配额（xfs 的 pquota、ext4 的 prjquota）时，为可写层目录分配一个项目 ID 并设置配额；
+// 否则在可写层目录上挂载一个该大小的 ext4 镜像文件 <dir>.img（loop 设备）
+// 两种方式都在存储驱动创建可写层之前设置，驱动在受限的目录中创建 diff、work 等目录
+// ------------------------
+
+// 项目配额使用的 ioctl 和 quotactl 常量，golang.org/x/sys 当前的版本中没有定义
+const (
+	fsIocFsGetXattr     = 0x801c581f // FS_IOC_FSGETXATTR
+	fsIocFsSetXattr     = 0x401c5820 // FS_IOC_FSSETXATTR
+	fsXflagProjInherit  = 0x200      // FS_XFLAG_PROJINHERIT，子目录和文件继承项目 ID
+	qXSetQLim           = 0x5804     // Q_XSETQLIM
+	prjQuota            = 2          // PRJQUOTA
+	fsDquotVersion      = 1          // FS_DQUOT_VERSION
+	fsProjQuota         = 2          // FS_PROJ_QUOTA
+	fsDqBSoft           = 0x4        // FS_DQ_BSOFT
+	fsDqBHard           = 0x8        // FS_DQ_BHARD
+	quotaBlockSize      = 512        // 配额的块大小
+	firstProjectID      = 100000     // 为可写层分配的项目 ID 从这里开始，避免与宿主机上已有的项目冲突
+	backingFsBlockDev   = "backingFsBlockDev"
+	projectIDLock       = "projectID.lock" // 分配项目 ID 时加锁的文件，位于可写层的上级目录中
+	minLoopbackSize     = 16 << 20         // loop 设备上的 ext4 至少需要的大小
+	loopbackImageSuffix = ".img"
+)
+
+// fsxattr 对应内核的 struct fsxattr
+type fsxattr struct {
+	xflags     uint32
+	extsize    uint32
+	nextents   uint32
+	projid     uint32
+	cowextsize uint32
+	pad        [8]byte
+}
+
+// fsDiskQuota 对应内核的 struct fs_disk_quota
+type fsDiskQuota struct {
+	version      int8
+	flags        int8
+	fieldmask    uint16
+	id           uint32
+	blkHardlimit uint64
+	blkSoftlimit uint64
+	inoHardlimit uint64
+	inoSoftlimit uint64
+	bcount       uint64
+	icount       uint64
+	itimer       int32
+	btimer       int32
+	iwarns       uint16
+	bwarns       uint16
+	padding2     int32
+	rtbHardlimit uint64
+	rtbSoftlimit uint64
+	rtbcount     uint64
+	rtbtimer     int32
+	rtbwarns     uint16
+	padding3     int16
+	padding4     [8]byte
+}
+
+// sizeRegexp 是 size 选项的格式：数字加可选的单位 b、k、m、g、t，单位后可以带 b 或 ib
+var sizeRegexp = regexp.MustCompile(`^([0-9]+(?:\.[0-9]+)?)\s*([kKmMgGtT]?)(?:[iI]?[bB])?$`)
+
+// ParseSize 把 10G、512m 这样的大小转换为字节数，单位按 1024 进制计算
+func ParseSize(s string) (int64, error) {
+	matches := sizeRegexp.FindStringSubmatch(strings.TrimSpace(s))
+	if matches == nil {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	value, err := strconv.ParseFloat(matches[1], 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	unit := int64(1)
+	switch strings.ToLower(matches[2]) {
+	case "k":
+		unit = 1 << 10
+	case "m":
+		unit = 1 << 20
+	case "g":
+		unit = 1 << 30
+	case "t":
+		unit = 1 << 40
+	}
+	return int64(value * float64(unit)), nil
+}
+
+// SetQuota 创建可写层目录 dir 并把其中的数据限制在 size 字节以内
+// 优先使用项目配额，文件系统不支持时退回到 loop 设备
+func SetQuota(dir string, size int64) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	err := setProjectQuota(dir, size)
+	if err == nil {
+		return nil
+	}
+	log.Debugf("Project quota is not available for %s: %v, using a loopback filesystem", dir, err)
+	if lerr := setLoopbackQuota(dir, size); lerr != nil {
+		return fmt.Errorf("limit size of %s: project quota: %v; loopback: %v", dir, err, lerr)
+	}
+	return nil
+}
+
+// RemoveQuota 取消可写层目录 dir 的大小限制：卸载并删除 loop 设备的镜像文件，或清除项目配额
+// 之后由存储驱动删除目录本身
+func RemoveQuota(dir string) error {
+	image := dir + loopbackImageSuffix
+	if _, err := os.Stat(image); err == nil {
+		if isMountPoint(dir) {
+			if output, err := exec.Command("umount", dir).CombinedOutput(); err != nil {
+				return fmt.Errorf("unmount %s error %v: %s", dir, err, output)
+			}
+		}
+		return os.Remove(image)
+	}
+	projectID, err := getProjectID(dir)
+	if err != nil || projectID == 0 {
+		return nil
+	}
+	return setProjectLimit(filepath.Dir(dir), projectID, 0)
+}
+
+// setProjectQuota 为 dir 分配新的项目 ID 并设置配额
+// 项目 ID 按已有可写层目录的最大值递增分配，同时创建容器的多个进程需要在 parent 的锁文件上互斥，
+// 直到新的 ID 设置到 dir 上，否则可能分到相同的 ID 而共用配额
+func setProjectQuota(dir string, size int64) error {
+	parent := filepath.Dir(dir)
+	lock, err := os.OpenFile(filepath.Join(parent, projectIDLock), os.O_RDONLY|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+	if err := syscall.Flock(int(lock.Fd()), syscall.LOCK_EX); err != nil {
+		return fmt.Errorf("lock %s error %v", lock.Name(), err)
+	}
+
+	projectID, err := nextProjectID(parent)
+	if err != nil {
+		return err
+	}
+	// 先设置配额再设置目录的项目 ID，不支持项目配额时目录保持原样
+	if err := setProjectLimit(parent, projectID, size); err != nil {
+		return err
+	}
+	if err := setProjectID(dir, projectID); err != nil {
+		setProjectLimit(parent, projectID, 0)
+		return err
+	}
+	return nil
+}
+
+// nextProjectID 返回 parent 下的可写层目录还没有使用的项目 ID
+func nextProjectID(parent string) (uint32, error) {
+	files, err := ioutil.ReadDir(parent)
+	if err != nil {
+		return 0, err
+	}
+	next := uint32(firstProjectID)
+	for _, file := range files {
+		if !file.IsDir() {
+			continue
+		}
+		projectID, err := getProjectID(filepath.Join(parent, file.Name()))
+		if err != nil {
+			return 0, err
+		}
+		if projectID >= next {
+			next = projectID + 1
+		}
+	}
+	return next, nil
+}
+
+// getProjectID 读取目录的项目 ID
+func getProjectID(dir string) (uint32, error) {
+	f, err := os.Open(dir)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	attr := fsxattr{}
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), fsIocFsGetXattr, uintptr(unsafe.Pointer(&attr))); errno != 0 {
+		return 0, fmt.Errorf("get project id of %s error %v", dir, errno)
+	}
+	return attr.projid, nil
+}
+
+// setProjectID 设置目录的项目 ID，并让其中新建的文件和目录继承
+func setProjectID(dir string, projectID uint32) error {
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	attr := fsxattr{}
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), fsIocFsGetXattr, uintptr(unsafe.Pointer(&attr))); errno != 0 {
+		return fmt.Errorf("get project id of %s error %v", dir, errno)
+	}
+	attr.projid = projectID
+	attr.xflags |= fsXflagProjInherit
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), fsIocFsSetXattr, uintptr(unsafe.Pointer(&attr))); errno != 0 {
+		return fmt.Errorf("set project id of %s error %v", dir, errno)
+	}
+	return nil
+}
+
+// setProjectLimit 设置项目 projectID 的块配额，size 为 0 表示取消限制
+// quotactl 需要文件系统的块设备，在 parent 中创建一个与 parent 所在设备相同的设备文件
+func setProjectLimit(parent string, projectID uint32, size int64) error {
+	device, err := backingDevice(parent)
+	if err != nil {
+		return err
+	}
+	devicePtr, err := syscall.BytePtrFromString(device)
+	if err != nil {
+		return err
+	}
+	quota := fsDiskQuota{
+		version:      fsDquotVersion,
+		flags:        fsProjQuota,
+		fieldmask:    fsDqBSoft | fsDqBHard,
+		id:           projectID,
+		blkHardlimit: uint64(size / quotaBlockSize),
+		blkSoftlimit: uint64(size / quotaBlockSize),
+	}
+	cmd := uintptr(qXSetQLim<<8 | prjQuota)
+	if _, _, errno := syscall.Syscall6(syscall.SYS_QUOTACTL, cmd, uintptr(unsafe.Pointer(devicePtr)),
+		uintptr(projectID), uintptr(unsafe.Pointer(&quota)), 0, 0); errno != 0 {
+		return fmt.Errorf("set quota of project %d on %s error %v", projectID, parent, errno)
+	}
+	return nil
+}
+
+// backingDevice 返回 parent 中代表其所在文件系统的块设备文件，不存在时创建
+func backingDevice(parent string) (string, error) {
+	var stat syscall.Stat_t
+	if err := syscall.Stat(parent, &stat); err != nil {
+		return "", err
+	}
+	device := filepath.Join(parent, backingFsBlockDev)
+	var existing syscall.Stat_t
+	if err := syscall.Stat(device, &existing); err == nil {
+		if existing.Mode&syscall.S_IFMT == syscall.S_IFBLK && existing.Rdev == stat.Dev {
+			return device, nil
+		}
+		os.Remove(device)
+	}
+	if err := syscall.Mknod(device, syscall.S_IFBLK|0600, int(stat.Dev)); err != nil {
+		return "", fmt.Errorf("create block device %s error %v", device, err)
+	}
+	return device, nil
+}
+
+// setLoopbackQuota 创建 size 字节的稀疏镜像文件，格式化为 ext4 后通过 loop 设备挂载到 dir
+func setLoopbackQuota(dir string, size int64) error {
+	if size < minLoopbackSize {
+		return fmt.Errorf("size must be at least %dMB", minLoopbackSize>>20)
+	}
+	image := dir + loopbackImageSuffix
+	f, err := os.OpenFile(image, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	err = f.Truncate(size)
+	f.Close()
+	if err != nil {
+		os.Remove(image)
+		return err
+	}
+	if output, err := exec.Command("mkfs.ext4", "-q", "-F", "-m", "0", image).CombinedOutput(); err != nil {
+		os.Remove(image)
+		return fmt.Errorf("mkfs.ext4 %s error %v: %s", image, err, output)
+	}
+	if output, err := exec.Command("mount", "-o", "loop", image, dir).CombinedOutput(); err != nil {
+		os.Remove(image)
+		return fmt.Errorf("mount %s on %s error %v: %s", image, dir, err, output)
+	}
+	// ext4 的 lost+found 不属于可写层
+	os.RemoveAll(filepath.Join(dir, "lost+found"))
+	return nil
+}
+
+// isMountPoint 判断 dir 是否是挂载点
+func isMountPoint(dir string) bool {
+	var stat, parentStat syscall.Stat_t
+	if err := syscall.Stat(dir, &stat); err != nil {
+		return false
+	}
+	if err := syscall.Stat(filepath.Dir(dir), &parentStat); err != nil {
+		return false
+	}
+	return stat.Dev != parentStat.Dev
+}
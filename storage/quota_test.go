@@ -0,0 +1,43 @@
+package storage
+
+import "testing"
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    int64
+		wantErr bool
+	}{
+		{in: "0", want: 0},
+		{in: "1024", want: 1024},
+		{in: "100b", want: 100},
+		{in: "1k", want: 1 << 10},
+		{in: "512m", want: 512 << 20},
+		{in: "512M", want: 512 << 20},
+		{in: "10G", want: 10 << 30},
+		{in: "10GB", want: 10 << 30},
+		{in: "10GiB", want: 10 << 30},
+		{in: "1.5g", want: 3 << 29},
+		{in: "2t", want: 2 << 40},
+		{in: " 20 m ", want: 20 << 20},
+
+		{in: "", wantErr: true},
+		{in: "g", wantErr: true},
+		{in: "-1g", wantErr: true},
+		{in: "10x", wantErr: true},
+		{in: "1.g", wantErr: true},
+		{in: "10gg", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseSize(tt.in)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseSize(%q) = %d, want an error", tt.in, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("ParseSize(%q) = %d, %v, want %d", tt.in, got, err, tt.want)
+		}
+	}
+}
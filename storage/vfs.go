@@ -0,0 +1,252 @@
+package storage
+
+import (
+	"fmt"
+	"go-docker/archive"
+	"golang.org/x/sys/unix"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// vfsDriver 不依赖联合文件系统：创建容器时把镜像各层依次复制到可写层目录中的 diff，
+// 挂载时把 diff 绑定挂载到挂载点；commit 时逐个文件与镜像层比较得出改动
+// 占用空间和创建容器的时间都与镜像大小成正比，只在其他驱动不可用时使用
+type vfsDriver struct{}
+
+func (d *vfsDriver) Name() string { return "vfs" }
+
+func (d *vfsDriver) ApplyDiff(dir string, layer io.Reader) error {
+	return archive.Untar(layer, dir)
+}
+
+// CreateLayer 按从下到上的顺序把镜像各层复制到 diff 中，并应用各层的白障
+func (d *vfsDriver) CreateLayer(dir string, layers []string) error {
+	rootfs := filepath.Join(dir, "diff")
+	if err := createDirs(rootfs); err != nil {
+		return err
+	}
+	for _, layer := range layers {
+		if err := applyWhiteouts(layer, rootfs); err != nil {
+			return fmt.Errorf("apply whiteouts of %s error %v", layer, err)
+		}
+		if err := copyLayer(layer, rootfs); err != nil {
+			return fmt.Errorf("copy layer %s error %v", layer, err)
+		}
+	}
+	return nil
+}
+
+func (d *vfsDriver) Mount(dir string, layers []string, target string) error {
+	if output, err := exec.Command("mount", "--bind", filepath.Join(dir, "diff"), target).CombinedOutput(); err != nil {
+		return fmt.Errorf("bind mount %s error %v: %s", target, err, output)
+	}
+	return nil
+}
+
+func (d *vfsDriver) Unmount(target string) error {
+	return unmount(target)
+}
+
+func (d *vfsDriver) Diff(dir string, layers []string, w io.Writer) error {
+	rootfs := filepath.Join(dir, "diff")
+	changes, err := archive.Changes(layers, rootfs)
+	if err != nil {
+		return err
+	}
+	return archive.TarChanges(rootfs, changes, w)
+}
+
+func (d *vfsDriver) Remove(dir string) error {
+	return os.RemoveAll(dir)
+}
+
+// applyWhiteouts 按镜像层中的白障删除 rootfs 中下层的文件，不透明目录清空其中的内容
+func applyWhiteouts(layer, rootfs string) error {
+	return filepath.Walk(layer, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		base := info.Name()
+		if !strings.HasPrefix(base, archive.WhiteoutPrefix) {
+			return nil
+		}
+		rel, err := filepath.Rel(layer, filepath.Dir(path))
+		if err != nil {
+			return err
+		}
+		dir := filepath.Join(rootfs, rel)
+		switch {
+		case base == archive.WhiteoutOpaqueDir:
+			entries, err := os.ReadDir(dir)
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			for _, entry := range entries {
+				if err := os.RemoveAll(filepath.Join(dir, entry.Name())); err != nil {
+					return err
+				}
+			}
+		case strings.HasPrefix(base, archive.WhiteoutMetaPrefix):
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+		default:
+			if err := os.RemoveAll(filepath.Join(dir, strings.TrimPrefix(base, archive.WhiteoutPrefix))); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// copyLayer 把镜像层复制到 rootfs，覆盖下层的同名文件
+// 保留属主、权限、修改时间、扩展属性、设备文件和层内的硬链接；白障文件不复制
+func copyLayer(layer, rootfs string) error {
+	// 层内的硬链接，inode 到已复制的目标路径
+	links := map[uint64]string{}
+	// 目录的修改时间在复制完其中的文件后再设置
+	type dirTime struct {
+		path  string
+		mtime time.Time
+	}
+	var dirTimes []dirTime
+
+	err := filepath.Walk(layer, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(info.Name(), archive.WhiteoutPrefix) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		rel, err := filepath.Rel(layer, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(rootfs, rel)
+		stat := info.Sys().(*syscall.Stat_t)
+
+		// 类型不同的同名文件先删除；目录与目录合并
+		if existing, err := os.Lstat(target); err == nil && !(existing.IsDir() && info.IsDir()) {
+			if err := os.RemoveAll(target); err != nil {
+				return err
+			}
+		}
+
+		mode := info.Mode()
+		switch {
+		case mode.IsDir():
+			if rel != "." {
+				if err := os.Mkdir(target, 0700); err != nil && !os.IsExist(err) {
+					return err
+				}
+			}
+		case mode.IsRegular():
+			if first, ok := links[stat.Ino]; ok && stat.Nlink > 1 {
+				return os.Link(first, target)
+			}
+			if err := copyFile(path, target); err != nil {
+				return err
+			}
+			if stat.Nlink > 1 {
+				links[stat.Ino] = target
+			}
+		case mode&os.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			if err := os.Symlink(link, target); err != nil {
+				return err
+			}
+		default:
+			// 设备文件、管道和套接字
+			if err := unix.Mknod(target, stat.Mode, int(stat.Rdev)); err != nil {
+				return err
+			}
+		}
+
+		if err := os.Lchown(target, int(stat.Uid), int(stat.Gid)); err != nil {
+			return err
+		}
+		if err := copyXattrs(path, target); err != nil {
+			return err
+		}
+		if mode&os.ModeSymlink == 0 {
+			// chown 会清除 setuid/setgid 位，权限在 chown 之后设置
+			if err := os.Chmod(target, mode); err != nil {
+				return err
+			}
+		}
+		if mode.IsDir() {
+			dirTimes = append(dirTimes, dirTime{target, info.ModTime()})
+			return nil
+		}
+		ts := []unix.Timespec{unix.NsecToTimespec(info.ModTime().UnixNano()), unix.NsecToTimespec(info.ModTime().UnixNano())}
+		return unix.UtimesNanoAt(unix.AT_FDCWD, target, ts, unix.AT_SYMLINK_NOFOLLOW)
+	})
+	if err != nil {
+		return err
+	}
+	for i := len(dirTimes) - 1; i >= 0; i-- {
+		if err := os.Chtimes(dirTimes[i].path, dirTimes[i].mtime, dirTimes[i].mtime); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyFile 复制普通文件的内容
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// copyXattrs 复制扩展属性，例如文件能力 security.capability；文件系统不支持扩展属性时忽略
+func copyXattrs(src, dst string) error {
+	size, err := unix.Llistxattr(src, nil)
+	if err != nil || size == 0 {
+		return nil
+	}
+	buf := make([]byte, size)
+	size, err = unix.Llistxattr(src, buf)
+	if err != nil {
+		return nil
+	}
+	for _, name := range strings.Split(strings.TrimRight(string(buf[:size]), "\x00"), "\x00") {
+		if name == "" {
+			continue
+		}
+		vsize, err := unix.Lgetxattr(src, name, nil)
+		if err != nil {
+			continue
+		}
+		value := make([]byte, vsize)
+		if vsize, err = unix.Lgetxattr(src, name, value); err != nil {
+			continue
+		}
+		if err := unix.Lsetxattr(dst, name, value[:vsize], 0); err != nil && err != unix.ENOTSUP {
+			return fmt.Errorf("set xattr %s on %s error %v", name, dst, err)
+		}
+	}
+	return nil
+}
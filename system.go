@@ -0,0 +1,214 @@
+package main
+
+import (
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"go-docker/container"
+	"go-docker/image"
+	"go-docker/volume"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"text/tabwriter"
+)
+
+// diskUsage 是 system df 的一行
+type diskUsage struct {
+	kind        string
+	total       int
+	active      int
+	size        int64
+	reclaimable int64
+}
+
+// systemDiskUsage 输出镜像、解压的镜像层、容器可写层和数据卷占用的磁盘空间
+// 镜像：被容器使用的镜像为活跃镜像，可回收的是只被其他镜像使用的层，多个镜像共享的层只计一次
+// 解压的镜像层：被容器使用的为活跃的层，其余的层删除后可以从镜像层的 tar 包重新解压
+// 容器：运行中的容器为活跃容器，可回收的是已停止容器的可写层
+// 数据卷：所有数据卷，被容器使用的为活跃的，可回收的是没有容器使用的数据卷
+// verbose 时再逐个列出容器可写层的大小和 --storage-opt size 设置的上限
+func systemDiskUsage(verbose bool) error {
+	containers := listContainerInfos()
+	usedImages := map[string]bool{}
+	for _, info := range containers {
+		if info.ImageID != "" {
+			usedImages[info.ImageID] = true
+		}
+	}
+	refs, err := image.ContainerRefs()
+	if err != nil {
+		return err
+	}
+	// 容器使用的层，以及容器在各存储驱动上使用的解压目录
+	usedLayers := map[string]bool{}
+	usedExtracted := map[string]bool{}
+	for _, ref := range refs {
+		for _, diffID := range ref.Layers {
+			usedLayers[diffID] = true
+			usedExtracted[ref.Driver+"/"+diffID] = true
+		}
+	}
+
+	// 镜像
+	images := diskUsage{kind: "Images"}
+	ids, err := image.ListImages()
+	if err != nil {
+		return err
+	}
+	layerSizes := map[string]int64{}
+	for _, id := range ids {
+		img, err := image.GetImage(id)
+		if err != nil {
+			log.Errorf("Get image %s error %v", id, err)
+			continue
+		}
+		images.total++
+		if usedImages[id] {
+			images.active++
+		}
+		for _, diffID := range img.RootFS.DiffIDs {
+			if _, ok := layerSizes[diffID]; !ok {
+				layerSizes[diffID] = image.LayerSize(diffID)
+			}
+			if usedImages[id] {
+				usedLayers[diffID] = true
+			}
+		}
+	}
+	for diffID, size := range layerSizes {
+		images.size += size
+		if !usedLayers[diffID] {
+			images.reclaimable += size
+		}
+	}
+
+	// 解压的镜像层
+	layers := diskUsage{kind: "Extracted Layers"}
+	extracted, err := image.ExtractedLayers()
+	if err != nil {
+		return err
+	}
+	for _, layer := range extracted {
+		size := dirSize(layer.Path)
+		layers.total++
+		layers.size += size
+		if usedExtracted[layer.Driver+"/"+layer.DiffID] {
+			layers.active++
+		} else {
+			layers.reclaimable += size
+		}
+	}
+
+	// 容器可写层
+	writeLayers := diskUsage{kind: "Containers"}
+	for _, info := range containers {
+		size := dirSize(fmt.Sprintf(container.WriteLayerUrl, info.Name))
+		writeLayers.total++
+		writeLayers.size += size
+		if info.Status == container.RUNNING {
+			writeLayers.active++
+		} else {
+			writeLayers.reclaimable += size
+		}
+	}
+
+	// 数据卷：被容器（包括已停止的容器）使用的数据卷为活跃的，其余可以由 volume prune 回收
+	volumes := diskUsage{kind: "Local Volumes"}
+	allVolumes, warnings, err := volume.List()
+	if err != nil {
+		return err
+	}
+	for _, warning := range warnings {
+		log.Warnf("List volumes: %v", warning)
+	}
+	users := volumeUsers()
+	for _, v := range allVolumes {
+		size := dirSize(v.Mountpoint)
+		volumes.total++
+		volumes.size += size
+		if len(users[v.Name]) > 0 {
+			volumes.active++
+		} else {
+			volumes.reclaimable += size
+		}
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 12, 1, 3, ' ', 0)
+	fmt.Fprint(w, "TYPE\tTOTAL\tACTIVE\tSIZE\tRECLAIMABLE\n")
+	for _, usage := range []diskUsage{images, layers, writeLayers, volumes} {
+		percent := int64(0)
+		if usage.size > 0 {
+			percent = usage.reclaimable * 100 / usage.size
+		}
+		fmt.Fprintf(w, "%s\t%d\t%d\t%s\t%s (%d%%)\n", usage.kind, usage.total, usage.active,
+			humanSize(usage.size), humanSize(usage.reclaimable), percent)
+	}
+	if err := w.Flush(); err != nil {
+		log.Errorf("Flush error %v", err)
+		return err
+	}
+	if !verbose {
+		return nil
+	}
+
+	fmt.Fprint(os.Stdout, "\nContainers space usage:\n\n")
+	w = tabwriter.NewWriter(os.Stdout, 12, 1, 3, ' ', 0)
+	fmt.Fprint(w, "NAME\tSTATUS\tSIZE\tLIMIT\n")
+	for _, info := range containers {
+		limit := "-"
+		if info.StorageSize > 0 {
+			limit = humanSize(info.StorageSize)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", info.Name, info.Status,
+			humanSize(dirSize(fmt.Sprintf(container.WriteLayerUrl, info.Name))), limit)
+	}
+	if err := w.Flush(); err != nil {
+		log.Errorf("Flush error %v", err)
+		return err
+	}
+	return nil
+}
+
+// listContainerInfos 返回所有容器的信息
+func listContainerInfos() []*container.ContainerInfo {
+	dirURL := fmt.Sprintf(container.DefaultInfoLocation, "")
+	files, err := ioutil.ReadDir(dirURL)
+	if err != nil {
+		return nil
+	}
+	var containers []*container.ContainerInfo
+	for _, file := range files {
+		if file.Name() == "network" || file.Name() == "plugins" {
+			continue
+		}
+		containerInfo, err := getContainerInfo(file)
+		if err != nil {
+			continue
+		}
+		containers = append(containers, containerInfo)
+	}
+	return containers
+}
+
+// dirSize 返回目录实际占用的磁盘空间，同一 inode 的硬链接只计一次，目录不存在时返回 0
+func dirSize(dir string) int64 {
+	var size int64
+	seen := map[uint64]bool{}
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		stat, ok := info.Sys().(*syscall.Stat_t)
+		if !ok {
+			return nil
+		}
+		if seen[stat.Ino] {
+			return nil
+		}
+		seen[stat.Ino] = true
+		size += stat.Blocks * 512
+		return nil
+	})
+	return size
+}
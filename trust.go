@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"go-docker/image"
+	"go-docker/trust"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// verifyImage 在运行镜像之前按信任策略检查镜像的签名
+// 按 name:tag 或 name@digest 引用时只检查该仓库的要求，按镜像 ID 引用时检查镜像所属的所有仓库
+func verifyImage(imageName, id string) error {
+	names, err := imageRepositories(id)
+	if err != nil {
+		return err
+	}
+	if ref, err := image.ParseReference(imageName); err == nil {
+		for _, name := range names {
+			if name == ref.Name {
+				names = []string{ref.Name}
+				break
+			}
+		}
+	}
+	manifestDigest, err := image.ManifestDigest(id)
+	if err != nil {
+		return err
+	}
+	if err := trust.Verify(manifestDigest, names); err != nil {
+		log.Errorf("Verify image %s error %v", imageName, err)
+		return err
+	}
+	return nil
+}
+
+// imageRepositories 返回镜像所属的仓库名，不重复
+func imageRepositories(id string) ([]string, error) {
+	tags, err := image.RepoTags(id)
+	if err != nil {
+		return nil, err
+	}
+	digests, err := image.RepoDigests(id)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	seen := map[string]bool{}
+	for _, s := range append(tags, digests...) {
+		ref, err := image.ParseReference(s)
+		if err != nil || seen[ref.Name] {
+			continue
+		}
+		seen[ref.Name] = true
+		names = append(names, ref.Name)
+	}
+	return names, nil
+}
+
+// generateKey 生成签名密钥对，输出公钥文件路径
+func generateKey(name, dir string) error {
+	if dir == "" {
+		dir = "."
+	}
+	pubPath, err := trust.GenerateKey(name, dir)
+	if err != nil {
+		log.Errorf("Generate key %s error %v", name, err)
+		return err
+	}
+	fmt.Fprintf(os.Stdout, "Public key written to %s\n", pubPath)
+	return nil
+}
+
+// signImage 用私钥为 name:tag 引用的镜像签名，签名绑定仓库名和镜像 manifest 摘要
+func signImage(imageName, key string) error {
+	if strings.Contains(imageName, "@") {
+		return fmt.Errorf("sign requires a name[:tag] reference, got %s", imageName)
+	}
+	ref, err := image.ParseReference(imageName)
+	if err != nil {
+		return err
+	}
+	id, _, err := resolveImage(imageName)
+	if err != nil {
+		return err
+	}
+	priv, err := trust.LoadPrivateKey(key)
+	if err != nil {
+		log.Errorf("Load key %s error %v", key, err)
+		return err
+	}
+	manifestDigest, err := trust.Sign(id, ref.Name, priv)
+	if err != nil {
+		log.Errorf("Sign image %s error %v", imageName, err)
+		return err
+	}
+	fmt.Fprintf(os.Stdout, "Signed %s: %s\n", ref, manifestDigest)
+	return nil
+}
+
+// inspectTrust 输出镜像的所有签名
+func inspectTrust(imageName string) error {
+	id, _, err := resolveImage(imageName)
+	if err != nil {
+		return err
+	}
+	manifestDigest, err := image.ManifestDigest(id)
+	if err != nil {
+		return err
+	}
+	signatures, err := image.Signatures(manifestDigest)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stdout, "Manifest digest: %s\n", manifestDigest)
+	if len(signatures) == 0 {
+		fmt.Fprintln(os.Stdout, "No signatures")
+		return nil
+	}
+	w := tabwriter.NewWriter(os.Stdout, 12, 1, 3, ' ', 0)
+	fmt.Fprint(w, "KEY ID\tREPOSITORY\tSIGNED\n")
+	for _, s := range signatures {
+		payload := trust.Payload{}
+		if err := json.Unmarshal(s.Payload, &payload); err != nil {
+			log.Errorf("Parse signature payload error %v", err)
+			continue
+		}
+		signed := time.Unix(payload.Optional.Timestamp, 0).Format("2006-01-02 15:04:05")
+		fmt.Fprintf(w, "%s\t%s\t%s\n", image.ShortID(s.KeyID), payload.Critical.Identity.DockerReference, signed)
+	}
+	if err := w.Flush(); err != nil {
+		log.Errorf("Flush error %v", err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package trust
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// KeyDir 是 trust key generate 生成的私钥的存放目录
+var KeyDir = "/root/trust/private"
+
+// GenerateKey 生成 ed25519 密钥对：私钥保存为 KeyDir/<name>.key，公钥写到 dir/<name>.pub，返回公钥文件路径
+func GenerateKey(name, dir string) (string, error) {
+	if name == "" || strings.ContainsAny(name, "/\\") || strings.HasPrefix(name, ".") {
+		return "", fmt.Errorf("invalid key name %q", name)
+	}
+	privPath := filepath.Join(KeyDir, name+".key")
+	pubPath := filepath.Join(dir, name+".pub")
+	for _, path := range []string{privPath, pubPath} {
+		if _, err := os.Stat(path); err == nil {
+			return "", fmt.Errorf("key file %s already exists", path)
+		}
+	}
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return "", err
+	}
+	privDER, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		return "", err
+	}
+	pubDER, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(KeyDir, 0700); err != nil {
+		return "", err
+	}
+	if err := ioutil.WriteFile(privPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privDER}), 0600); err != nil {
+		return "", err
+	}
+	if err := ioutil.WriteFile(pubPath, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER}), 0644); err != nil {
+		return "", err
+	}
+	return pubPath, nil
+}
+
+// LoadPrivateKey 读取私钥，key 可以是私钥文件的路径或 KeyDir 中的密钥名
+func LoadPrivateKey(key string) (ed25519.PrivateKey, error) {
+	path := key
+	if _, err := os.Stat(path); err != nil && !strings.Contains(key, "/") {
+		path = filepath.Join(KeyDir, key+".key")
+	}
+	block, err := readPEM(path, "PRIVATE KEY")
+	if err != nil {
+		return nil, err
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parse private key %s error %v", path, err)
+	}
+	priv, ok := parsed.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("private key %s is not an ed25519 key", path)
+	}
+	return priv, nil
+}
+
+// LoadPublicKey 读取 PEM 格式的公钥文件
+func LoadPublicKey(path string) (ed25519.PublicKey, error) {
+	block, err := readPEM(path, "PUBLIC KEY")
+	if err != nil {
+		return nil, err
+	}
+	parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parse public key %s error %v", path, err)
+	}
+	pub, ok := parsed.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("public key %s is not an ed25519 key", path)
+	}
+	return pub, nil
+}
+
+// KeyID 返回公钥的 ID，即公钥的 sha256 摘要
+func KeyID(pub ed25519.PublicKey) string {
+	sum := sha256.Sum256(pub)
+	return hex.EncodeToString(sum[:])
+}
+
+// readPEM 读取文件中第一个指定类型的 PEM 块
+func readPEM(path, blockType string) (*pem.Block, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		var block *pem.Block
+		block, content = pem.Decode(content)
+		if block == nil {
+			return nil, fmt.Errorf("no %s found in %s", blockType, path)
+		}
+		if block.Type == blockType {
+			return block, nil
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package trust
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// PolicyPath 是信任策略文件的路径，文件不存在时接受所有镜像
+var PolicyPath = "/etc/mydocker/policy.json"
+
+// 策略要求的类型，与 containers/image 的 policy.json 一致
+const (
+	TypeAccept   = "insecureAcceptAnything" // 不检查签名
+	TypeReject   = "reject"                 // 拒绝所有镜像
+	TypeSignedBy = "signedBy"               // 要求有 Keys 中某个公钥的有效签名
+)
+
+// Requirement 是对一个仓库中的镜像的要求
+type Requirement struct {
+	Type string   `json:"type"`
+	Keys []string `json:"keys,omitempty"` // 受信任的公钥文件路径，Type 为 signedBy 时使用
+}
+
+// Policy 是信任策略，例如：
+//
+//	{
+//	  "default": {"type": "insecureAcceptAnything"},
+//	  "repositories": {
+//	    "registry.example.com/team": {"type": "signedBy", "keys": ["/etc/mydocker/keys/pipeline.pub"]}
+//	  }
+//	}
+//
+// 仓库名按镜像引用中的写法匹配，也匹配以它加 / 开头的仓库，取最长的匹配；都不匹配时使用 default
+type Policy struct {
+	Default      Requirement            `json:"default"`
+	Repositories map[string]Requirement `json:"repositories"`
+}
+
+// LoadPolicy 读取信任策略，策略文件不存在时返回接受所有镜像的策略
+func LoadPolicy() (*Policy, error) {
+	policy := &Policy{Default: Requirement{Type: TypeAccept}}
+	content, err := ioutil.ReadFile(PolicyPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return policy, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(content, policy); err != nil {
+		return nil, fmt.Errorf("parse trust policy %s error %v", PolicyPath, err)
+	}
+	requirements := []Requirement{policy.Default}
+	for _, req := range policy.Repositories {
+		requirements = append(requirements, req)
+	}
+	for _, req := range requirements {
+		switch req.Type {
+		case TypeAccept, TypeReject:
+		case TypeSignedBy:
+			if len(req.Keys) == 0 {
+				return nil, fmt.Errorf("invalid trust policy %s: signedBy requires keys", PolicyPath)
+			}
+		default:
+			return nil, fmt.Errorf("invalid trust policy %s: unknown requirement type %q", PolicyPath, req.Type)
+		}
+	}
+	return policy, nil
+}
+
+// requirement 返回仓库适用的要求
+func (p *Policy) requirement(name string) Requirement {
+	match := ""
+	for repo := range p.Repositories {
+		if (name == repo || strings.HasPrefix(name, repo+"/")) && len(repo) > len(match) {
+			match = repo
+		}
+	}
+	if match == "" {
+		return p.Default
+	}
+	return p.Repositories[match]
+}
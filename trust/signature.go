@@ -0,0 +1,130 @@
+package trust
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"fmt"
+	"go-docker/image"
+	"time"
+)
+
+// signatureType 是签名内容的类型
+const signatureType = "atomic container signature"
+
+// Payload 是被签名的内容，格式与 containers/image 的简单签名一致，把仓库名和镜像 manifest 摘要绑定在一起
+type Payload struct {
+	Critical struct {
+		Identity struct {
+			DockerReference string `json:"docker-reference"`
+		} `json:"identity"`
+		Image struct {
+			DockerManifestDigest string `json:"docker-manifest-digest"`
+		} `json:"image"`
+		Type string `json:"type"`
+	} `json:"critical"`
+	Optional struct {
+		Creator   string `json:"creator"`
+		Timestamp int64  `json:"timestamp"`
+	} `json:"optional"`
+}
+
+// Sign 用私钥为仓库 name 中的镜像 id 签名并保存签名，返回镜像 manifest 的摘要
+func Sign(id, name string, priv ed25519.PrivateKey) (string, error) {
+	manifestDigest, err := image.ManifestDigest(id)
+	if err != nil {
+		return "", err
+	}
+	payload := Payload{}
+	payload.Critical.Identity.DockerReference = name
+	payload.Critical.Image.DockerManifestDigest = manifestDigest
+	payload.Critical.Type = signatureType
+	payload.Optional.Creator = "mydocker"
+	payload.Optional.Timestamp = time.Now().Unix()
+	content, err := json.Marshal(payload)
+	if err != nil {
+		return "", err
+	}
+	signature := image.Signature{
+		KeyID:     KeyID(priv.Public().(ed25519.PublicKey)),
+		Payload:   content,
+		Signature: ed25519.Sign(priv, content),
+	}
+	if err := image.AddSignatures(manifestDigest, []image.Signature{signature}); err != nil {
+		return "", err
+	}
+	return manifestDigest, nil
+}
+
+// Verify 按信任策略检查镜像的签名，names 为镜像所属的仓库名，每个仓库的要求都必须满足
+// 镜像没有仓库名（按 ID 引用的未标记镜像）时使用 default 要求，签名中的仓库名不检查
+func Verify(manifestDigest string, names []string) error {
+	policy, err := LoadPolicy()
+	if err != nil {
+		return err
+	}
+	if len(names) == 0 {
+		return check(policy.Default, "", manifestDigest)
+	}
+	for _, name := range names {
+		if err := check(policy.requirement(name), name, manifestDigest); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// check 检查镜像是否满足一个要求
+func check(req Requirement, name, manifestDigest string) error {
+	display := name
+	if display == "" {
+		display = manifestDigest
+	}
+	switch req.Type {
+	case TypeAccept:
+		return nil
+	case TypeReject:
+		return fmt.Errorf("image %s is rejected by the trust policy", display)
+	}
+
+	trusted := map[string]ed25519.PublicKey{}
+	for _, path := range req.Keys {
+		pub, err := LoadPublicKey(path)
+		if err != nil {
+			return fmt.Errorf("load trusted key error %v", err)
+		}
+		trusted[KeyID(pub)] = pub
+	}
+	signatures, err := image.Signatures(manifestDigest)
+	if err != nil {
+		return err
+	}
+	var invalid error
+	for _, s := range signatures {
+		pub, ok := trusted[s.KeyID]
+		if !ok {
+			continue
+		}
+		if !ed25519.Verify(pub, s.Payload, s.Signature) {
+			invalid = fmt.Errorf("invalid signature by key %s", s.KeyID[:12])
+			continue
+		}
+		payload := Payload{}
+		if err := json.Unmarshal(s.Payload, &payload); err != nil || payload.Critical.Type != signatureType {
+			invalid = fmt.Errorf("invalid signature payload by key %s", s.KeyID[:12])
+			continue
+		}
+		if payload.Critical.Image.DockerManifestDigest != manifestDigest {
+			invalid = fmt.Errorf("signature by key %s is for manifest %s", s.KeyID[:12], payload.Critical.Image.DockerManifestDigest)
+			continue
+		}
+		if name != "" && payload.Critical.Identity.DockerReference != name {
+			invalid = fmt.Errorf("signature by key %s is for repository %s", s.KeyID[:12], payload.Critical.Identity.DockerReference)
+			continue
+		}
+		return nil
+	}
+	if invalid != nil {
+		return fmt.Errorf("image %s is not trusted: %v", display, invalid)
+	}
+	return fmt.Errorf("image %s is not trusted: no signature by a trusted key", display)
+}
@@ -0,0 +1,350 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"go-docker/archive"
+	"go-docker/container"
+	"go-docker/volume"
+	"io"
+	"os"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+)
+
+// prepareMounts 在启动容器之前准备挂载的源：创建不存在的数据卷和 -v 指定的宿主机目录
+// 匿名数据卷在这里生成名称；数据卷由驱动为容器 containerName 挂载，名称、驱动和挂载路径记录在 mounts 中
+// 返回错误时已经挂载的数据卷由调用方通过 releaseVolumes 释放
+func prepareMounts(containerName string, mounts []container.MountPoint) error {
+	for i := range mounts {
+		m := &mounts[i]
+		switch m.Type {
+		case container.MountTypeVolume:
+			if m.Anonymous && m.Name == "" {
+				m.Name = volume.NewName()
+			}
+			v, err := volume.Create(m.Name, m.Driver, nil, nil, m.Anonymous)
+			if err != nil {
+				log.Errorf("Create volume %s error %v", m.Name, err)
+				return err
+			}
+			m.Driver = v.Driver
+			source, err := volume.Mount(m.Name, containerName)
+			if err != nil {
+				log.Errorf("Mount volume %s error %v", m.Name, err)
+				return err
+			}
+			m.Source = source
+		case container.MountTypeBind:
+			// 与 docker 一致，-v 的宿主机路径不存在时创建为目录；--mount 在解析时已检查源路径存在
+			if exist, _ := container.PathExists(m.Source); !exist {
+				if err := os.MkdirAll(m.Source, 0755); err != nil {
+					log.Errorf("Mkdir bind source %s error %v", m.Source, err)
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// releaseVolumes 通知数据卷的驱动容器 containerName 不再使用数据卷，并删除容器的匿名数据卷
+func releaseVolumes(containerName string, mounts []container.MountPoint) {
+	for _, m := range mounts {
+		if m.Type != container.MountTypeVolume || m.Source == "" {
+			continue
+		}
+		if err := volume.Unmount(m.Name, m.Driver, containerName); err != nil {
+			log.Errorf("Unmount volume %s error %v", m.Name, err)
+		}
+	}
+	removeAnonymousVolumes(mounts)
+}
+
+// removeAnonymousVolumes 删除容器的匿名数据卷，具名数据卷保留
+// 调用时容器信息已经删除或尚未记录；通过 --volumes-from 仍被其他容器使用的匿名数据卷保留，由最后一个使用它的容器删除
+func removeAnonymousVolumes(mounts []container.MountPoint) {
+	var users map[string][]string
+	for _, m := range mounts {
+		if m.Type != container.MountTypeVolume || !m.Anonymous || m.Name == "" {
+			continue
+		}
+		if users == nil {
+			users = volumeUsers()
+		}
+		if len(users[m.Name]) > 0 {
+			continue
+		}
+		if err := volume.Remove(m.Name); err != nil {
+			log.Errorf("Remove volume %s error %v", m.Name, err)
+		}
+	}
+}
+
+// volumesFrom 解析 --volumes-from 的参数 容器名[:ro|rw]，把源容器记录的数据卷和 bind 挂载加入 mounts
+// 指定 ro 或 rw 时覆盖源挂载的读写模式；目标路径已经由 -v、--mount 或之前的 --volumes-from 占用的挂载跳过
+func volumesFrom(specs []string, mounts []container.MountPoint) ([]container.MountPoint, error) {
+	used := map[string]bool{}
+	for _, m := range mounts {
+		used[m.Destination] = true
+	}
+	for _, spec := range specs {
+		parts := strings.Split(spec, ":")
+		if len(parts) > 2 || parts[0] == "" {
+			return nil, fmt.Errorf("invalid volumes-from specification %q", spec)
+		}
+		mode := ""
+		if len(parts) == 2 {
+			mode = parts[1]
+			if mode != "ro" && mode != "rw" {
+				return nil, fmt.Errorf("invalid volumes-from specification %q: invalid mode %q", spec, mode)
+			}
+		}
+		info, err := getContainerInfoByName(parts[0])
+		if err != nil {
+			return nil, fmt.Errorf("no such container: %s", parts[0])
+		}
+		for _, m := range info.Mounts {
+			if m.Type == container.MountTypeTmpfs || used[m.Destination] {
+				continue
+			}
+			if mode != "" {
+				m.ReadOnly = mode == "ro"
+			}
+			m.Options = append([]string{}, m.Options...)
+			if m.Type == container.MountTypeVolume {
+				// 数据卷的挂载路径由驱动为新容器重新挂载得到
+				m.Source = ""
+			}
+			used[m.Destination] = true
+			mounts = append(mounts, m)
+		}
+	}
+	return mounts, nil
+}
+
+// populateVolumes 把镜像中挂载目标路径下的内容复制到空的数据卷中，与 docker 一致
+// 在容器的 rootfs 挂载之后、init 进程挂载数据卷之前调用；指定了 nocopy 的数据卷不复制
+func populateVolumes(mounts []container.MountPoint, rootfs string) error {
+	for _, m := range mounts {
+		if m.Type != container.MountTypeVolume || m.NoCopy {
+			continue
+		}
+		src, err := container.ResolveInRootfs(rootfs, m.Destination)
+		if err != nil {
+			return err
+		}
+		info, err := os.Stat(src)
+		if err != nil || !info.IsDir() {
+			continue
+		}
+		if empty, err := isEmptyDir(m.Source); err != nil || !empty {
+			continue
+		}
+		if err := copyVolumeContent(src, m.Source, info); err != nil {
+			log.Errorf("Populate volume %s from %s error %v", m.Name, m.Destination, err)
+			return err
+		}
+	}
+	return nil
+}
+
+// copyVolumeContent 把 src 目录的内容复制到 dest，保留属主、权限、扩展属性和硬链接，dest 的属主和权限与 src 相同
+func copyVolumeContent(src, dest string, info os.FileInfo) error {
+	r, w := io.Pipe()
+	go func() {
+		w.CloseWithError(archive.TarLayer(src, w))
+	}()
+	if err := archive.Untar(r, dest); err != nil {
+		r.CloseWithError(err)
+		return err
+	}
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		if err := os.Lchown(dest, int(stat.Uid), int(stat.Gid)); err != nil {
+			return err
+		}
+	}
+	return os.Chmod(dest, info.Mode())
+}
+
+// isEmptyDir 判断目录是否为空
+func isEmptyDir(dir string) (bool, error) {
+	f, err := os.Open(dir)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	names, err := f.Readdirnames(1)
+	if err == io.EOF {
+		return true, nil
+	}
+	return len(names) == 0, err
+}
+
+// volumeUsers 返回使用各个数据卷的容器名称，按数据卷名称索引
+func volumeUsers() map[string][]string {
+	users := map[string][]string{}
+	for _, info := range listContainerInfos() {
+		for _, m := range info.Mounts {
+			if m.Type == container.MountTypeVolume && m.Name != "" {
+				users[m.Name] = append(users[m.Name], info.Name)
+			}
+		}
+	}
+	return users
+}
+
+// parseLabels 解析 --label、--opt 的 key=value 参数
+func parseLabels(labels []string) (map[string]string, error) {
+	result := map[string]string{}
+	for _, label := range labels {
+		kv := strings.SplitN(label, "=", 2)
+		if kv[0] == "" {
+			return nil, fmt.Errorf("invalid label %q", label)
+		}
+		if len(kv) == 1 {
+			kv = append(kv, "")
+		}
+		result[kv[0]] = kv[1]
+	}
+	return result, nil
+}
+
+// createVolume 用驱动 driver 创建具名数据卷，没有指定名称时生成随机名称，输出数据卷名称
+// opts 是传给驱动的 key=value 选项；与 docker 一致，数据卷已存在时不报错，直接输出名称
+func createVolume(name, driver string, opts, labels []string) error {
+	if name == "" {
+		name = volume.NewName()
+	}
+	optMap, err := parseLabels(opts)
+	if err != nil {
+		return err
+	}
+	labelMap, err := parseLabels(labels)
+	if err != nil {
+		return err
+	}
+	v, err := volume.Create(name, driver, optMap, labelMap, false)
+	if err != nil {
+		log.Errorf("Create volume %s error %v", name, err)
+		return err
+	}
+	fmt.Fprintln(os.Stdout, v.Name)
+	return nil
+}
+
+// listVolumes 列出所有数据卷，quiet 时只输出名称
+func listVolumes(quiet bool) error {
+	volumes, warnings, err := volume.List()
+	if err != nil {
+		log.Errorf("List volumes error %v", err)
+		return err
+	}
+	for _, warning := range warnings {
+		log.Warnf("List volumes: %v", warning)
+	}
+	if quiet {
+		for _, v := range volumes {
+			fmt.Fprintln(os.Stdout, v.Name)
+		}
+		return nil
+	}
+	w := tabwriter.NewWriter(os.Stdout, 12, 1, 3, ' ', 0)
+	fmt.Fprint(w, "DRIVER\tVOLUME NAME\n")
+	for _, v := range volumes {
+		fmt.Fprintf(w, "%s\t%s\n", v.Driver, v.Name)
+	}
+	if err := w.Flush(); err != nil {
+		log.Errorf("Flush error %v", err)
+		return err
+	}
+	return nil
+}
+
+// volumeInspectInfo 是 volume inspect 的输出：数据卷的元数据加上使用它的容器
+type volumeInspectInfo struct {
+	*volume.Volume
+	UsedBy []string `json:"UsedBy"`
+}
+
+// inspectVolumes 以 JSON 数组输出数据卷的详细信息
+func inspectVolumes(names []string) error {
+	users := volumeUsers()
+	infos := []volumeInspectInfo{}
+	for _, name := range names {
+		v, err := volume.Get(name)
+		if err != nil {
+			return err
+		}
+		usedBy := users[name]
+		if usedBy == nil {
+			usedBy = []string{}
+		}
+		infos = append(infos, volumeInspectInfo{Volume: v, UsedBy: usedBy})
+	}
+	infoJson, err := json.MarshalIndent(infos, "", "    ")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(os.Stdout, string(infoJson))
+	return nil
+}
+
+// removeVolumes 删除数据卷，仍被容器（包括已停止的容器）使用的数据卷不能删除
+func removeVolumes(names []string) error {
+	users := volumeUsers()
+	var failed []string
+	for _, name := range names {
+		if !volume.Exists(name) {
+			log.Errorf("No such volume: %s", name)
+			failed = append(failed, name)
+			continue
+		}
+		if len(users[name]) > 0 {
+			log.Errorf("Remove volume %s error: volume is in use by containers %s", name, strings.Join(users[name], ", "))
+			failed = append(failed, name)
+			continue
+		}
+		if err := volume.Remove(name); err != nil {
+			log.Errorf("Remove volume %s error %v", name, err)
+			failed = append(failed, name)
+			continue
+		}
+		fmt.Fprintln(os.Stdout, name)
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to remove volumes: %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// pruneVolumes 删除没有被任何容器使用的匿名数据卷，all 时也删除未使用的具名数据卷
+func pruneVolumes(all bool) error {
+	volumes, warnings, err := volume.List()
+	if err != nil {
+		log.Errorf("List volumes error %v", err)
+		return err
+	}
+	for _, warning := range warnings {
+		log.Warnf("List volumes: %v", warning)
+	}
+	users := volumeUsers()
+	var reclaimed int64
+	fmt.Fprintln(os.Stdout, "Deleted Volumes:")
+	for _, v := range volumes {
+		if len(users[v.Name]) > 0 || (!v.Anonymous && !all) {
+			continue
+		}
+		size := dirSize(v.Mountpoint)
+		if err := volume.Remove(v.Name); err != nil {
+			log.Errorf("Remove volume %s error %v", v.Name, err)
+			continue
+		}
+		reclaimed += size
+		fmt.Fprintln(os.Stdout, v.Name)
+	}
+	fmt.Fprintf(os.Stdout, "\nTotal reclaimed space: %s\n", humanSize(reclaimed))
+	return nil
+}
@@ -0,0 +1,195 @@
+package volume
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ------------------------
+// 数据卷插件：独立进程实现的数据卷驱动，在 PluginDir 下监听 <驱动名>.sock
+// 协议与 docker 的 volume plugin 一致：通过 unix socket 发送 HTTP POST 请求，请求和响应都是 JSON，
+// 响应中 Err 不为空表示失败
+//   /Plugin.Activate        握手，响应 {"Implements": ["VolumeDriver"]}
+//   /VolumeDriver.Create    {"Name", "Opts"}
+//   /VolumeDriver.Remove    {"Name"}
+//   /VolumeDriver.Mount     {"Name", "ID"}，响应 {"Mountpoint"}，ID 为使用数据卷的容器
+//   /VolumeDriver.Unmount   {"Name", "ID"}
+//   /VolumeDriver.Path      {"Name"}，响应 {"Mountpoint"}
+//   /VolumeDriver.List      {}，响应 {"Volumes": [{"Name", "Mountpoint"}]}
+// ------------------------
+
+// PluginDir 是数据卷插件 socket 所在的目录
+var PluginDir = "/var/run/mydocker/plugins"
+
+// pluginContentType 是插件协议请求的 Content-Type
+const pluginContentType = "application/vnd.docker.plugins.v1.2+json"
+
+// pluginTimeout 是一次插件请求的超时时间，挂载可能需要较长时间
+const pluginTimeout = 2 * time.Minute
+
+// Plugin 是一个数据卷插件的客户端
+type Plugin struct {
+	Name   string // 驱动名称
+	Socket string // 插件监听的 unix socket
+	client *http.Client
+}
+
+// pluginResponse 是插件响应中共有的字段
+type pluginResponse struct {
+	Err string `json:"Err"`
+}
+
+// PluginVolume 是插件 List 响应中的数据卷
+type PluginVolume struct {
+	Name       string `json:"Name"`
+	Mountpoint string `json:"Mountpoint"`
+}
+
+// Plugins 返回 PluginDir 下所有插件的驱动名称
+func Plugins() ([]string, error) {
+	files, err := ioutil.ReadDir(PluginDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var names []string
+	for _, file := range files {
+		if file.Mode()&os.ModeSocket != 0 && strings.HasSuffix(file.Name(), ".sock") {
+			names = append(names, strings.TrimSuffix(file.Name(), ".sock"))
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// GetPlugin 连接名为 name 的数据卷插件，并确认它实现了数据卷驱动
+func GetPlugin(name string) (*Plugin, error) {
+	if err := ValidateName(name); err != nil {
+		return nil, fmt.Errorf("invalid volume driver name %q", name)
+	}
+	socket := filepath.Join(PluginDir, name+".sock")
+	if _, err := os.Stat(socket); err != nil {
+		return nil, fmt.Errorf("volume driver %s not found: no socket at %s", name, socket)
+	}
+	p := &Plugin{
+		Name:   name,
+		Socket: socket,
+		client: &http.Client{
+			Timeout: pluginTimeout,
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+				},
+			},
+		},
+	}
+	activate := struct {
+		Implements []string `json:"Implements"`
+	}{}
+	if err := p.call("Plugin.Activate", struct{}{}, &activate); err != nil {
+		return nil, err
+	}
+	for _, i := range activate.Implements {
+		if i == "VolumeDriver" {
+			return p, nil
+		}
+	}
+	return nil, fmt.Errorf("plugin %s does not implement VolumeDriver", name)
+}
+
+// call 向插件发送请求，resp 必须是带有 Err 字段的结构体指针或 nil
+func (p *Plugin) call(method string, req, resp interface{}) error {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	// 地址中的主机名不会被使用，连接总是发往插件的 socket
+	r, err := p.client.Post("http://plugin/"+method, pluginContentType, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("volume plugin %s: %s: %v", p.Name, method, err)
+	}
+	defer r.Body.Close()
+	content, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return fmt.Errorf("volume plugin %s: %s: %v", p.Name, method, err)
+	}
+	result := pluginResponse{}
+	if err := json.Unmarshal(content, &result); err != nil && r.StatusCode == http.StatusOK {
+		return fmt.Errorf("volume plugin %s: %s: invalid response %v", p.Name, method, err)
+	}
+	if result.Err != "" {
+		return fmt.Errorf("volume plugin %s: %s: %s", p.Name, method, result.Err)
+	}
+	if r.StatusCode != http.StatusOK {
+		return fmt.Errorf("volume plugin %s: %s: %s", p.Name, method, r.Status)
+	}
+	if resp != nil {
+		if err := json.Unmarshal(content, resp); err != nil {
+			return fmt.Errorf("volume plugin %s: %s: invalid response %v", p.Name, method, err)
+		}
+	}
+	return nil
+}
+
+// Create 让插件创建数据卷
+func (p *Plugin) Create(name string, opts map[string]string) error {
+	return p.call("VolumeDriver.Create", map[string]interface{}{"Name": name, "Opts": opts}, nil)
+}
+
+// Remove 让插件删除数据卷
+func (p *Plugin) Remove(name string) error {
+	return p.call("VolumeDriver.Remove", map[string]string{"Name": name}, nil)
+}
+
+// Mount 让插件为容器 id 挂载数据卷，返回宿主机上的挂载路径
+func (p *Plugin) Mount(name, id string) (string, error) {
+	resp := struct {
+		Mountpoint string `json:"Mountpoint"`
+	}{}
+	if err := p.call("VolumeDriver.Mount", map[string]string{"Name": name, "ID": id}, &resp); err != nil {
+		return "", err
+	}
+	if !filepath.IsAbs(resp.Mountpoint) {
+		return "", fmt.Errorf("volume plugin %s: mountpoint %q of volume %s is not an absolute path", p.Name, resp.Mountpoint, name)
+	}
+	return resp.Mountpoint, nil
+}
+
+// Unmount 通知插件容器 id 不再使用数据卷
+func (p *Plugin) Unmount(name, id string) error {
+	return p.call("VolumeDriver.Unmount", map[string]string{"Name": name, "ID": id}, nil)
+}
+
+// Path 返回数据卷在宿主机上的挂载路径，没有挂载时可能为空
+func (p *Plugin) Path(name string) (string, error) {
+	resp := struct {
+		Mountpoint string `json:"Mountpoint"`
+	}{}
+	if err := p.call("VolumeDriver.Path", map[string]string{"Name": name}, &resp); err != nil {
+		return "", err
+	}
+	return resp.Mountpoint, nil
+}
+
+// List 返回插件管理的所有数据卷
+func (p *Plugin) List() ([]PluginVolume, error) {
+	resp := struct {
+		Volumes []PluginVolume `json:"Volumes"`
+	}{}
+	if err := p.call("VolumeDriver.List", struct{}{}, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Volumes, nil
+}
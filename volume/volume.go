@@ -0,0 +1,296 @@
+package volume
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"time"
+)
+
+// ------------------------
+// 数据卷：由 mydocker 管理的目录，挂载到容器中保存需要在容器删除后保留的数据
+//   <Root>/<name>/_data          数据卷的内容，挂载到容器中，仅 local 驱动
+//   <Root>/<name>/volume.json    数据卷的元数据：驱动、选项、标签、创建时间等
+// 其他驱动的数据卷由数据卷插件管理，见 plugin.go
+// 使用数据卷的容器由容器信息中的挂载记录得到，见 main 包的 volumeUsers
+// ------------------------
+
+// Root 是数据卷的存放目录
+var Root = "/root/volumes"
+
+// DefaultDriver 是数据卷默认的驱动，数据保存在 Root 下
+const DefaultDriver = "local"
+
+// nameRegexp 是数据卷名称的格式，与 docker 一致
+var nameRegexp = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]+$`)
+
+// Volume 是数据卷的元数据
+type Volume struct {
+	Name       string            `json:"Name"`
+	Driver     string            `json:"Driver"`
+	Mountpoint string            `json:"Mountpoint"` // 数据卷的数据目录，插件的数据卷为插件返回的挂载路径
+	CreatedAt  time.Time         `json:"CreatedAt"`
+	Labels     map[string]string `json:"Labels"`
+	Options    map[string]string `json:"Options"`   // 传给驱动的选项
+	Anonymous  bool              `json:"Anonymous"` // 是否为 run 时自动创建的匿名数据卷
+}
+
+// ValidateName 检查数据卷名称是否合法
+func ValidateName(name string) error {
+	if !nameRegexp.MatchString(name) {
+		return fmt.Errorf("invalid volume name %q, only [a-zA-Z0-9][a-zA-Z0-9_.-] are allowed", name)
+	}
+	return nil
+}
+
+// Path 返回数据卷的数据目录
+func Path(name string) string {
+	return filepath.Join(Root, name, "_data")
+}
+
+func metadataPath(name string) string {
+	return filepath.Join(Root, name, "volume.json")
+}
+
+// Exists 判断数据卷是否存在
+func Exists(name string) bool {
+	if _, err := os.Stat(metadataPath(name)); err == nil {
+		return true
+	}
+	_, err := os.Stat(Path(name))
+	return err == nil
+}
+
+// Create 用驱动 driver 创建数据卷，driver 为空时使用 local；已存在时返回已有的数据卷，此时 driver 为空表示不限驱动
+// opts 是传给驱动的选项，local 驱动不支持选项
+func Create(name, driver string, opts, labels map[string]string, anonymous bool) (*Volume, error) {
+	if err := ValidateName(name); err != nil {
+		return nil, err
+	}
+	if Exists(name) {
+		v, err := Get(name)
+		if err != nil {
+			return nil, err
+		}
+		if driver != "" && v.Driver != driver {
+			return nil, fmt.Errorf("volume %s already exists with driver %s", name, v.Driver)
+		}
+		return v, nil
+	}
+	if driver == "" {
+		driver = DefaultDriver
+	}
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	if opts == nil {
+		opts = map[string]string{}
+	}
+	v := &Volume{
+		Name:      name,
+		Driver:    driver,
+		CreatedAt: time.Now().UTC(),
+		Labels:    labels,
+		Options:   opts,
+		Anonymous: anonymous,
+	}
+	if driver == DefaultDriver {
+		if len(opts) > 0 {
+			return nil, fmt.Errorf("the %s volume driver does not support options", DefaultDriver)
+		}
+		v.Mountpoint = Path(name)
+		if err := os.MkdirAll(v.Mountpoint, 0755); err != nil {
+			return nil, err
+		}
+	} else {
+		plugin, err := GetPlugin(driver)
+		if err != nil {
+			return nil, err
+		}
+		if err := plugin.Create(name, opts); err != nil {
+			return nil, err
+		}
+		if err := os.MkdirAll(filepath.Join(Root, name), 0700); err != nil {
+			plugin.Remove(name)
+			return nil, err
+		}
+	}
+	if err := writeMetadata(v); err != nil {
+		if driver != DefaultDriver {
+			if plugin, perr := GetPlugin(driver); perr == nil {
+				plugin.Remove(name)
+			}
+		}
+		os.RemoveAll(filepath.Join(Root, name))
+		return nil, err
+	}
+	return v, nil
+}
+
+// writeMetadata 保存数据卷的元数据，插件数据卷的挂载路径由插件决定，不保存
+func writeMetadata(v *Volume) error {
+	saved := *v
+	if saved.Driver != DefaultDriver {
+		saved.Mountpoint = ""
+	}
+	content, err := json.Marshal(&saved)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(metadataPath(v.Name), content, 0600)
+}
+
+// Get 返回数据卷的元数据；没有元数据的数据卷（之前的版本创建的）按 local 驱动处理
+// 插件的数据卷向插件查询当前的挂载路径，插件不可用时挂载路径为空
+func Get(name string) (*Volume, error) {
+	if err := ValidateName(name); err != nil {
+		return nil, err
+	}
+	if !Exists(name) {
+		return nil, fmt.Errorf("no such volume: %s", name)
+	}
+	v := &Volume{Name: name, Driver: DefaultDriver, Mountpoint: Path(name)}
+	if info, err := os.Stat(filepath.Join(Root, name)); err == nil {
+		v.CreatedAt = info.ModTime().UTC()
+	}
+	content, err := ioutil.ReadFile(metadataPath(name))
+	if err == nil {
+		if err := json.Unmarshal(content, v); err != nil {
+			return nil, fmt.Errorf("parse metadata of volume %s error %v", name, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	if v.Labels == nil {
+		v.Labels = map[string]string{}
+	}
+	if v.Options == nil {
+		v.Options = map[string]string{}
+	}
+	if v.Driver == DefaultDriver {
+		v.Mountpoint = Path(name)
+	} else if plugin, err := GetPlugin(v.Driver); err == nil {
+		v.Mountpoint, _ = plugin.Path(name)
+	}
+	return v, nil
+}
+
+// List 按名称顺序返回所有数据卷；插件中存在但不是由 mydocker 创建的数据卷在这里记录元数据，之后可以正常使用和删除
+// 不可用的插件被跳过，错误通过 warnings 返回
+func List() ([]*Volume, []error, error) {
+	files, err := ioutil.ReadDir(Root)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, nil, err
+	}
+	var volumes []*Volume
+	seen := map[string]bool{}
+	for _, file := range files {
+		if !file.IsDir() || !Exists(file.Name()) {
+			continue
+		}
+		v, err := Get(file.Name())
+		if err != nil {
+			return nil, nil, err
+		}
+		seen[v.Name] = true
+		volumes = append(volumes, v)
+	}
+
+	var warnings []error
+	plugins, err := Plugins()
+	if err != nil {
+		warnings = append(warnings, err)
+	}
+	for _, name := range plugins {
+		plugin, err := GetPlugin(name)
+		if err != nil {
+			warnings = append(warnings, err)
+			continue
+		}
+		pluginVolumes, err := plugin.List()
+		if err != nil {
+			warnings = append(warnings, err)
+			continue
+		}
+		for _, pv := range pluginVolumes {
+			if seen[pv.Name] || ValidateName(pv.Name) != nil {
+				continue
+			}
+			seen[pv.Name] = true
+			v := &Volume{Name: pv.Name, Driver: name, Mountpoint: pv.Mountpoint, CreatedAt: time.Now().UTC(),
+				Labels: map[string]string{}, Options: map[string]string{}}
+			if err := os.MkdirAll(filepath.Join(Root, v.Name), 0700); err != nil {
+				return nil, nil, err
+			}
+			if err := writeMetadata(v); err != nil {
+				return nil, nil, err
+			}
+			volumes = append(volumes, v)
+		}
+	}
+	sort.Slice(volumes, func(i, j int) bool { return volumes[i].Name < volumes[j].Name })
+	return volumes, warnings, nil
+}
+
+// Remove 删除数据卷及其内容，插件的数据卷由插件删除；调用方负责检查数据卷没有被容器使用
+func Remove(name string) error {
+	if err := ValidateName(name); err != nil {
+		return err
+	}
+	v, err := Get(name)
+	if err != nil {
+		return err
+	}
+	if v.Driver != DefaultDriver {
+		plugin, err := GetPlugin(v.Driver)
+		if err != nil {
+			return err
+		}
+		if err := plugin.Remove(name); err != nil {
+			return err
+		}
+	}
+	return os.RemoveAll(filepath.Join(Root, name))
+}
+
+// Mount 为容器 id 准备数据卷，返回挂载到容器中的宿主机路径
+// local 数据卷直接使用数据目录，插件的数据卷由插件挂载
+func Mount(name, id string) (string, error) {
+	v, err := Get(name)
+	if err != nil {
+		return "", err
+	}
+	if v.Driver == DefaultDriver {
+		return v.Mountpoint, nil
+	}
+	plugin, err := GetPlugin(v.Driver)
+	if err != nil {
+		return "", err
+	}
+	return plugin.Mount(name, id)
+}
+
+// Unmount 通知数据卷的驱动容器 id 不再使用数据卷，local 数据卷不需要处理
+func Unmount(name, driver, id string) error {
+	if driver == "" || driver == DefaultDriver {
+		return nil
+	}
+	plugin, err := GetPlugin(driver)
+	if err != nil {
+		return err
+	}
+	return plugin.Unmount(name, id)
+}
+
+// NewName 为匿名数据卷生成随机名称
+func NewName() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}